@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -10,6 +11,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net"
@@ -18,6 +20,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +28,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/unixsock"
 )
 
 const (
@@ -72,6 +76,8 @@ type statusResponse struct {
 	Cache           cacheSummary              `json:"cache"`
 	CircuitBreakers map[string]breakerSummary `json:"circuit_breakers"`
 	Routes          []routeStatusSummary      `json:"routes"`
+	ConfigVersion   uint64                    `json:"config_version"`
+	LastReload      *reloadResult             `json:"last_reload"`
 }
 
 type healthSummary struct {
@@ -154,6 +160,14 @@ func checkStatus() {
 	fmt.Printf("Admin Protected: %v\n", status.AdminProtected)
 	fmt.Printf("Audit Enabled: %v\n", status.AuditEnabled)
 	fmt.Printf("Routes: %d\n", len(status.Routes))
+	fmt.Printf("Config Version: %d\n", status.ConfigVersion)
+	if reload := status.LastReload; reload != nil {
+		fmt.Printf("Last Reload: %s via %s at %s", reload.Status, reload.Source, reload.Time.Local().Format(time.RFC3339))
+		if reload.Error != "" {
+			fmt.Printf(" (%s)", reload.Error)
+		}
+		fmt.Println()
+	}
 	fmt.Printf("Cache: entries=%d bytes=%d hits=%d misses=%d\n",
 		status.Cache.TotalEntries,
 		status.Cache.TotalBytes,
@@ -191,14 +205,139 @@ func checkStatus() {
 	}
 }
 
-func reloadConfig() {
+// reloadResult is the outcome of a reload, as returned by POST /_gonk/reload
+// and shown as last_reload on /_gonk/status
+type reloadResult struct {
+	Status        string    `json:"status"`
+	Source        string    `json:"source"`
+	Time          time.Time `json:"time"`
+	Error         string    `json:"error"`
+	ConfigVersion uint64    `json:"config_version"`
+	Added         []string  `json:"added"`
+	Changed       []string  `json:"changed"`
+	Removed       []string  `json:"removed"`
+	FailedRoutes  []string  `json:"failed_routes"`
+}
+
+func reloadConfig(dryRun, viaSignal bool) error {
+	if viaSignal {
+		if dryRun {
+			return fmt.Errorf("--dry-run needs the admin API, not --signal")
+		}
+		return reloadBySignal()
+	}
+
+	path := "/_gonk/reload"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	req, err := newAdminRequest(http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach GONK: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	var result reloadResult
+	if err := json.Unmarshal(data, &result); err != nil || (resp.StatusCode >= 400 && result.Error == "") {
+		return fmt.Errorf("reload failed: HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if dryRun {
+		if result.Error != "" {
+			return fmt.Errorf("❌ Config is not valid: %s", result.Error)
+		}
+		fmt.Printf("🔍 Config is valid; reloading version %d would change:\n", result.ConfigVersion)
+		printRouteChanges(result)
+		return nil
+	}
+	return reportReload(result)
+}
+
+// reloadBySignal sends SIGHUP, then waits for the gateway to report a
+// reload newer than the signal on /_gonk/status
+func reloadBySignal() error {
 	fmt.Println("🔄 Requesting configuration reload via SIGHUP...")
-	cmd := exec.Command("pkill", "-HUP", "gonk")
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Failed to reload: %v\n", err)
+	sent := time.Now()
+	if err := exec.Command("pkill", "-HUP", "gonk").Run(); err != nil {
+		return fmt.Errorf("failed to signal gonk: %w", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var status struct {
+			LastReload *reloadResult `json:"last_reload"`
+		}
+		err := adminJSON(http.MethodGet, "/_gonk/status", nil, &status)
+		if err == nil && status.LastReload != nil && !status.LastReload.Time.Before(sent) {
+			return reportReload(*status.LastReload)
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("signal sent, but the reload could not be confirmed: %w", err)
+			}
+			return fmt.Errorf("signal sent, but GONK reported no reload within 10s")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func reportReload(result reloadResult) error {
+	switch result.Status {
+	case "failed":
+		return fmt.Errorf("❌ Reload failed, still running config version %d: %s", result.ConfigVersion, result.Error)
+	case "unchanged":
+		fmt.Printf("✅ Configuration unchanged, running version %d\n", result.ConfigVersion)
+		return nil
+	}
+
+	fmt.Printf("✅ Configuration reloaded, running version %d\n", result.ConfigVersion)
+	printRouteChanges(result)
+	if len(result.FailedRoutes) > 0 {
+		return fmt.Errorf("⚠️  Routes not set up, see the gateway log: %s", strings.Join(result.FailedRoutes, ", "))
+	}
+	return nil
+}
+
+func printRouteChanges(result reloadResult) {
+	if len(result.Added)+len(result.Changed)+len(result.Removed) == 0 {
+		fmt.Println("   no route changes")
 		return
 	}
-	fmt.Println("✅ Configuration reloaded")
+	for _, name := range result.Added {
+		fmt.Printf("   + %s\n", name)
+	}
+	for _, name := range result.Changed {
+		fmt.Printf("   ~ %s\n", name)
+	}
+	for _, name := range result.Removed {
+		fmt.Printf("   - %s\n", name)
+	}
+}
+
+func upgradeBinary(viaSignal bool) error {
+	if viaSignal {
+		if err := exec.Command("pkill", "-USR2", "gonk").Run(); err != nil {
+			return fmt.Errorf("failed to signal gonk: %w", err)
+		}
+		fmt.Println("🔁 Upgrade requested via SIGUSR2; the gateway log shows whether the new process took over")
+		return nil
+	}
+
+	var result struct {
+		PID          int    `json:"pid"`
+		DrainTimeout string `json:"drain_timeout"`
+	}
+	if err := adminJSON(http.MethodPost, "/_gonk/upgrade", nil, &result); err != nil {
+		return fmt.Errorf("❌ Upgrade failed: %w", err)
+	}
+	fmt.Printf("✅ gonk process %d took over the listening sockets\n", result.PID)
+	fmt.Printf("   The previous process drains open connections for up to %s\n", result.DrainTimeout)
+	return nil
 }
 
 // Config management
@@ -369,6 +508,10 @@ func addUpstreamDoctorFindings(cfg *config.Config, timeout time.Duration, failur
 	client := &http.Client{Timeout: timeout}
 	for _, route := range cfg.Routes {
 		for _, upstream := range route.Upstreams {
+			if socketURL, err := url.Parse(upstream.URL); err == nil && unixsock.IsUnix(socketURL) {
+				addUnixUpstreamDoctorFindings(route, upstream, unixsock.Path(socketURL), timeout, failures, warnings)
+				continue
+			}
 			if route.Protocol == "grpc" {
 				*warnings = append(*warnings, fmt.Sprintf("route %s uses grpc; live upstream probe skipped", route.Name))
 				continue
@@ -389,19 +532,53 @@ func addUpstreamDoctorFindings(cfg *config.Config, timeout time.Duration, failur
 				*failures = append(*failures, fmt.Sprintf("route %s upstream %s request build failed: %v", route.Name, checkURL, err))
 				continue
 			}
-			resp, err := client.Do(req)
-			if err != nil {
-				*failures = append(*failures, fmt.Sprintf("route %s upstream %s is unreachable: %v", route.Name, checkURL, err))
-				continue
-			}
-			resp.Body.Close()
+			addUpstreamResponseFindings(route.Name, checkURL, client, req, failures, warnings)
+		}
+	}
+}
 
-			if resp.StatusCode >= 500 {
-				*failures = append(*failures, fmt.Sprintf("route %s upstream %s returned HTTP %d", route.Name, checkURL, resp.StatusCode))
-			} else if resp.StatusCode >= 400 {
-				*warnings = append(*warnings, fmt.Sprintf("route %s upstream %s returned HTTP %d", route.Name, checkURL, resp.StatusCode))
-			}
+// addUnixUpstreamDoctorFindings probes a Unix socket upstream. gRPC upstreams
+// only get a connect check; HTTP and WebSocket upstreams get the health request.
+func addUnixUpstreamDoctorFindings(route config.Route, upstream config.Upstream, socketPath string, timeout time.Duration, failures, warnings *[]string) {
+	if route.Protocol == "grpc" {
+		conn, err := net.DialTimeout("unix", socketPath, timeout)
+		if err != nil {
+			*failures = append(*failures, fmt.Sprintf("route %s upstream %s is unreachable: %v", route.Name, upstream.URL, err))
+			return
 		}
+		conn.Close()
+		return
+	}
+
+	probePath := "/"
+	if upstream.HealthCheck != "" && !strings.HasPrefix(upstream.HealthCheck, "http://") && !strings.HasPrefix(upstream.HealthCheck, "https://") {
+		probePath = "/" + strings.TrimLeft(upstream.HealthCheck, "/")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+unixsock.Host+probePath, nil)
+	if err != nil {
+		*failures = append(*failures, fmt.Sprintf("route %s upstream %s request build failed: %v", route.Name, upstream.URL, err))
+		return
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: unixsock.Dialer(socketPath, timeout)},
+	}
+	addUpstreamResponseFindings(route.Name, strings.TrimRight(upstream.URL, "/")+probePath, client, req, failures, warnings)
+}
+
+func addUpstreamResponseFindings(routeName, checkURL string, client *http.Client, req *http.Request, failures, warnings *[]string) {
+	resp, err := client.Do(req)
+	if err != nil {
+		*failures = append(*failures, fmt.Sprintf("route %s upstream %s is unreachable: %v", routeName, checkURL, err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		*failures = append(*failures, fmt.Sprintf("route %s upstream %s returned HTTP %d", routeName, checkURL, resp.StatusCode))
+	} else if resp.StatusCode >= 400 {
+		*warnings = append(*warnings, fmt.Sprintf("route %s upstream %s returned HTTP %d", routeName, checkURL, resp.StatusCode))
 	}
 }
 
@@ -1545,6 +1722,191 @@ func clearCache() {
 	fmt.Println("✅ Cache cleared")
 }
 
+// Circuit breaker management
+type circuitBreakerStatus struct {
+	Route          string                 `json:"route"`
+	Scope          string                 `json:"scope"`
+	CircuitBreaker circuitBreakerDetail   `json:"circuit_breaker"`
+	Upstreams      []circuitBreakerDetail `json:"upstreams"`
+}
+
+type circuitBreakerDetail struct {
+	Name        string                  `json:"name"`
+	State       string                  `json:"state"`
+	Failures    int                     `json:"failures"`
+	WindowCalls int                     `json:"window_calls"`
+	FailureRate float64                 `json:"failure_rate"`
+	Override    *circuitBreakerOverride `json:"override"`
+}
+
+type circuitBreakerOverride struct {
+	State  string     `json:"state"`
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"`
+}
+
+type circuitBreakerAction struct {
+	Upstream string `json:"upstream,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func showCircuitBreakers(route string) error {
+	path := "/_gonk/circuit-breakers"
+	if route != "" {
+		path += "/" + url.PathEscape(route)
+	}
+
+	var breakers []circuitBreakerStatus
+	if route != "" {
+		var status circuitBreakerStatus
+		if err := adminJSON(http.MethodGet, path, nil, &status); err != nil {
+			return fmt.Errorf("failed to fetch circuit breaker: %w", err)
+		}
+		breakers = append(breakers, status)
+	} else {
+		var list struct {
+			CircuitBreakers []circuitBreakerStatus `json:"circuit_breakers"`
+		}
+		if err := adminJSON(http.MethodGet, path, nil, &list); err != nil {
+			return fmt.Errorf("failed to fetch circuit breakers: %w", err)
+		}
+		breakers = list.CircuitBreakers
+	}
+
+	if len(breakers) == 0 {
+		fmt.Println("No circuit breakers configured")
+		return nil
+	}
+
+	for _, breaker := range breakers {
+		fmt.Printf("%-24s %-10s scope=%s %s\n", breaker.Route, breaker.CircuitBreaker.State, breaker.Scope, describeBreaker(breaker.CircuitBreaker))
+		for _, upstream := range breaker.Upstreams {
+			fmt.Printf("  %-40s %-10s %s\n", strings.TrimPrefix(upstream.Name, breaker.Route+"|"), upstream.State, describeBreaker(upstream))
+		}
+	}
+	return nil
+}
+
+func describeBreaker(breaker circuitBreakerDetail) string {
+	description := fmt.Sprintf("failures=%d calls=%d failure_rate=%.1f%%", breaker.Failures, breaker.WindowCalls, breaker.FailureRate)
+	if breaker.Override != nil {
+		description += " forced=" + breaker.Override.State
+		if breaker.Override.Until != nil {
+			description += " until=" + breaker.Override.Until.Local().Format(time.RFC3339)
+		}
+		if breaker.Override.Reason != "" {
+			description += fmt.Sprintf(" reason=%q", breaker.Override.Reason)
+		}
+	}
+	return description
+}
+
+// overrideCircuitBreaker runs an open, close or reset action against a route's breakers
+func overrideCircuitBreaker(route, action string, opts circuitBreakerAction) error {
+	path := fmt.Sprintf("/_gonk/circuit-breakers/%s/%s", url.PathEscape(route), action)
+
+	var status circuitBreakerStatus
+	if err := adminJSON(http.MethodPost, path, opts, &status); err != nil {
+		return fmt.Errorf("failed to %s circuit breaker for route %s: %w", action, route, err)
+	}
+
+	fmt.Printf("✅ Circuit breaker for route %s is now %s\n", route, status.CircuitBreaker.State)
+	return nil
+}
+
+// Config history
+type configHistoryEntry struct {
+	Version uint64    `json:"version"`
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Diff    string    `json:"diff"`
+}
+
+func showConfigHistory() error {
+	var history struct {
+		Version uint64               `json:"version"`
+		History []configHistoryEntry `json:"history"`
+	}
+	if err := adminJSON(http.MethodGet, "/_gonk/config/history", nil, &history); err != nil {
+		return fmt.Errorf("failed to fetch config history: %w", err)
+	}
+
+	if len(history.History) == 0 {
+		fmt.Println("No config history recorded")
+		return nil
+	}
+
+	// The running version is marked with *
+	for _, entry := range history.History {
+		marker := " "
+		if entry.Version == history.Version {
+			marker = "*"
+		}
+		hash := entry.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Printf("%s %-6d %s  %-10s %s\n", marker, entry.Version, entry.Time.Local().Format(time.RFC3339), entry.Source, hash)
+	}
+	return nil
+}
+
+func showConfigDiff(version string) error {
+	if _, err := strconv.ParseUint(version, 10, 64); err != nil {
+		return fmt.Errorf("config version must be a number, got %q", version)
+	}
+
+	var entry configHistoryEntry
+	if err := adminJSON(http.MethodGet, "/_gonk/config/history/"+version, nil, &entry); err != nil {
+		return fmt.Errorf("failed to fetch config version %s: %w", version, err)
+	}
+
+	if entry.Diff == "" {
+		fmt.Printf("Config version %s has no recorded diff; it is the oldest version kept\n", version)
+		return nil
+	}
+	fmt.Print(entry.Diff)
+	return nil
+}
+
+func rollbackConfig(version string) error {
+	if _, err := strconv.ParseUint(version, 10, 64); err != nil {
+		return fmt.Errorf("config version must be a number, got %q", version)
+	}
+
+	var result struct {
+		Version      uint64 `json:"version"`
+		RolledBackTo uint64 `json:"rolled_back_to"`
+	}
+	if err := adminJSON(http.MethodPost, "/_gonk/config/history/"+version+"/rollback", nil, &result); err != nil {
+		return fmt.Errorf("failed to roll back to config version %s: %w", version, err)
+	}
+
+	fmt.Printf("✅ Rolled back to config version %d, now running as version %d\n", result.RolledBackTo, result.Version)
+	return nil
+}
+
+func adminJSON(method, path string, body, out interface{}) error {
+	req, err := newAdminRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
 // Utility functions
 func printJSON(data interface{}) {
 	output, _ := json.MarshalIndent(data, "", "  ")
@@ -1629,10 +1991,22 @@ func fetchLegacyHealthStatus() (*statusResponse, error) {
 }
 
 func newAdminRequest(method, path string, body interface{}) (*http.Request, error) {
-	req, err := http.NewRequest(method, gonkEndpoint(path), nil)
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, gonkEndpoint(path), reader)
 	if err != nil {
 		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	if token := os.Getenv("GONK_ADMIN_TOKEN"); token != "" {
 		req.Header.Set("X-Gonk-Admin-Token", token)
 	}
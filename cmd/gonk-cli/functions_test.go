@@ -3,6 +3,9 @@ package main
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -200,6 +203,51 @@ routes:
 	}
 }
 
+func TestRunDoctorProbesUnixSocketUpstreams(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "historian.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	configPath := filepath.Join(dir, "gonk.yaml")
+	writeFile(t, configPath, `server:
+  listen: ":8080"
+logging:
+  level: info
+  format: text
+  output: stdout
+routes:
+  - name: historian
+    path: /historian/*
+    methods: [GET]
+    upstreams:
+      - url: unix://`+socketPath+`
+        health_check: /health
+`)
+
+	if err := runDoctor(doctorOptions{ConfigPath: configPath, CheckUpstreams: true}); err != nil {
+		t.Fatalf("runDoctor failed: %v", err)
+	}
+
+	server.Close()
+	if err := runDoctor(doctorOptions{ConfigPath: configPath, CheckUpstreams: true}); err == nil {
+		t.Fatal("runDoctor should fail when the upstream socket is unreachable")
+	}
+}
+
 func readCertificate(t *testing.T, path string) *x509.Certificate {
 	t.Helper()
 
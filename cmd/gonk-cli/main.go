@@ -30,6 +30,7 @@ func init() {
 	rootCmd.AddCommand(doctorCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(upgradeCmd)
 
 	// Configuration commands
 	rootCmd.AddCommand(initCmd)
@@ -49,6 +50,7 @@ func init() {
 	rootCmd.AddCommand(logsCmd)
 	rootCmd.AddCommand(healthCmd)
 	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(cbCmd)
 }
 
 func main() {
@@ -130,7 +132,25 @@ var reloadCmd = &cobra.Command{
 	Use:   "reload",
 	Short: "Hot reload configuration",
 	Run: func(cmd *cobra.Command, args []string) {
-		reloadConfig()
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		viaSignal, _ := cmd.Flags().GetBool("signal")
+		if err := reloadConfig(dryRun, viaSignal); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// Upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Hand the listening sockets to a newly started gonk binary (Linux)",
+	Run: func(cmd *cobra.Command, args []string) {
+		viaSignal, _ := cmd.Flags().GetBool("signal")
+		if err := upgradeBinary(viaSignal); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -160,6 +180,41 @@ var configShowCmd = &cobra.Command{
 	},
 }
 
+var configHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the configurations the gateway has applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := showConfigHistory(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configDiffCmd = &cobra.Command{
+	Use:   "diff <version>",
+	Short: "Show what a config version changed from the one before it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := showConfigDiff(args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configRollbackCmd = &cobra.Command{
+	Use:   "rollback <version>",
+	Short: "Write an earlier config version back to the config file and reload it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := rollbackConfig(args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
 // Routes command
 var routesCmd = &cobra.Command{
 	Use:   "routes",
@@ -417,6 +472,62 @@ var cacheClearCmd = &cobra.Command{
 	},
 }
 
+// Circuit breaker command
+var cbCmd = &cobra.Command{
+	Use:   "cb",
+	Short: "Circuit breaker management",
+}
+
+var cbListCmd = &cobra.Command{
+	Use:   "list [route]",
+	Short: "Show circuit breaker state for all routes or one route",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		route := ""
+		if len(args) == 1 {
+			route = args[0]
+		}
+		if err := showCircuitBreakers(route); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func newCBOverrideCmd(action, short string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   action + " <route>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			upstream, _ := cmd.Flags().GetString("upstream")
+			reason, _ := cmd.Flags().GetString("reason")
+			duration := ""
+			if cmd.Flags().Lookup("duration") != nil {
+				if d, _ := cmd.Flags().GetDuration("duration"); d > 0 {
+					duration = d.String()
+				}
+			}
+
+			if err := overrideCircuitBreaker(args[0], action, circuitBreakerAction{
+				Upstream: upstream,
+				Duration: duration,
+				Reason:   reason,
+			}); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().String("upstream", "", "Only act on this upstream of a load-balanced route")
+	cmd.Flags().String("reason", "", "Reason recorded in the audit log")
+	if action != "reset" {
+		cmd.Flags().Duration("duration", 0, "Expire the override after this duration (default: until reset)")
+	}
+	return cmd
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVar(&gonkURL, "url", defaultGonkURL, "GONK admin URL")
 
@@ -434,6 +545,13 @@ func init() {
 	doctorCmd.Flags().Duration("timeout", 2*time.Second, "Timeout for live checks")
 	doctorCmd.Flags().Int("warn-days", 30, "Warn when certificates expire within this many days")
 
+	// Reload flags
+	reloadCmd.Flags().Bool("dry-run", false, "Validate the config file and show the route changes without applying them")
+	reloadCmd.Flags().Bool("signal", false, "Send SIGHUP to a local gonk process instead of calling the admin API")
+
+	// Upgrade flags
+	upgradeCmd.Flags().Bool("signal", false, "Send SIGUSR2 to a local gonk process instead of calling the admin API")
+
 	// Init flags
 	initCmd.Flags().StringP("template", "t", "basic", "Template type (basic, industrial, microservices)")
 	initCmd.Flags().StringP("output", "o", "gonk.yaml", "Output file path")
@@ -441,6 +559,9 @@ func init() {
 	// Config flags
 	configShowCmd.Flags().StringP("config", "c", "gonk.yaml", "Configuration file path")
 	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configHistoryCmd)
+	configCmd.AddCommand(configDiffCmd)
+	configCmd.AddCommand(configRollbackCmd)
 
 	// Routes subcommands
 	routesCmd.AddCommand(routesListCmd)
@@ -520,4 +641,10 @@ func init() {
 	// Cache subcommands
 	cacheCmd.AddCommand(cacheStatsCmd)
 	cacheCmd.AddCommand(cacheClearCmd)
+
+	// Circuit breaker subcommands
+	cbCmd.AddCommand(cbListCmd)
+	cbCmd.AddCommand(newCBOverrideCmd("open", "Force a route's circuit breaker open"))
+	cbCmd.AddCommand(newCBOverrideCmd("close", "Force a route's circuit breaker closed"))
+	cbCmd.AddCommand(newCBOverrideCmd("reset", "Clear overrides and counters and close the circuit breaker"))
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/JustVugg/gonk/internal/config"
 	"github.com/JustVugg/gonk/internal/server"
@@ -23,6 +24,7 @@ func main() {
 	var (
 		configPath = flag.String("config", "gonk.yaml", "Path to configuration file")
 		validate   = flag.Bool("validate", false, "Validate configuration and exit")
+		fallback   = flag.Bool("fallback-last-good", false, "Start from the last known-good config in the history if the config file does not load")
 		version    = flag.Bool("version", false, "Show version information")
 	)
 	flag.Parse()
@@ -34,6 +36,15 @@ func main() {
 
 	// Load configuration
 	cfg, err := config.Load(*configPath)
+	if err != nil && *fallback && !*validate {
+		log.Printf("⚠️  Failed to load config: %v", err)
+		lastGood, entry, fallbackErr := config.LoadLastGood(*configPath)
+		if fallbackErr != nil {
+			log.Fatalf("No known-good config to fall back to: %v", fallbackErr)
+		}
+		log.Printf("⚠️  Starting from known-good config version %d of %s", entry.Version, entry.Time.Format(time.RFC3339))
+		cfg, err = lastGood, nil
+	}
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -50,21 +61,37 @@ func main() {
 
 	// Create and start server
 	srv := server.New(cfg)
+	srv.SetConfigPath(*configPath)
 
 	// Watch for config changes
 	if cfg.Server.HotReload {
 		go config.Watch(*configPath, func(newCfg *config.Config) {
 			log.Println("Configuration reloaded")
-			srv.Reload(newCfg)
+			srv.Reload(newCfg, server.ReloadSourceFileWatch)
+		}, func(err error) {
+			srv.ReloadFailed(server.ReloadSourceFileWatch, err)
 		})
 	}
 
+	// SIGHUP reloads the config file, whether or not hot_reload is on
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			log.Println("SIGHUP received, reloading configuration")
+			srv.ReloadFile(server.ReloadSourceSIGHUP)
+		}
+	}()
+
+	// SIGUSR2 hands the listening sockets to a new gonk process (Linux)
+	srv.HandleUpgradeSignal()
+
 	// Graceful shutdown
 	ctx, cancel := signal.NotifyContext(context.Background(),
 		os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	log.Printf("🚀 GONK v%s starting on %s", Version, cfg.Server.Listen)
+	log.Printf("🚀 GONK v%s starting", Version)
 	if err := srv.Start(ctx); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// IdentityClaims are the claims of a signed identity assertion
+type IdentityClaims struct {
+	jwt.RegisteredClaims
+	IdentityType   string   `json:"identity_type,omitempty"`
+	UserID         string   `json:"user_id,omitempty"`
+	ClientID       string   `json:"client_id,omitempty"`
+	Roles          []string `json:"roles,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	CertCommonName string   `json:"cert_cn,omitempty"`
+}
+
+const identityHeadersKey contextKey = "identity_headers"
+
+// IdentityPropagator replaces inbound identity headers with the identity
+// established by authentication
+type IdentityPropagator struct {
+	cfg    *config.IdentityConfig
+	prefix string
+	strip  []string
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// NewIdentityPropagator loads the signing key, so a route with an unusable
+// key fails at startup instead of on its first request
+func NewIdentityPropagator(cfg *config.IdentityConfig) (*IdentityPropagator, error) {
+	p := &IdentityPropagator{
+		cfg:    cfg,
+		prefix: cfg.HeaderPrefix,
+		strip:  append([]string{"X-Client-ID"}, cfg.StripHeaders...),
+	}
+	// An empty prefix would strip every header
+	if p.prefix == "" {
+		p.prefix = "X-Gonk-"
+	}
+
+	a := cfg.Assertion
+	if a == nil || !a.Enabled {
+		return p, nil
+	}
+	p.strip = append(p.strip, a.Header)
+
+	switch a.Algorithm {
+	case "RS256", "ES256":
+		pemBytes, err := os.ReadFile(a.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity assertion key: %w", err)
+		}
+		if a.Algorithm == "RS256" {
+			p.method = jwt.SigningMethodRS256
+			p.key, err = jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		} else {
+			p.method = jwt.SigningMethodES256
+			p.key, err = jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity assertion key: %w", err)
+		}
+	default:
+		p.method = jwt.SigningMethodHS256
+		p.key = []byte(a.SecretKey)
+	}
+	return p, nil
+}
+
+// Middleware must run after authentication. Requests without an
+// authenticated identity reach the upstream with no identity headers at all.
+func (p *IdentityPropagator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.ToLower(p.prefix)
+		for name := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), prefix) {
+				r.Header.Del(name)
+			}
+		}
+		for _, name := range p.strip {
+			r.Header.Del(name)
+		}
+
+		authCtx := GetAuthContext(r)
+		if !authCtx.Authenticated {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := http.Header{}
+		p.setHeader(identity, "User", authCtx.UserID)
+		p.setHeader(identity, "Client-ID", authCtx.ClientID)
+		p.setHeader(identity, "Roles", strings.Join(authCtx.Roles, ","))
+		p.setHeader(identity, "Scopes", strings.Join(authCtx.Scopes, ","))
+		p.setHeader(identity, "Identity-Type", authCtx.IdentityType)
+		p.setHeader(identity, "Cert-CN", authCtx.CertCommonName)
+		if authCtx.ClientID != "" {
+			identity.Set("X-Client-ID", authCtx.ClientID)
+		}
+
+		if p.method != nil {
+			token, err := p.sign(authCtx, time.Now())
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"failed to sign identity assertion"}`))
+				return
+			}
+			identity.Set(p.cfg.Assertion.Header, token)
+		}
+
+		for name, values := range identity {
+			r.Header[name] = values
+		}
+		ctx := context.WithValue(r.Context(), identityHeadersKey, identity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// PropagatedHeaders returns the identity headers the identity middleware set
+// on the request, for proxies that build their own upstream request instead
+// of forwarding r.Header, such as the WebSocket dialer
+func PropagatedHeaders(r *http.Request) http.Header {
+	identity, _ := r.Context().Value(identityHeadersKey).(http.Header)
+	return identity
+}
+
+func (p *IdentityPropagator) setHeader(identity http.Header, name, value string) {
+	if value != "" {
+		identity.Set(p.prefix+name, value)
+	}
+}
+
+func (p *IdentityPropagator) sign(authCtx *AuthContext, now time.Time) (string, error) {
+	a := p.cfg.Assertion
+
+	subject := authCtx.UserID
+	if subject == "" {
+		subject = authCtx.ClientID
+	}
+	if subject == "" {
+		subject = authCtx.CertCommonName
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	claims := IdentityClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    a.Issuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{a.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.TTL)),
+			ID:        hex.EncodeToString(id),
+		},
+		IdentityType:   authCtx.IdentityType,
+		UserID:         authCtx.UserID,
+		ClientID:       authCtx.ClientID,
+		Roles:          authCtx.Roles,
+		Scopes:         authCtx.Scopes,
+		CertCommonName: authCtx.CertCommonName,
+	}
+
+	token := jwt.NewWithClaims(p.method, claims)
+	if a.KeyID != "" {
+		token.Header["kid"] = a.KeyID
+	}
+	return token.SignedString(p.key)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func TestIdentityPropagatorReplacesForgedHeaders(t *testing.T) {
+	propagator, err := NewIdentityPropagator(&config.IdentityConfig{
+		Enabled:      true,
+		HeaderPrefix: "X-Gonk-",
+		Assertion: &config.IdentityAssertionConfig{
+			Enabled:   true,
+			Header:    "X-Gonk-Identity",
+			Algorithm: "HS256",
+			SecretKey: "assertion-secret",
+			Issuer:    "gonk",
+			Audience:  "historian",
+			TTL:       time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewIdentityPropagator() returned error: %v", err)
+	}
+
+	var got http.Header
+	handler := propagator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+
+	forged := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/historian", nil)
+		req.Header.Set("X-Gonk-User", "admin")
+		req.Header.Set("X-Gonk-Identity", "forged.token.value")
+		req.Header.Set("X-Client-ID", "admin-service")
+		return req
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), forged())
+	for _, name := range []string{"X-Gonk-User", "X-Gonk-Identity", "X-Client-ID"} {
+		if value := got.Get(name); value != "" {
+			t.Fatalf("anonymous request kept %s = %q", name, value)
+		}
+	}
+
+	req := StoreAuthContext(forged(), &AuthContext{
+		Authenticated:  true,
+		IdentityType:   "device",
+		ClientID:       "plc-7",
+		Roles:          []string{"device", "writer"},
+		CertCommonName: "plc-7.plant.local",
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Get("X-Gonk-User") != "" || got.Get("X-Gonk-Client-ID") != "plc-7" || got.Get("X-Client-ID") != "plc-7" ||
+		got.Get("X-Gonk-Roles") != "device,writer" || got.Get("X-Gonk-Identity-Type") != "device" ||
+		got.Get("X-Gonk-Cert-CN") != "plc-7.plant.local" {
+		t.Fatalf("identity headers = %v", got)
+	}
+
+	claims := &IdentityClaims{}
+	_, err = jwt.ParseWithClaims(got.Get("X-Gonk-Identity"), claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("assertion-secret"), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience("historian"), jwt.WithIssuer("gonk"))
+	if err != nil {
+		t.Fatalf("assertion does not verify: %v", err)
+	}
+	if claims.Subject != "plc-7" || claims.CertCommonName != "plc-7.plant.local" || claims.ExpiresAt.Sub(claims.IssuedAt.Time) != time.Minute {
+		t.Fatalf("claims = %+v", claims)
+	}
+}
+
+func TestIdentityPropagatorSignsWithECDSAKeyFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "identity.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	propagator, err := NewIdentityPropagator(&config.IdentityConfig{
+		Enabled: true,
+		Assertion: &config.IdentityAssertionConfig{
+			Enabled:        true,
+			Header:         "X-Gonk-Identity",
+			Algorithm:      "ES256",
+			PrivateKeyFile: keyPath,
+			KeyID:          "gateway-1",
+			Issuer:         "gonk",
+			Audience:       "historian",
+			TTL:            time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewIdentityPropagator() returned error: %v", err)
+	}
+
+	var assertion string
+	handler := propagator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertion = r.Header.Get("X-Gonk-Identity")
+	}))
+	req := StoreAuthContext(httptest.NewRequest(http.MethodGet, "/", nil), &AuthContext{Authenticated: true, IdentityType: "user", UserID: "alice"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	token, err := jwt.ParseWithClaims(assertion, &IdentityClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	if err != nil {
+		t.Fatalf("assertion does not verify with the public key: %v", err)
+	}
+	if token.Header["kid"] != "gateway-1" || token.Claims.(*IdentityClaims).Subject != "alice" {
+		t.Fatalf("token = %+v", token)
+	}
+}
@@ -58,6 +58,12 @@ func ValidateMTLS(r *http.Request, routeAuth *config.RouteAuth) (*AuthContext, e
     return authCtx, nil
 }
 
+// CertRole returns the role a certificate's CN maps to, or "" when the
+// mapping has no entry for it
+func CertRole(cert *x509.Certificate, mapping map[string]string) string {
+    return mapCertToRole(cert, mapping)
+}
+
 // mapCertToRole maps certificate attributes to roles using configured mapping
 func mapCertToRole(cert *x509.Certificate, mapping map[string]string) string {
     cn := cert.Subject.CommonName
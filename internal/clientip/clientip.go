@@ -0,0 +1,165 @@
+// Package clientip resolves the address of the client behind trusted proxies,
+// so rate limits, audit records, admin checks and load balancing all see the
+// same client IP.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey struct{}
+
+type resolved struct {
+	ip          string
+	peerTrusted bool
+}
+
+// Resolver believes client IP headers only when they come from a trusted proxy
+type Resolver struct {
+	trusted   []*net.IPNet
+	forwarded bool // read Forwarded (RFC 7239) instead of X-Forwarded-For
+}
+
+// NewResolver accepts IPs and CIDRs. header is "X-Forwarded-For" or
+// "Forwarded"; an empty header means X-Forwarded-For.
+func NewResolver(trustedProxies []string, header string) (*Resolver, error) {
+	trusted, err := ParseNetworks(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	return &Resolver{trusted: trusted, forwarded: strings.EqualFold(header, "Forwarded")}, nil
+}
+
+// ParseNetworks parses a list of IPs and CIDRs. A plain IP matches only itself.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Contains reports whether ip is in any of the networks
+func Contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware resolves the client IP once and stores it in the request context
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), contextKey{}, r.resolve(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// resolve walks the forwarding chain from the right, skipping trusted
+// proxies. The first address that is not a trusted proxy is the client.
+func (r *Resolver) resolve(req *http.Request) resolved {
+	peer := remoteHost(req)
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !r.isTrusted(peerIP) {
+		return resolved{ip: peer}
+	}
+
+	client := peer
+	hops := r.hops(req.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			// Obfuscated or malformed: nothing to its left can be believed
+			break
+		}
+		client = ip.String()
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+
+	return resolved{ip: client, peerTrusted: true}
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	return Contains(r.trusted, ip)
+}
+
+// hops returns the forwarding chain, client first
+func (r *Resolver) hops(header http.Header) []string {
+	var hops []string
+
+	if !r.forwarded {
+		for _, line := range header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(line, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return hops
+	}
+
+	for _, line := range header.Values("Forwarded") {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port"
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
+
+// FromRequest returns the resolved client IP. Requests that did not pass
+// through a Resolver get the host of RemoteAddr.
+func FromRequest(req *http.Request) string {
+	if res, ok := req.Context().Value(contextKey{}).(resolved); ok {
+		return res.ip
+	}
+	return remoteHost(req)
+}
+
+// PeerTrusted reports whether the TCP peer is a trusted proxy, whose
+// forwarding headers may be passed on
+func PeerTrusted(req *http.Request) bool {
+	res, ok := req.Context().Value(contextKey{}).(resolved)
+	return ok && res.peerTrusted
+}
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
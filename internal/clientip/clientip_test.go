@@ -0,0 +1,113 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolverWalksForwardingChainFromTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+		trusted    bool
+	}{
+		{
+			name:       "untrusted peer ignores headers",
+			remoteAddr: "198.51.100.7:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.1"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.5:4000",
+			want:       "10.0.0.5",
+			trusted:    true,
+		},
+		{
+			name:       "rightmost untrusted hop is the client",
+			remoteAddr: "10.0.0.5:4000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.9, 10.0.0.6"},
+			want:       "203.0.113.9",
+			trusted:    true,
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.0.5:4000",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.7, 192.168.1.1"},
+			want:       "10.0.0.7",
+			trusted:    true,
+		},
+		{
+			name:       "malformed hop stops the walk",
+			remoteAddr: "10.0.0.5:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9, garbage, 10.0.0.6"},
+			want:       "10.0.0.6",
+			trusted:    true,
+		},
+		{
+			name:       "forwarded with quoted IPv6 and port",
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.5:4000",
+			headers:    map[string]string{"Forwarded": `for=192.0.2.43, for="[2001:db8::17]:4711";proto=https`},
+			want:       "2001:db8::17",
+			trusted:    true,
+		},
+		{
+			name:       "forwarded ignores X-Forwarded-For",
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.5:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "10.0.0.5",
+			trusted:    true,
+		},
+		{
+			name:       "plain IP entry trusts one address",
+			remoteAddr: "192.168.1.1:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "203.0.113.9",
+			trusted:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1"}, tt.header)
+			if err != nil {
+				t.Fatalf("NewResolver() returned error: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			var got string
+			var trusted bool
+			resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, trusted = FromRequest(r), PeerTrusted(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want || trusted != tt.trusted {
+				t.Fatalf("client IP = %q (peer trusted %v), want %q (%v)", got, trusted, tt.want, tt.trusted)
+			}
+		})
+	}
+}
+
+func TestFromRequestFallsBackToRemoteAddr(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.7:4000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+
+	if got := FromRequest(req); got != "198.51.100.7" {
+		t.Fatalf("FromRequest() = %q, want 198.51.100.7", got)
+	}
+	if _, err := NewResolver([]string{"not-a-cidr"}, ""); err == nil {
+		t.Fatalf("NewResolver() accepted an invalid trusted proxy")
+	}
+}
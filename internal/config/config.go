@@ -13,7 +13,18 @@ type Config struct {
 	Auth      AuthConfig       `yaml:"auth,omitempty" json:"auth,omitempty"`
 	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
 	Metrics   MetricsConfig    `yaml:"metrics,omitempty" json:"metrics,omitempty"`
+	Health    HealthConfig     `yaml:"health,omitempty" json:"health,omitempty"`
 	Routes    []Route          `yaml:"routes" json:"routes"`
+
+	RateLimitStore RateLimitStoreConfig `yaml:"rate_limit_store,omitempty" json:"rate_limit_store,omitempty"`
+
+	source []byte
+}
+
+// Source returns the file contents the config was parsed from, before
+// ${VAR} expansion, or nil for a config built in code
+func (c *Config) Source() []byte {
+	return c.source
 }
 
 type RuntimeConfig struct {
@@ -26,6 +37,55 @@ type AdminConfig struct {
 	Header       string   `yaml:"header,omitempty" json:"header,omitempty"`
 	Token        string   `yaml:"token,omitempty" json:"token,omitempty"`
 	AllowedCIDRs []string `yaml:"allowed_cidrs,omitempty" json:"allowed_cidrs,omitempty"`
+	Listeners    []string `yaml:"listeners,omitempty" json:"listeners,omitempty"` // serve /_gonk admin endpoints only here
+
+	// CertRoles maps verified client certificate CNs ("ops-laptop" or
+	// "CN=ops-*") to admin roles. A mapped certificate stands in for the token.
+	CertRoles map[string]string `yaml:"cert_roles,omitempty" json:"cert_roles,omitempty"`
+
+	// Credentials give named callers only the permissions they need. Token
+	// and CertRoles without credentials keep full access.
+	Credentials []AdminCredential `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+
+	Server *AdminServerConfig `yaml:"server,omitempty" json:"server,omitempty"`
+}
+
+// AdminCredential is one way into the admin API. Exactly one of TokenSHA256,
+// CertCN and Role identifies the caller.
+type AdminCredential struct {
+	Name        string   `yaml:"name" json:"name"`
+	TokenSHA256 string   `yaml:"token_sha256,omitempty" json:"token_sha256,omitempty"` // hex SHA-256 of a token sent in admin.header
+	CertCN      string   `yaml:"cert_cn,omitempty" json:"cert_cn,omitempty"`           // verified client certificate CN, or "CN=prefix*"
+	Role        string   `yaml:"role,omitempty" json:"role,omitempty"`                 // a role of an auth.jwt token, or one mapped by cert_roles
+	Permissions []string `yaml:"permissions" json:"permissions"`
+}
+
+// Admin API permissions
+const (
+	AdminPermissionAll            = "*"
+	AdminPermissionRead           = "read"
+	AdminPermissionCacheWrite     = "cache:write"
+	AdminPermissionBreakerWrite   = "breaker:write"
+	AdminPermissionRateLimitWrite = "ratelimit:write"
+	AdminPermissionRoutesWrite    = "routes:write"
+	AdminPermissionReload         = "reload"
+)
+
+// AdminServerConfig moves the admin API off the data plane onto its own
+// address, which may be a Unix socket such as unix:///run/gonk/admin.sock
+type AdminServerConfig struct {
+	Enabled bool       `yaml:"enabled" json:"enabled"`
+	Listen  string     `yaml:"listen" json:"listen"`
+	TLS     *TLSConfig `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+type HealthConfig struct {
+	Readiness ReadinessConfig `yaml:"readiness,omitempty" json:"readiness,omitempty"`
+}
+
+type ReadinessConfig struct {
+	Mode           string   `yaml:"mode,omitempty" json:"mode,omitempty"` // all_routes, required_routes, any_route, always
+	RequiredRoutes []string `yaml:"required_routes,omitempty" json:"required_routes,omitempty"`
 }
 
 type AuditConfig struct {
@@ -33,14 +93,88 @@ type AuditConfig struct {
 }
 
 type ServerConfig struct {
-	Listen       string        `yaml:"listen" json:"listen"`
-	HTTP2        bool          `yaml:"http2" json:"http2"`
-	HotReload    bool          `yaml:"hot_reload" json:"hot_reload"`
-	ReadTimeout  time.Duration `yaml:"read_timeout" json:"read_timeout"`
-	WriteTimeout time.Duration `yaml:"write_timeout" json:"write_timeout"`
-	IdleTimeout  time.Duration `yaml:"idle_timeout" json:"idle_timeout"`
-	CORS         *CORSConfig   `yaml:"cors,omitempty" json:"cors,omitempty"`
-	TLS          *TLSConfig    `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Listen       string               `yaml:"listen" json:"listen"`
+	HTTP2        bool                 `yaml:"http2" json:"http2"`
+	HotReload    bool                 `yaml:"hot_reload" json:"hot_reload"`
+	History      *ConfigHistoryConfig `yaml:"history,omitempty" json:"history,omitempty"`
+	ReadTimeout  time.Duration        `yaml:"read_timeout" json:"read_timeout"`
+	WriteTimeout time.Duration        `yaml:"write_timeout" json:"write_timeout"`
+	IdleTimeout  time.Duration        `yaml:"idle_timeout" json:"idle_timeout"`
+	DrainTimeout time.Duration        `yaml:"drain_timeout" json:"drain_timeout"` // open requests get this long to finish on shutdown or upgrade, and on a replaced route after a reload
+	CORS         *CORSConfig          `yaml:"cors,omitempty" json:"cors,omitempty"`
+	TLS          *TLSConfig           `yaml:"tls,omitempty" json:"tls,omitempty"`
+
+	// TrustedProxies lists the addresses (IPs or CIDRs) whose client IP
+	// headers are believed. Without it, the client IP is the TCP peer.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty"`
+	ClientIPHeader string   `yaml:"client_ip_header,omitempty" json:"client_ip_header,omitempty"` // "X-Forwarded-For" (default) or "Forwarded"
+
+	ProxyProtocol *ProxyProtocolConfig `yaml:"proxy_protocol,omitempty" json:"proxy_protocol,omitempty"`
+
+	// Listeners replace Listen, HTTP2, TLS and ProxyProtocol above with
+	// several addresses, each with its own settings
+	Listeners []ListenerConfig `yaml:"listeners,omitempty" json:"listeners,omitempty"`
+}
+
+// ConfigHistoryConfig keeps every applied config file on disk, for rollback
+// and to start from the last known-good one
+type ConfigHistoryConfig struct {
+	Enabled    bool   `yaml:"enabled" json:"enabled"`
+	Dir        string `yaml:"dir,omitempty" json:"dir,omitempty"`                 // default: .gonk-history next to the config file
+	MaxEntries int    `yaml:"max_entries,omitempty" json:"max_entries,omitempty"` // default: 20
+}
+
+// ListenerConfig is one address the gateway serves. Timeouts left empty use
+// the server's.
+type ListenerConfig struct {
+	Name          string               `yaml:"name" json:"name"`
+	Listen        string               `yaml:"listen" json:"listen"`
+	HTTP2         bool                 `yaml:"http2,omitempty" json:"http2,omitempty"` // cleartext HTTP/2 (h2c); TLS listeners negotiate HTTP/2 anyway
+	ReadTimeout   time.Duration        `yaml:"read_timeout,omitempty" json:"read_timeout,omitempty"`
+	WriteTimeout  time.Duration        `yaml:"write_timeout,omitempty" json:"write_timeout,omitempty"`
+	IdleTimeout   time.Duration        `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+	TLS           *TLSConfig           `yaml:"tls,omitempty" json:"tls,omitempty"`
+	ProxyProtocol *ProxyProtocolConfig `yaml:"proxy_protocol,omitempty" json:"proxy_protocol,omitempty"`
+}
+
+// EffectiveListeners returns the configured listeners, or a single listener
+// named "default" built from the server settings
+func (s ServerConfig) EffectiveListeners() []ListenerConfig {
+	listeners := s.Listeners
+	if len(listeners) == 0 {
+		listeners = []ListenerConfig{{
+			Name:          "default",
+			Listen:        s.Listen,
+			HTTP2:         s.HTTP2,
+			TLS:           s.TLS,
+			ProxyProtocol: s.ProxyProtocol,
+		}}
+	}
+
+	effective := make([]ListenerConfig, len(listeners))
+	for i, listener := range listeners {
+		if listener.ReadTimeout == 0 {
+			listener.ReadTimeout = s.ReadTimeout
+		}
+		if listener.WriteTimeout == 0 {
+			listener.WriteTimeout = s.WriteTimeout
+		}
+		if listener.IdleTimeout == 0 {
+			listener.IdleTimeout = s.IdleTimeout
+		}
+		effective[i] = listener
+	}
+	return effective
+}
+
+// ProxyProtocolConfig reads the client address from a PROXY protocol v1 or v2
+// header that an L4 load balancer sends ahead of the connection data
+type ProxyProtocolConfig struct {
+	Enabled bool          `yaml:"enabled" json:"enabled"`
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"` // default 5s
+	// AllowedSources must send a header; other peers are served as plain
+	// connections. When empty, every connection must start with a header.
+	AllowedSources []string `yaml:"allowed_sources,omitempty" json:"allowed_sources,omitempty"`
 }
 
 type TLSConfig struct {
@@ -98,6 +232,43 @@ type RateLimitConfig struct {
 	RequestsPerSecond int    `yaml:"requests_per_second" json:"requests_per_second"`
 	Burst             int    `yaml:"burst" json:"burst"`
 	By                string `yaml:"by" json:"by"` // "ip" or "client_id"
+
+	Algorithm string        `yaml:"algorithm,omitempty" json:"algorithm,omitempty"` // "gcra" (default), "sliding_window" or "fixed_window"
+	Window    time.Duration `yaml:"window,omitempty" json:"window,omitempty"`       // window length, allows requests_per_second per second of window
+
+	// Key builds the client key from several parts and replaces By, e.g.
+	// [auth.client_id, method] or [ip, "header:X-Device-ID", "path:device"]
+	Key []string `yaml:"key,omitempty" json:"key,omitempty"`
+
+	// Limits are stacked on top of the limit above; a request must pass all of them
+	Limits []RateLimitRule `yaml:"limits,omitempty" json:"limits,omitempty"`
+}
+
+// RateLimitRule is an additional limit of Requests per Period, such as a
+// daily quota per device
+type RateLimitRule struct {
+	Name      string        `yaml:"name" json:"name"`
+	Requests  int           `yaml:"requests" json:"requests"`
+	Period    time.Duration `yaml:"period" json:"period"`
+	Burst     int           `yaml:"burst,omitempty" json:"burst,omitempty"`         // gcra only
+	Algorithm string        `yaml:"algorithm,omitempty" json:"algorithm,omitempty"` // default "fixed_window"
+	Key       []string      `yaml:"key,omitempty" json:"key,omitempty"`             // default: the parent key
+}
+
+// RateLimitStoreConfig selects where rate limit state lives. A shared Redis
+// store lets redundant gateways enforce one limit together.
+type RateLimitStoreConfig struct {
+	Type            string        `yaml:"type,omitempty" json:"type,omitempty"`                         // "memory" (default) or "redis"
+	CleanupInterval time.Duration `yaml:"cleanup_interval,omitempty" json:"cleanup_interval,omitempty"` // memory: how often idle keys are evicted
+	PersistPath     string        `yaml:"persist_path,omitempty" json:"persist_path,omitempty"`         // memory: file that keeps long-window quotas across restarts
+	PersistInterval time.Duration `yaml:"persist_interval,omitempty" json:"persist_interval,omitempty"` // memory: how often quotas are written
+	PersistMinTTL   time.Duration `yaml:"persist_min_ttl,omitempty" json:"persist_min_ttl,omitempty"`   // memory: shortest key lifetime worth persisting
+	Address         string        `yaml:"address,omitempty" json:"address,omitempty"`                   // redis: host:port
+	Password        string        `yaml:"password,omitempty" json:"password,omitempty"`
+	DB              int           `yaml:"db,omitempty" json:"db,omitempty"`
+	KeyPrefix       string        `yaml:"key_prefix,omitempty" json:"key_prefix,omitempty"`
+	Timeout         time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	PoolSize        int           `yaml:"pool_size,omitempty" json:"pool_size,omitempty"`
 }
 
 type MetricsConfig struct {
@@ -112,15 +283,30 @@ type Route struct {
 	Upstream       string                `yaml:"upstream,omitempty" json:"upstream,omitempty"`
 	Upstreams      []Upstream            `yaml:"upstreams,omitempty" json:"upstreams,omitempty"`
 	LoadBalancing  *LoadBalancingConfig  `yaml:"load_balancing,omitempty" json:"load_balancing,omitempty"`
+	Discovery      *DiscoveryConfig      `yaml:"discovery,omitempty" json:"discovery,omitempty"`
 	Protocol       string                `yaml:"protocol,omitempty" json:"protocol,omitempty"`
 	StripPath      bool                  `yaml:"strip_path" json:"strip_path"`
+	Rewrite        *RewriteConfig        `yaml:"rewrite,omitempty" json:"rewrite,omitempty"`
 	Auth           *RouteAuth            `yaml:"auth,omitempty" json:"auth,omitempty"`
+	Identity       *IdentityConfig       `yaml:"identity,omitempty" json:"identity,omitempty"`
 	RateLimit      *RateLimitConfig      `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
 	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	Concurrency    *ConcurrencyConfig    `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
 	Cache          *CacheConfig          `yaml:"cache,omitempty" json:"cache,omitempty"`
 	Transform      *TransformConfig      `yaml:"transform,omitempty" json:"transform,omitempty"`
 	Headers        map[string]string     `yaml:"headers,omitempty" json:"headers,omitempty"`
 	Timeout        *TimeoutConfig        `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Listeners      []string              `yaml:"listeners,omitempty" json:"listeners,omitempty"` // default: every listener
+}
+
+// RewriteConfig changes the path sent upstream and replaces strip_path. Path
+// is a template where {var} is a path variable of the route and a trailing *
+// is the rest of the request path. Regex rewrites with Replacement, which may
+// use capture groups ($1, ${name}) and {var} path variables.
+type RewriteConfig struct {
+	Path        string `yaml:"path,omitempty" json:"path,omitempty"`
+	Regex       string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
 }
 
 type Upstream struct {
@@ -135,6 +321,18 @@ type LoadBalancingConfig struct {
 	HealthCheckTimeout  time.Duration `yaml:"health_check_timeout,omitempty" json:"health_check_timeout,omitempty"`
 }
 
+type DiscoveryConfig struct {
+	Type        string        `yaml:"type" json:"type"`                     // dns_srv, dns_a, file
+	Name        string        `yaml:"name,omitempty" json:"name,omitempty"` // DNS name to resolve
+	Port        int           `yaml:"port,omitempty" json:"port,omitempty"` // required for dns_a
+	Scheme      string        `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+	File        string        `yaml:"file,omitempty" json:"file,omitempty"`         // JSON or YAML endpoint file
+	Resolver    string        `yaml:"resolver,omitempty" json:"resolver,omitempty"` // optional DNS server host:port
+	Interval    time.Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout     time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	HealthCheck string        `yaml:"health_check,omitempty" json:"health_check,omitempty"`
+}
+
 type RouteAuth struct {
 	Type              string            `yaml:"type" json:"type"` // "jwt", "api_key", "mtls", "none"
 	Required          bool              `yaml:"required" json:"required"`
@@ -146,6 +344,29 @@ type RouteAuth struct {
 	RequireEither     []string          `yaml:"require_either,omitempty" json:"require_either,omitempty"` // ["client_cert", "jwt"]
 }
 
+// IdentityConfig passes the authenticated identity to the upstream. Inbound
+// headers that could carry a forged identity are always removed first.
+type IdentityConfig struct {
+	Enabled      bool                     `yaml:"enabled" json:"enabled"`
+	HeaderPrefix string                   `yaml:"header_prefix,omitempty" json:"header_prefix,omitempty"` // default "X-Gonk-"
+	StripHeaders []string                 `yaml:"strip_headers,omitempty" json:"strip_headers,omitempty"` // more inbound headers to remove
+	Assertion    *IdentityAssertionConfig `yaml:"assertion,omitempty" json:"assertion,omitempty"`
+}
+
+// IdentityAssertionConfig mints a short-lived signed JWT describing the
+// identity, so upstreams can verify it without trusting the network
+type IdentityAssertionConfig struct {
+	Enabled        bool          `yaml:"enabled" json:"enabled"`
+	Header         string        `yaml:"header,omitempty" json:"header,omitempty"`       // default "<prefix>Identity"
+	Algorithm      string        `yaml:"algorithm,omitempty" json:"algorithm,omitempty"` // "HS256" (default), "RS256" or "ES256"
+	SecretKey      string        `yaml:"secret_key,omitempty" json:"secret_key,omitempty"`
+	PrivateKeyFile string        `yaml:"private_key_file,omitempty" json:"private_key_file,omitempty"` // PEM, for RS256 and ES256
+	KeyID          string        `yaml:"key_id,omitempty" json:"key_id,omitempty"`
+	Issuer         string        `yaml:"issuer,omitempty" json:"issuer,omitempty"`     // default "gonk"
+	Audience       string        `yaml:"audience,omitempty" json:"audience,omitempty"` // default: the route name
+	TTL            time.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`           // default 60s
+}
+
 type Permission struct {
 	Role         string   `yaml:"role,omitempty" json:"role,omitempty"`
 	IdentityType string   `yaml:"identity_type,omitempty" json:"identity_type,omitempty"` // "device", "user"
@@ -155,9 +376,51 @@ type Permission struct {
 
 type CircuitBreakerConfig struct {
 	Enabled         bool          `yaml:"enabled" json:"enabled"`
-	MaxFailures     int           `yaml:"max_failures" json:"max_failures"`
+	MaxFailures     int           `yaml:"max_failures" json:"max_failures"` // consecutive failures, 0 disables
 	ResetTimeout    time.Duration `yaml:"reset_timeout" json:"reset_timeout"`
 	HalfOpenMaxReqs int           `yaml:"half_open_max_reqs" json:"half_open_max_reqs"`
+
+	// Sliding window used for the failure and slow-call rates
+	WindowType            string        `yaml:"window_type,omitempty" json:"window_type,omitempty"`         // "count" or "time"
+	WindowSize            int           `yaml:"window_size,omitempty" json:"window_size,omitempty"`         // calls, for count windows
+	WindowDuration        time.Duration `yaml:"window_duration,omitempty" json:"window_duration,omitempty"` // for time windows
+	MinimumRequests       int           `yaml:"minimum_requests,omitempty" json:"minimum_requests,omitempty"`
+	FailureRateThreshold  float64       `yaml:"failure_rate_threshold,omitempty" json:"failure_rate_threshold,omitempty"` // percent
+	SlowCallDuration      time.Duration `yaml:"slow_call_duration,omitempty" json:"slow_call_duration,omitempty"`
+	SlowCallRateThreshold float64       `yaml:"slow_call_rate_threshold,omitempty" json:"slow_call_rate_threshold,omitempty"` // percent
+	FailureStatusCodes    []int         `yaml:"failure_status_codes,omitempty" json:"failure_status_codes,omitempty"`         // default: any 5xx
+}
+
+// ConcurrencyConfig bounds the requests a route may have in flight, so a slow
+// upstream cannot hold every goroutine and file descriptor of the gateway
+type ConcurrencyConfig struct {
+	Enabled        bool          `yaml:"enabled" json:"enabled"`
+	MaxInFlight    int           `yaml:"max_in_flight,omitempty" json:"max_in_flight,omitempty"`       // 0 leaves the route unbounded
+	MaxQueue       int           `yaml:"max_queue,omitempty" json:"max_queue,omitempty"`               // requests waiting for a slot
+	QueueTimeout   time.Duration `yaml:"queue_timeout,omitempty" json:"queue_timeout,omitempty"`       // longest wait for a slot
+	MaxPerUpstream int           `yaml:"max_per_upstream,omitempty" json:"max_per_upstream,omitempty"` // 0 leaves upstreams unbounded
+	RetryAfter     time.Duration `yaml:"retry_after,omitempty" json:"retry_after,omitempty"`           // Retry-After on rejections
+
+	Adaptive *AdaptiveConcurrencyConfig `yaml:"adaptive,omitempty" json:"adaptive,omitempty"`
+}
+
+// AdaptiveConcurrencyConfig adjusts a route's in-flight limit from observed
+// latency instead of a fixed number
+type AdaptiveConcurrencyConfig struct {
+	Enabled      bool   `yaml:"enabled" json:"enabled"`
+	Algorithm    string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"` // "aimd" or "gradient"
+	InitialLimit int    `yaml:"initial_limit,omitempty" json:"initial_limit,omitempty"`
+	MinLimit     int    `yaml:"min_limit,omitempty" json:"min_limit,omitempty"`
+	MaxLimit     int    `yaml:"max_limit,omitempty" json:"max_limit,omitempty"`
+
+	// AIMD: grow by one while latency stays under the threshold, shrink by
+	// the backoff ratio on slow or failed calls
+	LatencyThreshold time.Duration `yaml:"latency_threshold,omitempty" json:"latency_threshold,omitempty"`
+	BackoffRatio     float64       `yaml:"backoff_ratio,omitempty" json:"backoff_ratio,omitempty"`
+
+	// Gradient: shrink as latency rises above its long-term average
+	Tolerance float64 `yaml:"tolerance,omitempty" json:"tolerance,omitempty"` // allowed latency growth, e.g. 1.5
+	Smoothing float64 `yaml:"smoothing,omitempty" json:"smoothing,omitempty"` // weight of each new limit, 0-1
 }
 
 type CacheConfig struct {
@@ -174,6 +437,21 @@ type TransformConfig struct {
 type TransformRule struct {
 	AddHeaders    map[string]string `yaml:"add_headers,omitempty" json:"add_headers,omitempty"`
 	RemoveHeaders []string          `yaml:"remove_headers,omitempty" json:"remove_headers,omitempty"`
+
+	// JSON body operations, applied in the order rename, remove, set. Fields
+	// are JSON pointers ("/device/id") or dotted paths ("device.id"). String
+	// values of set_fields support the same variables as add_headers.
+	RenameFields map[string]string      `yaml:"rename_fields,omitempty" json:"rename_fields,omitempty"`
+	RemoveFields []string               `yaml:"remove_fields,omitempty" json:"remove_fields,omitempty"`
+	SetFields    map[string]interface{} `yaml:"set_fields,omitempty" json:"set_fields,omitempty"`
+	MaxBodySize  int64                  `yaml:"max_body_size,omitempty" json:"max_body_size,omitempty"` // bodies beyond this pass through untouched; default 1MB
+
+	// Query and method operations apply to requests only. Query parameters
+	// are renamed, removed and then added; add_query replaces existing values.
+	RenameQuery map[string]string `yaml:"rename_query,omitempty" json:"rename_query,omitempty"`
+	RemoveQuery []string          `yaml:"remove_query,omitempty" json:"remove_query,omitempty"`
+	AddQuery    map[string]string `yaml:"add_query,omitempty" json:"add_query,omitempty"`
+	Method      string            `yaml:"method,omitempty" json:"method,omitempty"` // overrides the request method, e.g. POST
 }
 
 type TimeoutConfig struct {
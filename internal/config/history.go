@@ -0,0 +1,236 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultHistoryDir is created next to the config file
+const DefaultHistoryDir = ".gonk-history"
+
+// ErrNoHistory is returned when the history has no entry to use
+var ErrNoHistory = errors.New("no config history")
+
+// HistoryEntry is one applied config file. Content is the file as written,
+// with ${VAR} references unexpanded.
+type HistoryEntry struct {
+	Version uint64    `json:"version"`
+	Hash    string    `json:"hash"` // hex SHA-256 of Content
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`         // what applied it: startup, file_watch, sighup, admin_api, rollback
+	Diff    string    `json:"diff,omitempty"` // unified diff against the previous entry
+	Content string    `json:"content,omitempty"`
+}
+
+// History keeps the last applied config files in a directory, one JSON file
+// per version. Versions keep counting across restarts.
+type History struct {
+	dir        string
+	maxEntries int
+	mu         sync.Mutex
+}
+
+// NewHistory opens the history in dir. maxEntries <= 0 keeps every entry.
+func NewHistory(dir string, maxEntries int) *History {
+	return &History{dir: dir, maxEntries: maxEntries}
+}
+
+// HistoryDir returns where the history of the config file at configPath is
+// kept
+func HistoryDir(configPath string, cfg *ConfigHistoryConfig) string {
+	if cfg != nil && cfg.Dir != "" {
+		return cfg.Dir
+	}
+	return filepath.Join(filepath.Dir(configPath), DefaultHistoryDir)
+}
+
+// Record adds content as the newest entry. Content equal to the newest
+// entry is not recorded again; that entry is returned instead.
+func (h *History) Record(source string, content []byte) (*HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sum := sha256.Sum256(content)
+	entry := &HistoryEntry{
+		Version: 1,
+		Hash:    hex.EncodeToString(sum[:]),
+		Time:    time.Now().UTC(),
+		Source:  source,
+		Content: string(content),
+	}
+
+	versions, err := h.versions()
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		previous, err := h.read(versions[len(versions)-1])
+		if err != nil {
+			return nil, err
+		}
+		if previous.Hash == entry.Hash {
+			return previous, nil
+		}
+		entry.Version = previous.Version + 1
+		entry.Diff = Diff([]byte(previous.Content), content, versionLabel(previous.Version), versionLabel(entry.Version))
+	}
+
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config history: %w", err)
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to record config history: %w", err)
+	}
+	// Config files may hold secrets
+	if err := writeFileAtomic(h.path(entry.Version), data, 0600); err != nil {
+		return nil, err
+	}
+
+	versions = append(versions, entry.Version)
+	if h.maxEntries > 0 {
+		for len(versions) > h.maxEntries {
+			os.Remove(h.path(versions[0]))
+			versions = versions[1:]
+		}
+	}
+
+	return entry, nil
+}
+
+// Entries returns every kept entry, newest first, without content or diff
+func (h *History) Entries() ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions, err := h.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		entry, err := h.read(versions[i])
+		if err != nil {
+			return nil, err
+		}
+		entry.Diff, entry.Content = "", ""
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// Entry returns one entry with its content and diff
+func (h *History) Entry(version uint64) (*HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry, err := h.read(version)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("config version %d: %w", version, ErrNoHistory)
+	}
+	return entry, err
+}
+
+func (h *History) versions() ([]uint64, error) {
+	files, err := os.ReadDir(h.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config history: %w", err)
+	}
+
+	var versions []uint64
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if version, err := strconv.ParseUint(name, 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+func (h *History) read(version uint64) (*HistoryEntry, error) {
+	data, err := os.ReadFile(h.path(version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config history: %w", err)
+	}
+	var entry HistoryEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to read config version %d: %w", version, err)
+	}
+	return &entry, nil
+}
+
+func (h *History) path(version uint64) string {
+	return filepath.Join(h.dir, fmt.Sprintf("%010d.json", version))
+}
+
+func versionLabel(version uint64) string {
+	return "version " + strconv.FormatUint(version, 10)
+}
+
+// LoadLastGood loads the newest entry in the history of the config file at
+// path that is still a valid config. It is meant for startup, when the file
+// itself no longer loads. The history location is read from the file if it
+// still parses.
+func LoadLastGood(path string) (*Config, *HistoryEntry, error) {
+	history := NewHistory(HistoryDir(path, historySettings(path)), 0)
+
+	entries, err := history.Entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, summary := range entries {
+		entry, err := history.Entry(summary.Version)
+		if err != nil {
+			continue
+		}
+		if cfg, err := Parse(path, []byte(entry.Content)); err == nil {
+			return cfg, entry, nil
+		}
+	}
+	return nil, nil, ErrNoHistory
+}
+
+// historySettings reads server.history from a config file that may not be
+// valid
+func historySettings(path string) *ConfigHistoryConfig {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var partial struct {
+		Server struct {
+			History *ConfigHistoryConfig `yaml:"history" json:"history"`
+		} `yaml:"server" json:"server"`
+	}
+	dataStr := expandEnvWithDefaults(string(data))
+	if isJSON(path) {
+		err = json.Unmarshal([]byte(dataStr), &partial)
+	} else {
+		err = yaml.Unmarshal([]byte(dataStr), &partial)
+	}
+	if err != nil {
+		return nil
+	}
+	return partial.Server.History
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRecordsVersionsWithDiffs(t *testing.T) {
+	history := NewHistory(t.TempDir(), 2)
+
+	first := "server:\n  listen: \":8080\"\nroutes:\n  - name: api\n    path: /api/*\n"
+	second := "server:\n  listen: \":8080\"\nroutes:\n  - name: api\n    path: /v2/*\n"
+
+	entry, err := history.Record("startup", []byte(first))
+	if err != nil || entry.Version != 1 || entry.Diff != "" {
+		t.Fatalf("Record() = %+v, %v; want version 1 without a diff", entry, err)
+	}
+	if entry, err = history.Record("file_watch", []byte(first)); err != nil || entry.Version != 1 {
+		t.Fatalf("Record() of unchanged content = %+v, %v; want version 1 again", entry, err)
+	}
+
+	entry, err = history.Record("admin_api", []byte(second))
+	if err != nil || entry.Version != 2 {
+		t.Fatalf("Record() = %+v, %v; want version 2", entry, err)
+	}
+	wantDiff := "--- version 1\n+++ version 2\n@@ -2,4 +2,4 @@\n   listen: \":8080\"\n routes:\n   - name: api\n-    path: /api/*\n+    path: /v2/*\n"
+	if entry.Diff != wantDiff {
+		t.Fatalf("Diff = %q, want %q", entry.Diff, wantDiff)
+	}
+
+	if _, err := history.Record("rollback", []byte(first)); err != nil {
+		t.Fatalf("Record() returned error: %v", err)
+	}
+	entries, err := history.Entries()
+	if err != nil {
+		t.Fatalf("Entries() returned error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Version != 3 || entries[0].Source != "rollback" || entries[1].Version != 2 {
+		t.Fatalf("Entries() = %+v, want versions 3 and 2", entries)
+	}
+	if _, err := history.Entry(1); !errors.Is(err, ErrNoHistory) {
+		t.Fatalf("Entry(1) error = %v, want the pruned version gone", err)
+	}
+}
+
+func TestLoadLastGoodSkipsInvalidEntries(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "gonk.yaml")
+
+	good := "routes:\n  - name: api\n    path: /api/*\n    upstreams:\n      - url: http://backend:3000\n"
+	history := NewHistory(filepath.Join(dir, DefaultHistoryDir), 0)
+	if _, err := history.Record("startup", []byte(good)); err != nil {
+		t.Fatalf("Record() returned error: %v", err)
+	}
+	if _, err := history.Record("file_watch", []byte("routes: []\n")); err != nil {
+		t.Fatalf("Record() returned error: %v", err)
+	}
+
+	if err := os.WriteFile(configPath, []byte("routes:\n  - name: api\n    path: /api/*\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject a route without upstreams")
+	}
+
+	cfg, entry, err := LoadLastGood(configPath)
+	if err != nil {
+		t.Fatalf("LoadLastGood() returned error: %v", err)
+	}
+	if entry.Version != 1 || len(cfg.Routes) != 1 || !strings.Contains(string(cfg.Source()), "backend:3000") {
+		t.Fatalf("LoadLastGood() = version %d with %d routes, want version 1", entry.Version, len(cfg.Routes))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -25,13 +26,20 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	return Parse(path, data)
+}
+
+// Parse loads config file contents. The path only selects the format, so
+// edits can be validated before they are written.
+func Parse(path string, data []byte) (*Config, error) {
 	// Replace environment variables. Supports both ${VAR} and ${VAR:-fallback}.
 	dataStr := expandEnvWithDefaults(string(data))
 
 	var cfg Config
+	var err error
 
 	// Detect format by extension
-	if strings.HasSuffix(path, ".json") {
+	if isJSON(path) {
 		err = json.Unmarshal([]byte(dataStr), &cfg)
 	} else {
 		err = yaml.Unmarshal([]byte(dataStr), &cfg)
@@ -41,6 +49,8 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	cfg.source = data
+
 	// Set defaults
 	setDefaults(&cfg)
 
@@ -52,6 +62,10 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+func isJSON(path string) bool {
+	return strings.HasSuffix(path, ".json")
+}
+
 func setDefaults(cfg *Config) {
 	// Server defaults
 	if cfg.Server.Listen == "" {
@@ -74,6 +88,22 @@ func setDefaults(cfg *Config) {
 		cfg.Server.IdleTimeout = 120 * time.Second
 	}
 
+	if cfg.Server.DrainTimeout == 0 {
+		cfg.Server.DrainTimeout = 10 * time.Second
+	}
+
+	if cfg.Server.ClientIPHeader == "" {
+		cfg.Server.ClientIPHeader = "X-Forwarded-For"
+	}
+
+	if cfg.Server.History != nil && cfg.Server.History.MaxEntries == 0 {
+		cfg.Server.History.MaxEntries = 20
+	}
+
+	if pp := cfg.Server.ProxyProtocol; pp != nil && pp.Timeout == 0 {
+		pp.Timeout = 5 * time.Second
+	}
+
 	// Admin endpoint defaults
 	if cfg.Admin.Header == "" {
 		cfg.Admin.Header = "X-Gonk-Admin-Token"
@@ -85,6 +115,18 @@ func setDefaults(cfg *Config) {
 			cfg.Server.TLS.ClientAuth = "none"
 		}
 	}
+	if srv := cfg.Admin.Server; srv != nil && srv.TLS != nil && srv.TLS.Enabled && srv.TLS.ClientAuth == "" {
+		srv.TLS.ClientAuth = "none"
+	}
+	for i := range cfg.Server.Listeners {
+		listener := &cfg.Server.Listeners[i]
+		if listener.TLS != nil && listener.TLS.Enabled && listener.TLS.ClientAuth == "" {
+			listener.TLS.ClientAuth = "none"
+		}
+		if pp := listener.ProxyProtocol; pp != nil && pp.Timeout == 0 {
+			pp.Timeout = 5 * time.Second
+		}
+	}
 
 	// Logging defaults
 	if cfg.Logging.Level == "" {
@@ -107,6 +149,16 @@ func setDefaults(cfg *Config) {
 	if cfg.RateLimit != nil {
 		setRateLimitDefaults(cfg.RateLimit)
 	}
+	setRateLimitStoreDefaults(&cfg.RateLimitStore)
+
+	// Readiness defaults
+	if cfg.Health.Readiness.Mode == "" {
+		if len(cfg.Health.Readiness.RequiredRoutes) > 0 {
+			cfg.Health.Readiness.Mode = "required_routes"
+		} else {
+			cfg.Health.Readiness.Mode = "all_routes"
+		}
+	}
 
 	// Route defaults
 	for i := range cfg.Routes {
@@ -143,6 +195,24 @@ func setDefaults(cfg *Config) {
 			}
 		}
 
+		// Discovery defaults
+		if route.Discovery != nil {
+			if route.Discovery.Interval == 0 {
+				route.Discovery.Interval = 30 * time.Second
+			}
+			if route.Discovery.Timeout == 0 {
+				route.Discovery.Timeout = 5 * time.Second
+			}
+			if route.Discovery.Scheme == "" {
+				switch route.Protocol {
+				case "https", "ws", "wss":
+					route.Discovery.Scheme = route.Protocol
+				default:
+					route.Discovery.Scheme = "http"
+				}
+			}
+		}
+
 		// Set default weights if not specified
 		if len(route.Upstreams) > 0 {
 			hasWeights := false
@@ -161,21 +231,21 @@ func setDefaults(cfg *Config) {
 
 		// Circuit breaker defaults
 		if route.CircuitBreaker != nil && route.CircuitBreaker.Enabled {
-			if route.CircuitBreaker.MaxFailures == 0 {
-				route.CircuitBreaker.MaxFailures = 5
-			}
-			if route.CircuitBreaker.ResetTimeout == 0 {
-				route.CircuitBreaker.ResetTimeout = 60 * time.Second
-			}
-			if route.CircuitBreaker.HalfOpenMaxReqs == 0 {
-				route.CircuitBreaker.HalfOpenMaxReqs = 3
-			}
+			setCircuitBreakerDefaults(route.CircuitBreaker)
+		}
+
+		if route.Concurrency != nil && route.Concurrency.Enabled {
+			setConcurrencyDefaults(route.Concurrency)
 		}
 
 		if route.RateLimit != nil {
 			setRateLimitDefaults(route.RateLimit)
 		}
 
+		if route.Identity != nil && route.Identity.Enabled {
+			setIdentityDefaults(route.Identity, route.Name)
+		}
+
 		// Cache defaults
 		if route.Cache != nil && route.Cache.Enabled {
 			if route.Cache.TTL == 0 {
@@ -198,6 +268,49 @@ func setRateLimitDefaults(cfg *RateLimitConfig) {
 	if cfg.Enabled && cfg.Burst == 0 && cfg.RequestsPerSecond > 0 {
 		cfg.Burst = cfg.RequestsPerSecond
 	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = "gcra"
+	}
+	if (cfg.Algorithm == "sliding_window" || cfg.Algorithm == "fixed_window") && cfg.Window == 0 {
+		cfg.Window = time.Second
+	}
+	for i := range cfg.Limits {
+		rule := &cfg.Limits[i]
+		if rule.Algorithm == "" {
+			rule.Algorithm = "fixed_window"
+		}
+		if rule.Algorithm == "gcra" && rule.Burst == 0 {
+			rule.Burst = 1
+		}
+	}
+}
+
+func setRateLimitStoreDefaults(cfg *RateLimitStoreConfig) {
+	if cfg.Type == "" {
+		cfg.Type = "memory"
+	}
+	if cfg.CleanupInterval == 0 {
+		cfg.CleanupInterval = time.Minute
+	}
+	if cfg.PersistPath != "" {
+		if cfg.PersistInterval == 0 {
+			cfg.PersistInterval = 30 * time.Second
+		}
+		if cfg.PersistMinTTL == 0 {
+			cfg.PersistMinTTL = time.Hour
+		}
+	}
+	if cfg.Type == "redis" {
+		if cfg.KeyPrefix == "" {
+			cfg.KeyPrefix = "gonk:ratelimit:"
+		}
+		if cfg.Timeout == 0 {
+			cfg.Timeout = 250 * time.Millisecond
+		}
+		if cfg.PoolSize == 0 {
+			cfg.PoolSize = 10
+		}
+	}
 }
 
 func validate(cfg *Config) error {
@@ -210,6 +323,10 @@ func validate(cfg *Config) error {
 		return err
 	}
 
+	if err := validateServer(cfg.Server); err != nil {
+		return err
+	}
+
 	if err := validateAdmin(cfg.Admin); err != nil {
 		return err
 	}
@@ -218,24 +335,30 @@ func validate(cfg *Config) error {
 		return err
 	}
 
+	if err := validateRateLimitStore(cfg.RateLimitStore); err != nil {
+		return err
+	}
+
 	if err := validateAuth(cfg.Auth); err != nil {
 		return err
 	}
 
+	if err := validateReadiness(cfg); err != nil {
+		return err
+	}
+
 	// Validate TLS configuration
-	if cfg.Server.TLS != nil && cfg.Server.TLS.Enabled {
-		if cfg.Server.TLS.CertFile == "" {
-			return fmt.Errorf("tls enabled but cert_file not specified")
-		}
-		if cfg.Server.TLS.KeyFile == "" {
-			return fmt.Errorf("tls enabled but key_file not specified")
-		}
+	if err := validateTLS(cfg.Server.TLS); err != nil {
+		return err
+	}
 
-		validClientAuth := map[string]bool{
-			"none": true, "request": true, "require": true,
-		}
-		if !validClientAuth[cfg.Server.TLS.ClientAuth] {
-			return fmt.Errorf("invalid client_auth value: %s (must be none, request, or require)", cfg.Server.TLS.ClientAuth)
+	listenerNames, err := validateListeners(cfg.Server)
+	if err != nil {
+		return err
+	}
+	for _, name := range cfg.Admin.Listeners {
+		if !listenerNames[name] {
+			return fmt.Errorf("admin.listeners references unknown listener %s", name)
 		}
 	}
 
@@ -254,20 +377,34 @@ func validate(cfg *Config) error {
 			return fmt.Errorf("route %s: path is required", route.Name)
 		}
 
-		// Validate upstreams
-		if len(route.Upstreams) == 0 {
+		for _, name := range route.Listeners {
+			if !listenerNames[name] {
+				return fmt.Errorf("route %s: unknown listener %s", route.Name, name)
+			}
+		}
+
+		// Validate upstreams; discovered routes may start without static upstreams
+		if len(route.Upstreams) == 0 && route.Discovery == nil {
 			return fmt.Errorf("route %s: at least one upstream is required", route.Name)
 		}
 
+		if err := validateDiscovery(route); err != nil {
+			return err
+		}
+
 		for j, upstream := range route.Upstreams {
 			if upstream.URL == "" {
 				return fmt.Errorf("route %s: upstream #%d URL is required", route.Name, j)
 			}
 
 			// Validate upstream URL
-			if _, err := url.Parse(upstream.URL); err != nil {
+			parsedURL, err := url.Parse(upstream.URL)
+			if err != nil {
 				return fmt.Errorf("route %s: invalid upstream URL %s: %v", route.Name, upstream.URL, err)
 			}
+			if parsedURL.Scheme == "unix" && parsedURL.Path == "" && parsedURL.Opaque == "" {
+				return fmt.Errorf("route %s: unix upstream %s has no socket path", route.Name, upstream.URL)
+			}
 
 			if upstream.Weight < 0 {
 				return fmt.Errorf("route %s: upstream %s has invalid weight %d", route.Name, upstream.URL, upstream.Weight)
@@ -296,6 +433,26 @@ func validate(cfg *Config) error {
 			return err
 		}
 
+		if err := validateCircuitBreaker(route); err != nil {
+			return err
+		}
+
+		if err := validateConcurrency(route); err != nil {
+			return err
+		}
+
+		if err := validateTransform(route); err != nil {
+			return err
+		}
+
+		if err := validateRewrite(route); err != nil {
+			return err
+		}
+
+		if err := validateIdentity(route); err != nil {
+			return err
+		}
+
 		// Validate auth configuration
 		if route.Auth != nil {
 			validAuthTypes := map[string]bool{
@@ -333,6 +490,49 @@ func validate(cfg *Config) error {
 	return nil
 }
 
+func validateDiscovery(route Route) error {
+	discovery := route.Discovery
+	if discovery == nil {
+		return nil
+	}
+
+	if route.Protocol == "grpc" {
+		return fmt.Errorf("route %s: discovery is not supported for grpc routes", route.Name)
+	}
+
+	switch discovery.Type {
+	case "dns_srv":
+		if discovery.Name == "" {
+			return fmt.Errorf("route %s: discovery.name is required for dns_srv", route.Name)
+		}
+	case "dns_a":
+		if discovery.Name == "" {
+			return fmt.Errorf("route %s: discovery.name is required for dns_a", route.Name)
+		}
+		if discovery.Port <= 0 || discovery.Port > 65535 {
+			return fmt.Errorf("route %s: discovery.port must be between 1 and 65535 for dns_a", route.Name)
+		}
+	case "file":
+		if discovery.File == "" {
+			return fmt.Errorf("route %s: discovery.file is required for file discovery", route.Name)
+		}
+	default:
+		return fmt.Errorf("route %s: invalid discovery type %s (must be dns_srv, dns_a, or file)", route.Name, discovery.Type)
+	}
+
+	if discovery.Resolver != "" {
+		if _, _, err := net.SplitHostPort(discovery.Resolver); err != nil {
+			return fmt.Errorf("route %s: discovery.resolver must be host:port: %v", route.Name, err)
+		}
+	}
+
+	if discovery.Interval < time.Second {
+		return fmt.Errorf("route %s: discovery.interval must be at least 1s", route.Name)
+	}
+
+	return nil
+}
+
 func validateAuth(cfg AuthConfig) error {
 	if cfg.JWT != nil && cfg.JWT.Enabled {
 		if strings.TrimSpace(cfg.JWT.SecretKey) == "" {
@@ -360,6 +560,33 @@ func validateAuth(cfg AuthConfig) error {
 	return nil
 }
 
+func validateReadiness(cfg *Config) error {
+	readiness := cfg.Health.Readiness
+
+	validModes := map[string]bool{
+		"all_routes": true, "required_routes": true, "any_route": true, "always": true,
+	}
+	if !validModes[readiness.Mode] {
+		return fmt.Errorf("invalid health.readiness.mode value: %s", readiness.Mode)
+	}
+
+	if readiness.Mode == "required_routes" && len(readiness.RequiredRoutes) == 0 {
+		return fmt.Errorf("health.readiness.mode is required_routes but no required_routes are configured")
+	}
+
+	routeNames := make(map[string]bool, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		routeNames[route.Name] = true
+	}
+	for _, name := range readiness.RequiredRoutes {
+		if !routeNames[name] {
+			return fmt.Errorf("health.readiness.required_routes references unknown route %s", name)
+		}
+	}
+
+	return nil
+}
+
 func validateRuntime(cfg *Config) error {
 	validEnvironments := map[string]bool{
 		"development": true,
@@ -382,9 +609,138 @@ func validateRuntime(cfg *Config) error {
 	return nil
 }
 
+func validateTLS(tls *TLSConfig) error {
+	if tls == nil || !tls.Enabled {
+		return nil
+	}
+
+	if tls.CertFile == "" {
+		return fmt.Errorf("tls enabled but cert_file not specified")
+	}
+	if tls.KeyFile == "" {
+		return fmt.Errorf("tls enabled but key_file not specified")
+	}
+
+	validClientAuth := map[string]bool{
+		"none": true, "request": true, "require": true,
+	}
+	if !validClientAuth[tls.ClientAuth] {
+		return fmt.Errorf("invalid client_auth value: %s (must be none, request, or require)", tls.ClientAuth)
+	}
+	return nil
+}
+
+// validateListeners returns the names routes and the admin API can bind to
+func validateListeners(cfg ServerConfig) (map[string]bool, error) {
+	names := make(map[string]bool, len(cfg.Listeners))
+	addresses := make(map[string]bool, len(cfg.Listeners))
+
+	for i, listener := range cfg.Listeners {
+		if listener.Name == "" {
+			return nil, fmt.Errorf("listener #%d: name is required", i)
+		}
+		if names[listener.Name] {
+			return nil, fmt.Errorf("listener %s: duplicate name", listener.Name)
+		}
+		names[listener.Name] = true
+
+		if listener.Listen == "" {
+			return nil, fmt.Errorf("listener %s: listen address is required", listener.Name)
+		}
+		if addresses[listener.Listen] {
+			return nil, fmt.Errorf("listener %s: address %s is used by another listener", listener.Name, listener.Listen)
+		}
+		addresses[listener.Listen] = true
+
+		if listener.ReadTimeout < 0 || listener.WriteTimeout < 0 || listener.IdleTimeout < 0 {
+			return nil, fmt.Errorf("listener %s: timeouts cannot be negative", listener.Name)
+		}
+		if err := validateTLS(listener.TLS); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", listener.Name, err)
+		}
+		if err := validateProxyProtocol(listener.ProxyProtocol); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", listener.Name, err)
+		}
+	}
+
+	if len(cfg.Listeners) == 0 {
+		names["default"] = true
+	}
+	return names, nil
+}
+
+func validateServer(cfg ServerConfig) error {
+	for _, trusted := range cfg.TrustedProxies {
+		if ip := net.ParseIP(trusted); ip != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(trusted); err != nil {
+			return fmt.Errorf("invalid server.trusted_proxies value %q", trusted)
+		}
+	}
+
+	if cfg.ClientIPHeader != "" && !strings.EqualFold(cfg.ClientIPHeader, "X-Forwarded-For") && !strings.EqualFold(cfg.ClientIPHeader, "Forwarded") {
+		return fmt.Errorf("server.client_ip_header must be X-Forwarded-For or Forwarded")
+	}
+
+	if err := validateProxyProtocol(cfg.ProxyProtocol); err != nil {
+		return fmt.Errorf("server.%w", err)
+	}
+
+	if cfg.History != nil && cfg.History.MaxEntries < 1 {
+		return fmt.Errorf("server.history.max_entries must be positive")
+	}
+
+	if cfg.DrainTimeout < 0 {
+		return fmt.Errorf("server.drain_timeout must not be negative")
+	}
+
+	return nil
+}
+
+func validateProxyProtocol(pp *ProxyProtocolConfig) error {
+	if pp == nil || !pp.Enabled {
+		return nil
+	}
+
+	if pp.Timeout < 0 {
+		return fmt.Errorf("proxy_protocol.timeout cannot be negative")
+	}
+	for _, source := range pp.AllowedSources {
+		if ip := net.ParseIP(source); ip != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(source); err != nil {
+			return fmt.Errorf("invalid proxy_protocol.allowed_sources value %q", source)
+		}
+	}
+	return nil
+}
+
 func validateAdmin(cfg AdminConfig) error {
-	if cfg.RequireAuth && cfg.Token == "" {
-		return fmt.Errorf("admin.require_auth is true but admin.token is not specified")
+	if cfg.RequireAuth && cfg.Token == "" && len(cfg.CertRoles) == 0 && len(cfg.Credentials) == 0 {
+		return fmt.Errorf("admin.require_auth is true but no admin token, cert_roles or credentials are specified")
+	}
+	if len(cfg.Credentials) > 0 && !cfg.RequireAuth {
+		return fmt.Errorf("admin.credentials require admin.require_auth")
+	}
+	if err := validateAdminCredentials(cfg.Credentials); err != nil {
+		return err
+	}
+
+	if srv := cfg.Server; srv != nil && srv.Enabled {
+		if srv.Listen == "" {
+			return fmt.Errorf("admin.server.listen is required")
+		}
+		if strings.HasPrefix(srv.Listen, "unix:") && strings.TrimPrefix(strings.TrimPrefix(srv.Listen, "unix:"), "//") == "" {
+			return fmt.Errorf("admin.server.listen %s has no socket path", srv.Listen)
+		}
+		if err := validateTLS(srv.TLS); err != nil {
+			return fmt.Errorf("admin.server: %w", err)
+		}
+		if len(cfg.Listeners) > 0 {
+			return fmt.Errorf("admin.listeners cannot be combined with admin.server")
+		}
 	}
 
 	for _, allowed := range cfg.AllowedCIDRs {
@@ -399,6 +755,55 @@ func validateAdmin(cfg AdminConfig) error {
 	return nil
 }
 
+var tokenSHA256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+func validateAdminCredentials(credentials []AdminCredential) error {
+	validPermissions := map[string]bool{
+		AdminPermissionAll:            true,
+		AdminPermissionRead:           true,
+		AdminPermissionCacheWrite:     true,
+		AdminPermissionBreakerWrite:   true,
+		AdminPermissionRateLimitWrite: true,
+		AdminPermissionRoutesWrite:    true,
+		AdminPermissionReload:         true,
+	}
+
+	names := make(map[string]bool, len(credentials))
+	for i, credential := range credentials {
+		if credential.Name == "" {
+			return fmt.Errorf("admin credential #%d: name is required", i)
+		}
+		if names[credential.Name] {
+			return fmt.Errorf("admin credential %s: duplicate name", credential.Name)
+		}
+		names[credential.Name] = true
+
+		identifiers := 0
+		for _, value := range []string{credential.TokenSHA256, credential.CertCN, credential.Role} {
+			if value != "" {
+				identifiers++
+			}
+		}
+		if identifiers != 1 {
+			return fmt.Errorf("admin credential %s: set exactly one of token_sha256, cert_cn and role", credential.Name)
+		}
+		if credential.TokenSHA256 != "" && !tokenSHA256Pattern.MatchString(credential.TokenSHA256) {
+			return fmt.Errorf("admin credential %s: token_sha256 must be 64 hex characters", credential.Name)
+		}
+
+		if len(credential.Permissions) == 0 {
+			return fmt.Errorf("admin credential %s: at least one permission is required", credential.Name)
+		}
+		for _, permission := range credential.Permissions {
+			if !validPermissions[permission] {
+				return fmt.Errorf("admin credential %s: unknown permission %s", credential.Name, permission)
+			}
+		}
+	}
+
+	return nil
+}
+
 func demoSecretFindings(cfg *Config) []string {
 	var findings []string
 
@@ -415,6 +820,11 @@ func demoSecretFindings(cfg *Config) []string {
 			}
 		}
 	}
+	for _, route := range cfg.Routes {
+		if route.Identity != nil && route.Identity.Assertion != nil && isDemoSecret(route.Identity.Assertion.SecretKey) {
+			findings = append(findings, fmt.Sprintf("routes.%s.identity.assertion.secret_key", route.Name))
+		}
+	}
 
 	return findings
 }
@@ -443,6 +853,342 @@ func isDemoSecret(value string) bool {
 		strings.Contains(normalized, "your-") && strings.Contains(normalized, "-here")
 }
 
+func setCircuitBreakerDefaults(cb *CircuitBreakerConfig) {
+	rateBased := cb.FailureRateThreshold > 0 || cb.SlowCallRateThreshold > 0
+
+	// Rate-based breakers only trip on consecutive failures when asked to
+	if cb.MaxFailures == 0 && !rateBased {
+		cb.MaxFailures = 5
+	}
+	if cb.ResetTimeout == 0 {
+		cb.ResetTimeout = 60 * time.Second
+	}
+	if cb.HalfOpenMaxReqs == 0 {
+		cb.HalfOpenMaxReqs = 3
+	}
+
+	if cb.WindowType == "" {
+		cb.WindowType = "count"
+	}
+	if cb.WindowSize == 0 {
+		cb.WindowSize = 100
+	}
+	if cb.WindowDuration == 0 {
+		cb.WindowDuration = 60 * time.Second
+	}
+	if cb.MinimumRequests == 0 {
+		cb.MinimumRequests = 10
+	}
+}
+
+func validateCircuitBreaker(route Route) error {
+	cb := route.CircuitBreaker
+	if cb == nil || !cb.Enabled {
+		return nil
+	}
+
+	if cb.MaxFailures < 0 {
+		return fmt.Errorf("route %s: circuit_breaker.max_failures must not be negative", route.Name)
+	}
+	if cb.MaxFailures == 0 && cb.FailureRateThreshold == 0 && cb.SlowCallRateThreshold == 0 {
+		return fmt.Errorf("route %s: circuit_breaker needs max_failures, failure_rate_threshold or slow_call_rate_threshold", route.Name)
+	}
+
+	switch cb.WindowType {
+	case "count":
+		if cb.WindowSize < 1 {
+			return fmt.Errorf("route %s: circuit_breaker.window_size must be at least 1", route.Name)
+		}
+	case "time":
+		if cb.WindowDuration < time.Second {
+			return fmt.Errorf("route %s: circuit_breaker.window_duration must be at least 1s", route.Name)
+		}
+	default:
+		return fmt.Errorf("route %s: invalid circuit_breaker.window_type %s (must be count or time)", route.Name, cb.WindowType)
+	}
+
+	if cb.MinimumRequests < 1 {
+		return fmt.Errorf("route %s: circuit_breaker.minimum_requests must be at least 1", route.Name)
+	}
+	if cb.FailureRateThreshold < 0 || cb.FailureRateThreshold > 100 {
+		return fmt.Errorf("route %s: circuit_breaker.failure_rate_threshold must be between 0 and 100", route.Name)
+	}
+	if cb.SlowCallRateThreshold < 0 || cb.SlowCallRateThreshold > 100 {
+		return fmt.Errorf("route %s: circuit_breaker.slow_call_rate_threshold must be between 0 and 100", route.Name)
+	}
+	if cb.SlowCallRateThreshold > 0 && cb.SlowCallDuration <= 0 {
+		return fmt.Errorf("route %s: circuit_breaker.slow_call_duration is required with slow_call_rate_threshold", route.Name)
+	}
+
+	for _, code := range cb.FailureStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("route %s: invalid circuit_breaker failure status code %d", route.Name, code)
+		}
+	}
+
+	return nil
+}
+
+func setIdentityDefaults(identity *IdentityConfig, routeName string) {
+	if identity.HeaderPrefix == "" {
+		identity.HeaderPrefix = "X-Gonk-"
+	}
+
+	if a := identity.Assertion; a != nil && a.Enabled {
+		if a.Header == "" {
+			a.Header = identity.HeaderPrefix + "Identity"
+		}
+		if a.Algorithm == "" {
+			a.Algorithm = "HS256"
+		}
+		if a.Issuer == "" {
+			a.Issuer = "gonk"
+		}
+		if a.Audience == "" {
+			a.Audience = routeName
+		}
+		if a.TTL == 0 {
+			a.TTL = time.Minute
+		}
+	}
+}
+
+func setConcurrencyDefaults(cc *ConcurrencyConfig) {
+	if cc.MaxQueue > 0 && cc.QueueTimeout == 0 {
+		cc.QueueTimeout = time.Second
+	}
+	if cc.RetryAfter == 0 {
+		cc.RetryAfter = time.Second
+	}
+
+	if adaptive := cc.Adaptive; adaptive != nil && adaptive.Enabled {
+		if adaptive.Algorithm == "" {
+			adaptive.Algorithm = "aimd"
+		}
+		if adaptive.MinLimit == 0 {
+			adaptive.MinLimit = 1
+		}
+		if adaptive.MaxLimit == 0 {
+			adaptive.MaxLimit = 1000
+			if cc.MaxInFlight > 0 {
+				adaptive.MaxLimit = cc.MaxInFlight
+			}
+		}
+		if adaptive.InitialLimit == 0 {
+			adaptive.InitialLimit = 20
+		}
+		if adaptive.InitialLimit < adaptive.MinLimit {
+			adaptive.InitialLimit = adaptive.MinLimit
+		}
+		if adaptive.InitialLimit > adaptive.MaxLimit {
+			adaptive.InitialLimit = adaptive.MaxLimit
+		}
+		if adaptive.LatencyThreshold == 0 {
+			adaptive.LatencyThreshold = time.Second
+		}
+		if adaptive.BackoffRatio == 0 {
+			adaptive.BackoffRatio = 0.9
+		}
+		if adaptive.Tolerance == 0 {
+			adaptive.Tolerance = 1.5
+		}
+		if adaptive.Smoothing == 0 {
+			adaptive.Smoothing = 0.2
+		}
+	}
+}
+
+func validateConcurrency(route Route) error {
+	cc := route.Concurrency
+	if cc == nil || !cc.Enabled {
+		return nil
+	}
+
+	if cc.MaxInFlight < 0 || cc.MaxQueue < 0 || cc.MaxPerUpstream < 0 {
+		return fmt.Errorf("route %s: concurrency limits must not be negative", route.Name)
+	}
+	adaptive := cc.Adaptive != nil && cc.Adaptive.Enabled
+	if cc.MaxInFlight == 0 && cc.MaxPerUpstream == 0 && !adaptive {
+		return fmt.Errorf("route %s: concurrency needs max_in_flight, max_per_upstream or adaptive", route.Name)
+	}
+	if cc.MaxQueue > 0 && cc.MaxInFlight == 0 {
+		return fmt.Errorf("route %s: concurrency.max_queue requires max_in_flight", route.Name)
+	}
+	if cc.QueueTimeout < 0 || cc.RetryAfter < 0 {
+		return fmt.Errorf("route %s: concurrency durations must not be negative", route.Name)
+	}
+
+	if !adaptive {
+		return nil
+	}
+	a := cc.Adaptive
+	if a.Algorithm != "aimd" && a.Algorithm != "gradient" {
+		return fmt.Errorf("route %s: invalid concurrency.adaptive.algorithm %s (must be aimd or gradient)", route.Name, a.Algorithm)
+	}
+	if a.MinLimit < 1 || a.MaxLimit < a.MinLimit {
+		return fmt.Errorf("route %s: concurrency.adaptive needs 1 <= min_limit <= max_limit", route.Name)
+	}
+	if cc.MaxInFlight > 0 && a.MaxLimit > cc.MaxInFlight {
+		return fmt.Errorf("route %s: concurrency.adaptive.max_limit must not exceed max_in_flight", route.Name)
+	}
+	if a.LatencyThreshold < 0 {
+		return fmt.Errorf("route %s: concurrency.adaptive.latency_threshold must not be negative", route.Name)
+	}
+	if a.BackoffRatio <= 0 || a.BackoffRatio >= 1 {
+		return fmt.Errorf("route %s: concurrency.adaptive.backoff_ratio must be between 0 and 1", route.Name)
+	}
+	if a.Tolerance < 1 {
+		return fmt.Errorf("route %s: concurrency.adaptive.tolerance must be at least 1", route.Name)
+	}
+	if a.Smoothing <= 0 || a.Smoothing > 1 {
+		return fmt.Errorf("route %s: concurrency.adaptive.smoothing must be greater than 0 and at most 1", route.Name)
+	}
+
+	return nil
+}
+
+func validateIdentity(route Route) error {
+	identity := route.Identity
+	if identity == nil || !identity.Enabled {
+		return nil
+	}
+
+	a := identity.Assertion
+	if a == nil || !a.Enabled {
+		return nil
+	}
+	switch a.Algorithm {
+	case "HS256":
+		if a.SecretKey == "" {
+			return fmt.Errorf("route %s: identity.assertion with HS256 requires secret_key", route.Name)
+		}
+	case "RS256", "ES256":
+		if a.PrivateKeyFile == "" {
+			return fmt.Errorf("route %s: identity.assertion with %s requires private_key_file", route.Name, a.Algorithm)
+		}
+	default:
+		return fmt.Errorf("route %s: invalid identity.assertion.algorithm %s (must be HS256, RS256 or ES256)", route.Name, a.Algorithm)
+	}
+	if a.TTL < 0 {
+		return fmt.Errorf("route %s: identity.assertion.ttl must not be negative", route.Name)
+	}
+
+	return nil
+}
+
+func validateRewrite(route Route) error {
+	rw := route.Rewrite
+	if rw == nil {
+		return nil
+	}
+
+	if route.StripPath {
+		return fmt.Errorf("route %s: rewrite replaces strip_path, set only one of them", route.Name)
+	}
+	if (rw.Path == "") == (rw.Regex == "") {
+		return fmt.Errorf("route %s: rewrite needs either path or regex", route.Name)
+	}
+
+	target := rw.Path
+	if rw.Regex != "" {
+		if _, err := regexp.Compile(rw.Regex); err != nil {
+			return fmt.Errorf("route %s: invalid rewrite.regex: %v", route.Name, err)
+		}
+		if rw.Replacement == "" {
+			return fmt.Errorf("route %s: rewrite.regex requires a replacement", route.Name)
+		}
+		target = rw.Replacement
+	} else {
+		if !strings.HasPrefix(rw.Path, "/") {
+			return fmt.Errorf("route %s: rewrite.path must start with /", route.Name)
+		}
+		if strings.Contains(strings.TrimSuffix(rw.Path, "*"), "*") {
+			return fmt.Errorf("route %s: rewrite.path may only end with *", route.Name)
+		}
+		if strings.HasSuffix(rw.Path, "*") && !strings.HasSuffix(route.Path, "/*") {
+			return fmt.Errorf("route %s: rewrite.path ends with * but path %s has no wildcard", route.Name, route.Path)
+		}
+	}
+
+	vars := map[string]bool{}
+	for _, match := range routeVarPattern.FindAllStringSubmatch(route.Path, -1) {
+		vars[match[1]] = true
+	}
+	for _, match := range rewriteVarPattern.FindAllStringSubmatch(target, -1) {
+		if !vars[match[1]] {
+			return fmt.Errorf("route %s: rewrite uses {%s}, which is not a variable of path %s", route.Name, match[1], route.Path)
+		}
+	}
+
+	return nil
+}
+
+var (
+	// httpMethodPattern accepts upper case method names such as POST or PROPFIND
+	httpMethodPattern = regexp.MustCompile(`^[A-Z]+$`)
+	// routeVarPattern finds the names of gorilla/mux path variables
+	routeVarPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?::[^}]*)?\}`)
+	// rewriteVarPattern finds {name} placeholders in rewrite targets, but not
+	// ${name} references to regex capture groups
+	rewriteVarPattern = regexp.MustCompile(`(?:^|[^$])\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+func validateTransform(route Route) error {
+	if route.Transform == nil {
+		return nil
+	}
+
+	rules := map[string]*TransformRule{"request": route.Transform.Request, "response": route.Transform.Response}
+	for _, side := range []string{"request", "response"} {
+		rule := rules[side]
+		if rule == nil {
+			continue
+		}
+		if rule.MaxBodySize < 0 {
+			return fmt.Errorf("route %s: transform.%s.max_body_size must not be negative", route.Name, side)
+		}
+		if side == "response" && (len(rule.RenameQuery) > 0 || len(rule.RemoveQuery) > 0 || len(rule.AddQuery) > 0 || rule.Method != "") {
+			return fmt.Errorf("route %s: query and method transforms only apply to transform.request", route.Name)
+		}
+		if rule.Method != "" && !httpMethodPattern.MatchString(rule.Method) {
+			return fmt.Errorf("route %s: invalid transform.request.method %s", route.Name, rule.Method)
+		}
+		for from, to := range rule.RenameQuery {
+			if from == "" || to == "" {
+				return fmt.Errorf("route %s: transform.request.rename_query needs non-empty names", route.Name)
+			}
+		}
+
+		fields := append([]string{}, rule.RemoveFields...)
+		for from, to := range rule.RenameFields {
+			fields = append(fields, from, to)
+		}
+		for field := range rule.SetFields {
+			fields = append(fields, field)
+		}
+		for _, field := range fields {
+			if !validFieldPath(field) {
+				return fmt.Errorf("route %s: transform.%s has invalid field path %q", route.Name, side, field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validFieldPath accepts JSON pointers and dotted paths without empty segments
+func validFieldPath(path string) bool {
+	if strings.HasPrefix(path, "/") {
+		return len(path) > 1
+	}
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" {
+			return false
+		}
+	}
+	return true
+}
+
 func validateRateLimit(label string, cfg *RateLimitConfig) error {
 	if cfg == nil || !cfg.Enabled {
 		return nil
@@ -456,6 +1202,88 @@ func validateRateLimit(label string, cfg *RateLimitConfig) error {
 	if cfg.By != "ip" && cfg.By != "client_id" {
 		return fmt.Errorf("%s: by must be ip or client_id", label)
 	}
+	switch cfg.Algorithm {
+	case "gcra":
+	case "sliding_window", "fixed_window":
+		if cfg.Window < time.Millisecond {
+			return fmt.Errorf("%s: window must be at least 1ms", label)
+		}
+	default:
+		return fmt.Errorf("%s: algorithm must be gcra, sliding_window or fixed_window", label)
+	}
+	if err := validateRateLimitKey(label, cfg.Key); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(cfg.Limits))
+	for _, rule := range cfg.Limits {
+		if rule.Name == "" {
+			return fmt.Errorf("%s: every entry in limits needs a name", label)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("%s: duplicate limit %s", label, rule.Name)
+		}
+		names[rule.Name] = true
+
+		ruleLabel := fmt.Sprintf("%s limit %s", label, rule.Name)
+		if rule.Requests <= 0 {
+			return fmt.Errorf("%s: requests must be greater than zero", ruleLabel)
+		}
+		if rule.Period < time.Millisecond {
+			return fmt.Errorf("%s: period must be at least 1ms", ruleLabel)
+		}
+		switch rule.Algorithm {
+		case "gcra", "sliding_window", "fixed_window":
+		default:
+			return fmt.Errorf("%s: algorithm must be gcra, sliding_window or fixed_window", ruleLabel)
+		}
+		if rule.Burst < 0 {
+			return fmt.Errorf("%s: burst must not be negative", ruleLabel)
+		}
+		if err := validateRateLimitKey(ruleLabel, rule.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var rateLimitKeyParts = map[string]bool{
+	"ip": true, "client_id": true, "method": true, "route": true,
+	"auth.user_id": true, "auth.client_id": true, "auth.identity": true,
+	"auth.identity_type": true, "auth.cert_common_name": true,
+}
+
+func validateRateLimitKey(label string, parts []string) error {
+	for _, part := range parts {
+		if name, ok := strings.CutPrefix(part, "header:"); ok && name != "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(part, "path:"); ok && name != "" {
+			continue
+		}
+		if !rateLimitKeyParts[part] {
+			return fmt.Errorf("%s: unsupported key part %q", label, part)
+		}
+	}
+	return nil
+}
+
+func validateRateLimitStore(cfg RateLimitStoreConfig) error {
+	switch cfg.Type {
+	case "memory":
+		if cfg.CleanupInterval < 0 || cfg.PersistInterval < 0 || cfg.PersistMinTTL < 0 {
+			return fmt.Errorf("rate_limit_store: cleanup_interval, persist_interval and persist_min_ttl must not be negative")
+		}
+	case "redis":
+		if cfg.Address == "" {
+			return fmt.Errorf("rate_limit_store: address is required for redis")
+		}
+		if cfg.DB < 0 || cfg.PoolSize < 0 || cfg.Timeout < 0 {
+			return fmt.Errorf("rate_limit_store: db, pool_size and timeout must not be negative")
+		}
+	default:
+		return fmt.Errorf("rate_limit_store: type must be memory or redis")
+	}
 	return nil
 }
 
@@ -194,3 +194,305 @@ func repositoryRoot(t *testing.T) string {
 
 	return filepath.Clean(filepath.Join(filepath.Dir(filename), "..", ".."))
 }
+
+func TestLoadRejectsUnknownReadinessRoute(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+health:
+  readiness:
+    required_routes: [missing]
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject readiness required_routes that reference unknown routes")
+	}
+}
+
+func TestLoadRejectsUnixUpstreamWithoutSocketPath(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: historian
+    path: /historian/*
+    upstreams:
+      - url: unix://
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject unix upstreams without a socket path")
+	}
+}
+
+func TestLoadDefaultsRateBasedCircuitBreakerWithoutConsecutiveFailures(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+    circuit_breaker:
+      enabled: true
+      failure_rate_threshold: 50
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	cb := cfg.Routes[0].CircuitBreaker
+	if cb.MaxFailures != 0 {
+		t.Fatalf("max_failures = %d, want 0 for rate-based breakers", cb.MaxFailures)
+	}
+	if cb.WindowType != "count" || cb.WindowSize != 100 || cb.MinimumRequests != 10 {
+		t.Fatalf("window = %s/%d/%d, want count/100/10", cb.WindowType, cb.WindowSize, cb.MinimumRequests)
+	}
+}
+
+func TestLoadRejectsSlowCallRateWithoutDuration(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+    circuit_breaker:
+      enabled: true
+      slow_call_rate_threshold: 80
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject slow_call_rate_threshold without slow_call_duration")
+	}
+}
+
+func TestLoadRejectsConcurrencyQueueWithoutMaxInFlight(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+    concurrency:
+      enabled: true
+      max_per_upstream: 10
+      max_queue: 20
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject concurrency.max_queue without max_in_flight")
+	}
+}
+
+func TestLoadDefaultsAdaptiveConcurrencyToStaticLimit(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+    concurrency:
+      enabled: true
+      max_in_flight: 50
+      adaptive:
+        enabled: true
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	adaptive := cfg.Routes[0].Concurrency.Adaptive
+	if adaptive.Algorithm != "aimd" || adaptive.MaxLimit != 50 || adaptive.InitialLimit != 20 || adaptive.MinLimit != 1 {
+		t.Fatalf("adaptive = %+v, want aimd limited to 1-50 starting at 20", adaptive)
+	}
+}
+
+func TestLoadRejectsUnknownRateLimitKeyPart(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+    rate_limit:
+      enabled: true
+      requests_per_second: 10
+      burst: 20
+      limits:
+        - name: daily
+          requests: 50000
+          period: 24h
+          key: [auth.client_id, "cookie:session"]
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject an unknown rate limit key part")
+	}
+}
+
+func TestLoadRejectsEmptyTransformFieldPathSegment(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+    transform:
+      response:
+        remove_fields: ["debug..trace"]
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject a transform field path with an empty segment")
+	}
+}
+
+func TestLoadRejectsRewriteWithUnknownPathVariable(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+routes:
+  - name: sensors
+    path: /plant/{line}/sensors/*
+    upstreams:
+      - url: http://backend:3000
+    rewrite:
+      path: /v2/lines/{plant}/readings/*
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject a rewrite that uses an unknown path variable")
+	}
+}
+
+func TestLoadRejectsInvalidTrustedProxy(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+server:
+  trusted_proxies: ["10.0.0.0/8", "lb.internal"]
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject a trusted proxy that is not an IP or CIDR")
+	}
+}
+
+func TestLoadRejectsRouteBoundToUnknownListener(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `
+server:
+  listeners:
+    - name: devices
+      listen: ":8080"
+    - name: operators
+      listen: ":8443"
+routes:
+  - name: api
+    path: /api/*
+    listeners: [admin]
+    upstreams:
+      - url: http://backend:3000
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("Load() should reject a route bound to an unknown listener")
+	}
+}
+
+func TestLoadRejectsInvalidAdminCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		credential string
+	}{
+		{name: "unknown permission", credential: "{name: ops, role: ops, permissions: [cache:delete]}"},
+		{name: "plain token", credential: "{name: ops, token_sha256: change-me, permissions: [read]}"},
+		{name: "two identifiers", credential: "{name: ops, role: ops, cert_cn: \"CN=ops-*\", permissions: [read]}"},
+		{name: "no permissions", credential: "{name: ops, role: ops}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+			configContent := `
+admin:
+  require_auth: true
+  credentials:
+    - ` + tt.credential + `
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://backend:3000
+`
+
+			if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			if _, err := Load(configPath); err == nil {
+				t.Fatal("Load() should reject the admin credential")
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RoutesFile is a config file opened to edit its routes. Routes are kept as
+// YAML nodes, so comments and ${VAR} references elsewhere in the file are
+// written back as they were.
+type RoutesFile struct {
+	path   string
+	doc    yaml.Node
+	routes *yaml.Node
+}
+
+func OpenRoutesFile(path string) (*RoutesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	f := &RoutesFile{path: path}
+	if err := yaml.Unmarshal(data, &f.doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if f.doc.Kind != yaml.DocumentNode || len(f.doc.Content) == 0 || f.doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to parse config: not a mapping")
+	}
+
+	root := f.doc.Content[0]
+	f.routes = mappingValue(root, "routes")
+	if f.routes == nil {
+		f.routes = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "routes"}, f.routes)
+	}
+	if f.routes.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("failed to parse config: routes is not a list")
+	}
+
+	return f, nil
+}
+
+// Routes returns the routes as written, without defaults or expanded
+// variables
+func (f *RoutesFile) Routes() []*yaml.Node {
+	return f.routes.Content
+}
+
+func (f *RoutesFile) Route(name string) *yaml.Node {
+	for _, route := range f.routes.Content {
+		if RouteNodeName(route) == name {
+			return route
+		}
+	}
+	return nil
+}
+
+// PutRoute replaces the route with the same name, or appends it
+func (f *RoutesFile) PutRoute(route *yaml.Node) {
+	name := RouteNodeName(route)
+	for i, existing := range f.routes.Content {
+		if RouteNodeName(existing) == name {
+			f.routes.Content[i] = route
+			return
+		}
+	}
+	f.routes.Content = append(f.routes.Content, route)
+}
+
+func (f *RoutesFile) DeleteRoute(name string) bool {
+	for i, route := range f.routes.Content {
+		if RouteNodeName(route) == name {
+			f.routes.Content = append(f.routes.Content[:i], f.routes.Content[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal renders the file in its own format. JSON files lose their key
+// order, since they are rewritten from a map.
+func (f *RoutesFile) Marshal() ([]byte, error) {
+	if isJSON(f.path) {
+		var value interface{}
+		if err := f.doc.Decode(&value); err != nil {
+			return nil, fmt.Errorf("failed to render config: %w", err)
+		}
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to render config: %w", err)
+		}
+		return append(data, '\n'), nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&f.doc); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RouteNodeName returns the name of a route mapping node
+func RouteNodeName(route *yaml.Node) string {
+	if name := mappingValue(route, "name"); name != nil && name.Kind == yaml.ScalarNode {
+		return name.Value
+	}
+	return ""
+}
+
+// SetRouteNodeName sets the name of a route mapping node
+func SetRouteNodeName(route *yaml.Node, name string) {
+	if value := mappingValue(route, "name"); value != nil {
+		*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}
+		return
+	}
+	route.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"},
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+	}, route.Content...)
+}
+
+// RouteNodeUpstreams returns the upstreams list of a route mapping node,
+// adding an empty one if the route has none
+func RouteNodeUpstreams(route *yaml.Node) *yaml.Node {
+	upstreams := mappingValue(route, "upstreams")
+	if upstreams == nil || upstreams.Kind != yaml.SequenceNode {
+		if upstreams == nil {
+			upstreams = &yaml.Node{}
+			route.Content = append(route.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "upstreams"}, upstreams)
+		}
+		*upstreams = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
+	return upstreams
+}
+
+// UpstreamNodeURL returns the url of an upstream mapping node
+func UpstreamNodeURL(upstream *yaml.Node) string {
+	if url := mappingValue(upstream, "url"); url != nil && url.Kind == yaml.ScalarNode {
+		return url.Value
+	}
+	return ""
+}
+
+// mappingValue returns the value of key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// WriteFileAtomic replaces path with data through a rename, so readers and
+// the config watcher never see a partly written file. The file keeps its
+// permissions.
+func WriteFileAtomic(path string, data []byte) error {
+	return writeFileAtomic(path, data, 0644)
+}
+
+// writeFileAtomic creates a new file with mode
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
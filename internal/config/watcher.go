@@ -7,7 +7,9 @@ import (
     "github.com/fsnotify/fsnotify"
 )
 
-func Watch(configPath string, onChange func(*Config)) error {
+// Watch calls onChange with the new config whenever the config file
+// changes, or onError, if not nil, when the changed file does not load
+func Watch(configPath string, onChange func(*Config), onError func(error)) error {
     watcher, err := fsnotify.NewWatcher()
     if err != nil {
         return err
@@ -23,12 +25,21 @@ func Watch(configPath string, onChange func(*Config)) error {
                     return
                 }
                 
-                if event.Op&fsnotify.Write == fsnotify.Write {
+                // Only the config file itself; renames over it, as atomic
+                // saves do, arrive as Create
+                if filepath.Clean(event.Name) != filepath.Clean(configPath) {
+                    continue
+                }
+
+                if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
                     log.Println("Config file modified, reloading...")
                     
                     newConfig, err := Load(configPath)
                     if err != nil {
                         log.Printf("Failed to reload config: %v", err)
+                        if onError != nil {
+                            onError(err)
+                        }
                         continue
                     }
                     
@@ -0,0 +1,323 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// Resolver looks up the current upstream set for a route
+type Resolver interface {
+	Resolve(ctx context.Context) ([]config.Upstream, error)
+}
+
+// NewResolver builds the resolver for a discovery configuration
+func NewResolver(cfg *config.DiscoveryConfig) (Resolver, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("discovery is not configured")
+	}
+
+	scheme := cfg.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	switch cfg.Type {
+	case "dns_srv":
+		return &srvResolver{
+			name:        cfg.Name,
+			scheme:      scheme,
+			healthCheck: cfg.HealthCheck,
+			lookupSRV:   dnsResolver(cfg.Resolver).LookupSRV,
+		}, nil
+	case "dns_a":
+		return &addressResolver{
+			name:        cfg.Name,
+			port:        cfg.Port,
+			scheme:      scheme,
+			healthCheck: cfg.HealthCheck,
+			lookupIP:    dnsResolver(cfg.Resolver).LookupIP,
+		}, nil
+	case "file":
+		return &fileResolver{path: cfg.File, healthCheck: cfg.HealthCheck}, nil
+	default:
+		return nil, fmt.Errorf("unsupported discovery type %q", cfg.Type)
+	}
+}
+
+// dnsResolver returns the system resolver, or one pinned to a specific DNS server
+func dnsResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// srvResolver resolves SRV records, keeping only the lowest priority targets
+type srvResolver struct {
+	name        string
+	scheme      string
+	healthCheck string
+	lookupSRV   func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (r *srvResolver) Resolve(ctx context.Context) ([]config.Upstream, error) {
+	_, records, err := r.lookupSRV(ctx, "", "", r.name)
+	if err != nil {
+		return nil, fmt.Errorf("SRV lookup for %s failed: %w", r.name, err)
+	}
+
+	var priority uint16
+	for i, record := range records {
+		if i == 0 || record.Priority < priority {
+			priority = record.Priority
+		}
+	}
+
+	upstreams := make([]config.Upstream, 0, len(records))
+	for _, record := range records {
+		if record.Priority != priority {
+			continue
+		}
+
+		weight := int(record.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+
+		host := strings.TrimSuffix(record.Target, ".")
+		upstreams = append(upstreams, config.Upstream{
+			URL:         fmt.Sprintf("%s://%s", r.scheme, net.JoinHostPort(host, strconv.Itoa(int(record.Port)))),
+			Weight:      weight,
+			HealthCheck: r.healthCheck,
+		})
+	}
+
+	return sortUpstreams(upstreams), nil
+}
+
+// addressResolver resolves A and AAAA records to a fixed port
+type addressResolver struct {
+	name        string
+	port        int
+	scheme      string
+	healthCheck string
+	lookupIP    func(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+func (r *addressResolver) Resolve(ctx context.Context) ([]config.Upstream, error) {
+	ips, err := r.lookupIP(ctx, "ip", r.name)
+	if err != nil {
+		return nil, fmt.Errorf("address lookup for %s failed: %w", r.name, err)
+	}
+
+	upstreams := make([]config.Upstream, 0, len(ips))
+	for _, ip := range ips {
+		upstreams = append(upstreams, config.Upstream{
+			URL:         fmt.Sprintf("%s://%s", r.scheme, net.JoinHostPort(ip.String(), strconv.Itoa(r.port))),
+			Weight:      100,
+			HealthCheck: r.healthCheck,
+		})
+	}
+
+	return sortUpstreams(upstreams), nil
+}
+
+// fileResolver reads upstreams from a JSON or YAML file. The file may hold a
+// bare list of upstreams or an object with an "upstreams" list.
+type fileResolver struct {
+	path        string
+	healthCheck string
+}
+
+type endpointFile struct {
+	Upstreams []config.Upstream `yaml:"upstreams" json:"upstreams"`
+}
+
+func (r *fileResolver) Resolve(ctx context.Context) ([]config.Upstream, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read endpoint file: %w", err)
+	}
+
+	// YAML is a superset of JSON, so one decoder handles both formats
+	var upstreams []config.Upstream
+	if err := yaml.Unmarshal(data, &upstreams); err != nil {
+		var file endpointFile
+		if fileErr := yaml.Unmarshal(data, &file); fileErr != nil {
+			return nil, fmt.Errorf("failed to parse endpoint file: %w", fileErr)
+		}
+		upstreams = file.Upstreams
+	}
+
+	for i := range upstreams {
+		if upstreams[i].URL == "" {
+			return nil, fmt.Errorf("endpoint file entry #%d has no url", i)
+		}
+		if upstreams[i].Weight == 0 {
+			upstreams[i].Weight = 100
+		}
+		if upstreams[i].HealthCheck == "" {
+			upstreams[i].HealthCheck = r.healthCheck
+		}
+	}
+
+	return sortUpstreams(upstreams), nil
+}
+
+func sortUpstreams(upstreams []config.Upstream) []config.Upstream {
+	sort.Slice(upstreams, func(i, j int) bool {
+		return upstreams[i].URL < upstreams[j].URL
+	})
+	return upstreams
+}
+
+// Watcher periodically resolves upstreams and reports changes. Failed or
+// empty lookups keep the last known set so a DNS hiccup does not drain a route.
+type Watcher struct {
+	cfg      *config.DiscoveryConfig
+	resolver Resolver
+	onUpdate func([]config.Upstream)
+	last     []config.Upstream
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	mutex    sync.Mutex
+}
+
+// NewWatcher creates a watcher that calls onUpdate whenever the resolved set changes
+func NewWatcher(cfg *config.DiscoveryConfig, onUpdate func([]config.Upstream)) (*Watcher, error) {
+	resolver, err := NewResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		cfg:      cfg,
+		resolver: resolver,
+		onUpdate: onUpdate,
+		stopCh:   make(chan struct{}),
+	}, nil
+}
+
+// Refresh resolves upstreams once and reports them if they changed
+func (w *Watcher) Refresh() error {
+	timeout := w.cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	upstreams, err := w.resolver.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(upstreams) == 0 {
+		return fmt.Errorf("discovery returned no upstreams")
+	}
+
+	w.mutex.Lock()
+	changed := !sameUpstreams(w.last, upstreams)
+	if changed {
+		w.last = upstreams
+	}
+	w.mutex.Unlock()
+
+	if changed && w.onUpdate != nil {
+		w.onUpdate(upstreams)
+	}
+	return nil
+}
+
+// Start refreshes on the configured interval, and on file changes for file discovery
+func (w *Watcher) Start() {
+	var events <-chan fsnotify.Event
+	if w.cfg.Type == "file" {
+		if fileWatcher, err := fsnotify.NewWatcher(); err != nil {
+			log.Printf("Discovery file watcher unavailable for %s, polling only: %v", w.cfg.File, err)
+		} else if err := fileWatcher.Add(filepath.Dir(w.cfg.File)); err != nil {
+			log.Printf("Discovery file watcher unavailable for %s, polling only: %v", w.cfg.File, err)
+			fileWatcher.Close()
+		} else {
+			events = fileWatcher.Events
+			go func() {
+				<-w.stopCh
+				fileWatcher.Close()
+			}()
+		}
+	}
+
+	interval := w.cfg.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.refreshAndLog()
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(w.cfg.File) &&
+					event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					w.refreshAndLog()
+				}
+			case <-w.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Watcher) refreshAndLog() {
+	if err := w.Refresh(); err != nil {
+		log.Printf("Discovery refresh failed, keeping previous upstreams: %v", err)
+	}
+}
+
+// Stop stops the watcher
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+}
+
+func sameUpstreams(a, b []config.Upstream) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
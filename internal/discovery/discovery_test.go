@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func TestSRVResolverKeepsLowestPriorityTargets(t *testing.T) {
+	resolver := &srvResolver{
+		name:   "_http._tcp.historian.local",
+		scheme: "http",
+		lookupSRV: func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			return "", []*net.SRV{
+				{Target: "backup.historian.local.", Port: 8080, Priority: 20, Weight: 10},
+				{Target: "b.historian.local.", Port: 8080, Priority: 10, Weight: 0},
+				{Target: "a.historian.local.", Port: 8080, Priority: 10, Weight: 30},
+			}, nil
+		},
+	}
+
+	upstreams, err := resolver.Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Resolve() returned error: %v", err)
+	}
+
+	if len(upstreams) != 2 {
+		t.Fatalf("upstreams = %#v, want 2 priority-10 targets", upstreams)
+	}
+	if upstreams[0].URL != "http://a.historian.local:8080" || upstreams[0].Weight != 30 {
+		t.Fatalf("first upstream = %#v", upstreams[0])
+	}
+	if upstreams[1].URL != "http://b.historian.local:8080" || upstreams[1].Weight != 1 {
+		t.Fatalf("second upstream = %#v", upstreams[1])
+	}
+}
+
+func TestAddressResolverBuildsURLsForIPv4AndIPv6(t *testing.T) {
+	resolver := &addressResolver{
+		name:   "api.local",
+		port:   3000,
+		scheme: "http",
+		lookupIP: func(ctx context.Context, network, host string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("fd00::5")}, nil
+		},
+	}
+
+	upstreams, err := resolver.Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Resolve() returned error: %v", err)
+	}
+
+	want := []string{"http://10.0.0.5:3000", "http://[fd00::5]:3000"}
+	if len(upstreams) != len(want) {
+		t.Fatalf("upstreams = %#v, want %v", upstreams, want)
+	}
+	for i := range want {
+		if upstreams[i].URL != want[i] {
+			t.Fatalf("upstream #%d = %s, want %s", i, upstreams[i].URL, want[i])
+		}
+	}
+}
+
+func TestFileResolverReadsJSONAndYAML(t *testing.T) {
+	dir := t.TempDir()
+
+	jsonPath := filepath.Join(dir, "endpoints.json")
+	writeFile(t, jsonPath, `{"upstreams":[{"url":"http://10.0.0.6:3000","weight":50}]}`)
+
+	yamlPath := filepath.Join(dir, "endpoints.yaml")
+	writeFile(t, yamlPath, "- url: http://10.0.0.7:3000\n")
+
+	for _, tt := range []struct {
+		path   string
+		url    string
+		weight int
+	}{
+		{path: jsonPath, url: "http://10.0.0.6:3000", weight: 50},
+		{path: yamlPath, url: "http://10.0.0.7:3000", weight: 100},
+	} {
+		resolver := &fileResolver{path: tt.path, healthCheck: "/health"}
+		upstreams, err := resolver.Resolve(context.Background())
+		if err != nil {
+			t.Fatalf("Resolve(%s) returned error: %v", tt.path, err)
+		}
+		if len(upstreams) != 1 || upstreams[0].URL != tt.url || upstreams[0].Weight != tt.weight || upstreams[0].HealthCheck != "/health" {
+			t.Fatalf("Resolve(%s) = %#v", tt.path, upstreams)
+		}
+	}
+}
+
+func TestWatcherKeepsLastSetOnFailureAndSkipsUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeFile(t, path, "- url: http://10.0.0.8:3000\n")
+
+	var updates [][]config.Upstream
+	watcher, err := NewWatcher(&config.DiscoveryConfig{Type: "file", File: path}, func(upstreams []config.Upstream) {
+		updates = append(updates, upstreams)
+	})
+	if err != nil {
+		t.Fatalf("NewWatcher() returned error: %v", err)
+	}
+	defer watcher.Stop()
+
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("second Refresh() returned error: %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("updates = %d, want 1 for an unchanged endpoint file", len(updates))
+	}
+
+	writeFile(t, path, "[]\n")
+	if err := watcher.Refresh(); err == nil {
+		t.Fatal("Refresh() should report an empty endpoint file")
+	}
+
+	watcher.resolver = failingResolver{}
+	if err := watcher.Refresh(); err == nil {
+		t.Fatal("Refresh() should report resolver errors")
+	}
+	if len(updates) != 1 {
+		t.Fatalf("updates = %d, failed refreshes must not replace the upstream set", len(updates))
+	}
+}
+
+type failingResolver struct{}
+
+func (failingResolver) Resolve(ctx context.Context) ([]config.Upstream, error) {
+	return nil, errors.New("dns unavailable")
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
@@ -3,8 +3,12 @@ package health
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
 )
 
 type HealthStatus string
@@ -14,40 +18,132 @@ const (
 	StatusUnhealthy HealthStatus = "unhealthy"
 )
 
+// Readiness modes decide which routes must have a healthy upstream before
+// the gateway reports itself ready for traffic.
+const (
+	ReadinessAllRoutes      = "all_routes"
+	ReadinessRequiredRoutes = "required_routes"
+	ReadinessAnyRoute       = "any_route"
+	ReadinessAlways         = "always"
+)
+
 type Monitor struct {
 	upstreams map[string]*UpstreamHealth
+	routes    map[string]bool
+	readiness config.ReadinessConfig
 	startTime time.Time
 	mu        sync.RWMutex
 }
 
 type UpstreamHealth struct {
-	Name   string       `json:"name"`
-	URL    string       `json:"url"`
-	Status HealthStatus `json:"status"`
+	Name       string       `json:"name"`
+	URL        string       `json:"url"`
+	Status     HealthStatus `json:"status"`
+	LastChange time.Time    `json:"last_change"`
 }
 
 type Stats struct {
-	Status    string            `json:"status"`
-	Uptime    string            `json:"uptime"`
-	StartedAt time.Time         `json:"started_at"`
-	Upstreams []*UpstreamHealth `json:"upstreams"`
+	Status        string            `json:"status"`
+	Ready         bool              `json:"ready"`
+	UnreadyRoutes []string          `json:"unready_routes,omitempty"`
+	Uptime        string            `json:"uptime"`
+	StartedAt     time.Time         `json:"started_at"`
+	Upstreams     []*UpstreamHealth `json:"upstreams"`
 }
 
 func NewMonitor() *Monitor {
 	return &Monitor{
 		upstreams: make(map[string]*UpstreamHealth),
+		routes:    make(map[string]bool),
 		startTime: time.Now(),
 	}
 }
 
+// SetReadiness replaces the readiness criteria used by ReadinessHandler.
+func (m *Monitor) SetReadiness(cfg config.ReadinessConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.readiness = config.ReadinessConfig{
+		Mode:           cfg.Mode,
+		RequiredRoutes: append([]string(nil), cfg.RequiredRoutes...),
+	}
+}
+
 func (m *Monitor) RegisterUpstream(name, url string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.upstreams[name+"|"+url] = &UpstreamHealth{
-		Name:   name,
-		URL:    url,
-		Status: StatusHealthy,
+	m.routes[name] = true
+	m.upstreams[upstreamKey(name, url)] = &UpstreamHealth{
+		Name:       name,
+		URL:        url,
+		Status:     StatusHealthy,
+		LastChange: time.Now(),
+	}
+}
+
+// SetRouteUpstreams replaces the upstreams tracked for a route, keeping the
+// status of upstreams that remain. New upstreams start healthy.
+func (m *Monitor) SetRouteUpstreams(name string, urls []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.routes[name] = true
+
+	keep := make(map[string]bool, len(urls))
+	for _, upstreamURL := range urls {
+		key := upstreamKey(name, upstreamURL)
+		keep[key] = true
+		if _, exists := m.upstreams[key]; !exists {
+			m.upstreams[key] = &UpstreamHealth{
+				Name:       name,
+				URL:        upstreamURL,
+				Status:     StatusHealthy,
+				LastChange: time.Now(),
+			}
+		}
+	}
+
+	for key, upstream := range m.upstreams {
+		if upstream.Name == name && !keep[key] {
+			delete(m.upstreams, key)
+		}
+	}
+}
+
+// SetUpstreamStatus records a health transition reported by a load balancer.
+// Upstreams that are not registered are ignored so late reports from a
+// replaced route cannot resurrect stale entries.
+func (m *Monitor) SetUpstreamStatus(name, url string, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upstream, exists := m.upstreams[upstreamKey(name, url)]
+	if !exists {
+		return
+	}
+
+	status := StatusUnhealthy
+	if healthy {
+		status = StatusHealthy
+	}
+	if upstream.Status != status {
+		upstream.Status = status
+		upstream.LastChange = time.Now()
+	}
+}
+
+// RemoveRoute stops tracking a route and its upstreams
+func (m *Monitor) RemoveRoute(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.routes, name)
+	for key, upstream := range m.upstreams {
+		if upstream.Name == name {
+			delete(m.upstreams, key)
+		}
 	}
 }
 
@@ -56,6 +152,7 @@ func (m *Monitor) ClearUpstreams() {
 	defer m.mu.Unlock()
 
 	m.upstreams = make(map[string]*UpstreamHealth)
+	m.routes = make(map[string]bool)
 }
 
 func (m *Monitor) Stats() Stats {
@@ -63,35 +160,111 @@ func (m *Monitor) Stats() Stats {
 	defer m.mu.RUnlock()
 
 	upstreams := make([]*UpstreamHealth, 0, len(m.upstreams))
-	status := "healthy"
+	allHealthy := true
 	for _, upstream := range m.upstreams {
 		copy := *upstream
 		if copy.Status != StatusHealthy {
-			status = "unhealthy"
+			allHealthy = false
 		}
 		upstreams = append(upstreams, &copy)
 	}
+	sort.Slice(upstreams, func(i, j int) bool {
+		if upstreams[i].Name != upstreams[j].Name {
+			return upstreams[i].Name < upstreams[j].Name
+		}
+		return upstreams[i].URL < upstreams[j].URL
+	})
+
+	unready := m.unreadyRoutes()
+	status := "healthy"
+	if len(unready) > 0 {
+		status = "unhealthy"
+	} else if !allHealthy {
+		status = "degraded"
+	}
 
 	return Stats{
-		Status:    status,
-		Uptime:    time.Since(m.startTime).String(),
-		StartedAt: m.startTime,
-		Upstreams: upstreams,
+		Status:        status,
+		Ready:         len(unready) == 0,
+		UnreadyRoutes: unready,
+		Uptime:        time.Since(m.startTime).String(),
+		StartedAt:     m.startTime,
+		Upstreams:     upstreams,
+	}
+}
+
+// unreadyRoutes returns the routes that violate the readiness criteria.
+// Callers must hold m.mu.
+func (m *Monitor) unreadyRoutes() []string {
+	healthyByRoute := make(map[string]int, len(m.routes))
+	for name := range m.routes {
+		healthyByRoute[name] = 0
+	}
+	for _, upstream := range m.upstreams {
+		if upstream.Status == StatusHealthy {
+			healthyByRoute[upstream.Name]++
+		}
+	}
+
+	var unready []string
+	switch m.readiness.Mode {
+	case ReadinessAlways:
+		return nil
+
+	case ReadinessRequiredRoutes:
+		for _, name := range m.readiness.RequiredRoutes {
+			if healthyByRoute[name] == 0 {
+				unready = append(unready, name)
+			}
+		}
+
+	case ReadinessAnyRoute:
+		for name, healthy := range healthyByRoute {
+			if healthy > 0 {
+				return nil
+			}
+			unready = append(unready, name)
+		}
+
+	default: // all_routes
+		for name, healthy := range healthyByRoute {
+			if healthy == 0 {
+				unready = append(unready, name)
+			}
+		}
 	}
+
+	sort.Strings(unready)
+	return unready
 }
 
 func (m *Monitor) HealthHandler(w http.ResponseWriter, r *http.Request) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	stats := m.Stats()
+
+	healthyUpstreams := 0
+	for _, upstream := range stats.Upstreams {
+		if upstream.Status == StatusHealthy {
+			healthyUpstreams++
+		}
+	}
 
 	health := map[string]interface{}{
-		"status":    "healthy",
-		"uptime":    time.Since(m.startTime).String(),
-		"upstreams": len(m.upstreams),
+		"status":            stats.Status,
+		"uptime":            stats.Uptime,
+		"upstreams":         len(stats.Upstreams),
+		"healthy_upstreams": healthyUpstreams,
+	}
+	if len(stats.UnreadyRoutes) > 0 {
+		health["unready_routes"] = stats.UnreadyRoutes
+	}
+
+	code := http.StatusOK
+	if !stats.Ready {
+		code = http.StatusServiceUnavailable
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(health)
 }
 
@@ -102,7 +275,29 @@ func (m *Monitor) LivenessHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Monitor) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	unready := m.unreadyRoutes()
+	m.mu.RUnlock()
+
 	w.Header().Set("Content-Type", "application/json")
+	if len(unready) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "not_ready",
+			"unready_routes": unready,
+		})
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"ready"}`))
 }
+
+// upstreamKey normalizes the upstream URL so configured URLs and the URLs
+// reported by load balancers map to the same entry.
+func upstreamKey(name, rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		rawURL = parsed.String()
+	}
+	return name + "|" + rawURL
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/JustVugg/gonk/internal/config"
 )
 
 func TestHealthHandlerReportsRegisteredUpstreams(t *testing.T) {
@@ -55,3 +57,80 @@ func TestLivenessAndReadinessHandlers(t *testing.T) {
 		})
 	}
 }
+
+func TestReadinessFailsWhenRouteHasNoHealthyUpstream(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.RegisterUpstream("api", "http://api-a:3000")
+	monitor.RegisterUpstream("api", "http://api-b:3000")
+	monitor.RegisterUpstream("plc", "http://plc:502")
+
+	monitor.SetUpstreamStatus("api", "http://api-a:3000", false)
+
+	rr := httptest.NewRecorder()
+	monitor.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/_gonk/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status with one healthy api upstream = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if stats := monitor.Stats(); stats.Status != "degraded" || !stats.Ready {
+		t.Fatalf("stats = %s ready=%v, want degraded and ready", stats.Status, stats.Ready)
+	}
+
+	monitor.SetUpstreamStatus("plc", "http://plc:502", false)
+
+	rr = httptest.NewRecorder()
+	monitor.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/_gonk/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with plc down = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	var payload struct {
+		Status        string   `json:"status"`
+		UnreadyRoutes []string `json:"unready_routes"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if payload.Status != "not_ready" || len(payload.UnreadyRoutes) != 1 || payload.UnreadyRoutes[0] != "plc" {
+		t.Fatalf("unexpected readiness payload: %#v", payload)
+	}
+
+	rr = httptest.NewRecorder()
+	monitor.HealthHandler(rr, httptest.NewRequest(http.MethodGet, "/_gonk/health", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("health status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestReadinessOnlyConsidersRequiredRoutes(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.SetReadiness(config.ReadinessConfig{
+		Mode:           ReadinessRequiredRoutes,
+		RequiredRoutes: []string{"api"},
+	})
+	monitor.RegisterUpstream("api", "http://api:3000")
+	monitor.RegisterUpstream("reports", "http://reports:3000")
+	monitor.SetUpstreamStatus("reports", "http://reports:3000", false)
+
+	rr := httptest.NewRecorder()
+	monitor.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/_gonk/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	monitor.SetUpstreamStatus("api", "http://api:3000", false)
+
+	rr = httptest.NewRecorder()
+	monitor.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/_gonk/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSetUpstreamStatusIgnoresUnknownUpstreams(t *testing.T) {
+	monitor := NewMonitor()
+	monitor.SetUpstreamStatus("api", "http://stale:3000", false)
+
+	if stats := monitor.Stats(); len(stats.Upstreams) != 0 || !stats.Ready {
+		t.Fatalf("unexpected stats after unknown upstream report: %#v", stats)
+	}
+}
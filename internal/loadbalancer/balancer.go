@@ -2,6 +2,7 @@ package loadbalancer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/JustVugg/gonk/internal/config"
 	"github.com/JustVugg/gonk/internal/metrics"
+	"github.com/JustVugg/gonk/internal/resilience"
+	"github.com/JustVugg/gonk/internal/unixsock"
 )
 
 // UpstreamState represents the health state of an upstream
@@ -26,8 +29,19 @@ type UpstreamState struct {
 	ActiveConns   int32
 	LastCheck     time.Time
 	mutex         sync.RWMutex
+
+	breaker *resilience.CircuitBreaker
 }
 
+// ErrCircuitOpen is returned by GetNextUpstream when every upstream's circuit breaker is open
+var ErrCircuitOpen = errors.New("all upstream circuit breakers are open")
+
+// ErrUpstreamsSaturated is returned by GetNextUpstream when every upstream is at its connection cap
+var ErrUpstreamsSaturated = errors.New("all upstreams are at their connection limit")
+
+// HealthChangeFunc is called when an upstream transitions between healthy and unhealthy
+type HealthChangeFunc func(upstream string, healthy bool)
+
 // LoadBalancer manages multiple upstreams with health checking
 type LoadBalancer struct {
 	upstreams      []*UpstreamState
@@ -35,6 +49,10 @@ type LoadBalancer struct {
 	currentIndex   uint32
 	healthInterval time.Duration
 	healthTimeout  time.Duration
+	onHealthChange HealthChangeFunc
+	breakerRoute   string
+	breakerConfig  *config.CircuitBreakerConfig
+	maxConns       int32
 	stopCh         chan struct{}
 	stopOnce       sync.Once
 	mutex          sync.RWMutex
@@ -46,8 +64,28 @@ func NewLoadBalancer(upstreams []config.Upstream, lbConfig *config.LoadBalancing
 		return nil, fmt.Errorf("no upstreams configured")
 	}
 
+	lb := newLoadBalancer(lbConfig)
+	if err := lb.SetUpstreams(upstreams); err != nil {
+		return nil, err
+	}
+
+	// Start health checking
+	go lb.healthCheckLoop()
+
+	return lb, nil
+}
+
+// NewDynamicLoadBalancer creates a load balancer whose upstream set is supplied
+// later through SetUpstreams, for example by service discovery. It rejects
+// requests with "no healthy upstreams available" until upstreams are set.
+func NewDynamicLoadBalancer(lbConfig *config.LoadBalancingConfig) *LoadBalancer {
+	lb := newLoadBalancer(lbConfig)
+	go lb.healthCheckLoop()
+	return lb
+}
+
+func newLoadBalancer(lbConfig *config.LoadBalancingConfig) *LoadBalancer {
 	lb := &LoadBalancer{
-		upstreams:      make([]*UpstreamState, 0, len(upstreams)),
 		strategy:       "round-robin",
 		healthInterval: 10 * time.Second,
 		healthTimeout:  5 * time.Second,
@@ -67,11 +105,26 @@ func NewLoadBalancer(upstreams []config.Upstream, lbConfig *config.LoadBalancing
 		}
 	}
 
-	// Initialize upstreams
+	return lb
+}
+
+// SetUpstreams replaces the upstream set. Upstreams that remain in the set
+// keep their health, failure and connection counters; new upstreams start
+// healthy. The previous set is kept if any URL is invalid.
+func (lb *LoadBalancer) SetUpstreams(upstreams []config.Upstream) error {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	existing := make(map[string]*UpstreamState, len(lb.upstreams))
+	for _, upstream := range lb.upstreams {
+		existing[upstream.URL.String()] = upstream
+	}
+
+	next := make([]*UpstreamState, 0, len(upstreams))
 	for _, upstream := range upstreams {
 		parsedURL, err := url.Parse(upstream.URL)
 		if err != nil {
-			return nil, fmt.Errorf("invalid upstream URL %s: %w", upstream.URL, err)
+			return fmt.Errorf("invalid upstream URL %s: %w", upstream.URL, err)
 		}
 
 		weight := upstream.Weight
@@ -79,21 +132,61 @@ func NewLoadBalancer(upstreams []config.Upstream, lbConfig *config.LoadBalancing
 			weight = 100
 		}
 
-		state := &UpstreamState{
+		if state, ok := existing[parsedURL.String()]; ok {
+			state.mutex.Lock()
+			state.Weight = weight
+			state.HealthCheck = upstream.HealthCheck
+			state.mutex.Unlock()
+			next = append(next, state)
+			delete(existing, parsedURL.String())
+			continue
+		}
+
+		next = append(next, &UpstreamState{
 			URL:         parsedURL,
 			Weight:      weight,
 			HealthCheck: upstream.HealthCheck,
 			Healthy:     true, // Assume healthy initially
 			LastCheck:   time.Now(),
+			breaker:     lb.newBreaker(parsedURL),
+		})
+	}
+
+	lb.upstreams = next
+	return nil
+}
+
+// EnableCircuitBreakers gives every upstream, including upstreams added later
+// by SetUpstreams, its own circuit breaker named "<route>|<upstream URL>"
+func (lb *LoadBalancer) EnableCircuitBreakers(route string, cfg *config.CircuitBreakerConfig) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	lb.breakerRoute = route
+	lb.breakerConfig = cfg
+	for _, upstream := range lb.upstreams {
+		if upstream.breaker == nil {
+			upstream.breaker = lb.newBreaker(upstream.URL)
 		}
+	}
+}
 
-		lb.upstreams = append(lb.upstreams, state)
+// newBreaker creates the breaker for a new upstream, or nil when circuit
+// breakers are not enabled. Callers must hold lb.mutex.
+func (lb *LoadBalancer) newBreaker(upstreamURL *url.URL) *resilience.CircuitBreaker {
+	if lb.breakerConfig == nil {
+		return nil
 	}
+	return resilience.NewCircuitBreaker(lb.breakerRoute+"|"+upstreamURL.String(), lb.breakerConfig)
+}
 
-	// Start health checking
-	go lb.healthCheckLoop()
+// SetMaxConnsPerUpstream caps the active connections of each upstream.
+// Zero removes the cap.
+func (lb *LoadBalancer) SetMaxConnsPerUpstream(max int) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
 
-	return lb, nil
+	lb.maxConns = int32(max)
 }
 
 // GetNextUpstream returns the next upstream based on strategy
@@ -106,21 +199,95 @@ func (lb *LoadBalancer) GetNextUpstream(clientIP string) (*url.URL, error) {
 		return nil, fmt.Errorf("no healthy upstreams available")
 	}
 
+	if lb.breakerConfig != nil {
+		return lb.nextAdmitted(admittedByBreaker(healthyUpstreams), clientIP)
+	}
+	return lb.next(healthyUpstreams, clientIP)
+}
+
+// next picks an upstream, within the connection cap if there is one.
+// Callers must hold lb.mutex.
+func (lb *LoadBalancer) next(upstreams []*UpstreamState, clientIP string) (*url.URL, error) {
+	if lb.maxConns > 0 {
+		return lb.nextWithinLimit(upstreams, clientIP)
+	}
+	return lb.pick(upstreams, clientIP), nil
+}
+
+// nextAdmitted picks among upstreams whose breaker admits a call. Only the
+// picked upstream's breaker is asked to allow it, so upstreams passed over
+// are not moved to half-open. A pick whose breaker changed its mind since
+// the filter is undone and retried without it. Callers must hold lb.mutex.
+func (lb *LoadBalancer) nextAdmitted(upstreams []*UpstreamState, clientIP string) (*url.URL, error) {
+	for len(upstreams) > 0 {
+		selected, err := lb.next(upstreams, clientIP)
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := make([]*UpstreamState, 0, len(upstreams)-1)
+		for _, upstream := range upstreams {
+			if upstream.URL != selected {
+				remaining = append(remaining, upstream)
+				continue
+			}
+			if upstream.breaker == nil || upstream.breaker.Allow() {
+				return selected, nil
+			}
+			atomic.AddInt32(&upstream.ActiveConns, -1)
+		}
+		upstreams = remaining
+	}
+	return nil, ErrCircuitOpen
+}
+
+// pick selects an upstream with the configured strategy and counts the new connection
+func (lb *LoadBalancer) pick(upstreams []*UpstreamState, clientIP string) *url.URL {
 	switch lb.strategy {
 	case "round-robin":
-		return lb.roundRobin(healthyUpstreams), nil
+		return lb.roundRobin(upstreams)
 
 	case "weighted":
-		return lb.weighted(healthyUpstreams), nil
+		return lb.weighted(upstreams)
 
 	case "least-connections":
-		return lb.leastConnections(healthyUpstreams), nil
+		return lb.leastConnections(upstreams)
 
 	case "ip-hash":
-		return lb.ipHash(healthyUpstreams, clientIP), nil
+		return lb.ipHash(upstreams, clientIP)
 
 	default:
-		return lb.roundRobin(healthyUpstreams), nil
+		return lb.roundRobin(upstreams)
+	}
+}
+
+// nextWithinLimit picks among upstreams below the connection cap. A pick that
+// overshoots the cap because of a concurrent request is undone and retried
+// without that upstream. Callers must hold lb.mutex.
+func (lb *LoadBalancer) nextWithinLimit(upstreams []*UpstreamState, clientIP string) (*url.URL, error) {
+	for {
+		available := make([]*UpstreamState, 0, len(upstreams))
+		for _, upstream := range upstreams {
+			if atomic.LoadInt32(&upstream.ActiveConns) < lb.maxConns {
+				available = append(available, upstream)
+			}
+		}
+		if len(available) == 0 {
+			return nil, ErrUpstreamsSaturated
+		}
+
+		selected := lb.pick(available, clientIP)
+		for _, upstream := range available {
+			if upstream.URL != selected {
+				continue
+			}
+			if atomic.LoadInt32(&upstream.ActiveConns) <= lb.maxConns {
+				return selected, nil
+			}
+			atomic.AddInt32(&upstream.ActiveConns, -1)
+			break
+		}
+		upstreams = available
 	}
 }
 
@@ -202,45 +369,106 @@ func (lb *LoadBalancer) ReleaseConnection(upstreamURL *url.URL) {
 	}
 }
 
+// RecordResult records the outcome of a proxied request. The upstream's
+// circuit breaker sees every result; health tracking counts 5xx and errors.
+func (lb *LoadBalancer) RecordResult(upstreamURL *url.URL, statusCode int, err error, duration time.Duration) {
+	upstream := lb.findUpstream(upstreamURL)
+	if upstream == nil {
+		return
+	}
+
+	if upstream.breaker != nil {
+		upstream.breaker.Record(statusCode, err, duration)
+	}
+
+	if err != nil || statusCode >= 500 {
+		lb.RecordFailure(upstreamURL)
+	} else {
+		lb.RecordSuccess(upstreamURL)
+	}
+}
+
 // RecordFailure records a failure for an upstream
 func (lb *LoadBalancer) RecordFailure(upstreamURL *url.URL) {
-	lb.mutex.Lock()
-	defer lb.mutex.Unlock()
+	upstream := lb.findUpstream(upstreamURL)
+	if upstream == nil {
+		return
+	}
 
-	for _, upstream := range lb.upstreams {
-		if upstream.URL.String() == upstreamURL.String() {
-			failures := atomic.AddInt32(&upstream.Failures, 1)
-
-			// Mark as unhealthy after 3 consecutive failures
-			if failures >= 3 {
-				upstream.mutex.Lock()
-				upstream.Healthy = false
-				upstream.mutex.Unlock()
-				log.Printf("Upstream %s marked unhealthy after %d failures", upstreamURL, failures)
-			}
-			break
+	failures := atomic.AddInt32(&upstream.Failures, 1)
+
+	// Mark as unhealthy after 3 consecutive failures
+	if failures >= 3 {
+		upstream.mutex.Lock()
+		wasHealthy := upstream.Healthy
+		upstream.Healthy = false
+		upstream.mutex.Unlock()
+
+		if wasHealthy {
+			log.Printf("Upstream %s marked unhealthy after %d failures", upstreamURL, failures)
+			metrics.UpdateUpstreamHealth(upstream.URL.String(), 0)
+			lb.notifyHealthChange(upstream, false)
 		}
 	}
 }
 
 // RecordSuccess records a successful request
 func (lb *LoadBalancer) RecordSuccess(upstreamURL *url.URL) {
+	upstream := lb.findUpstream(upstreamURL)
+	if upstream == nil {
+		return
+	}
+
+	atomic.StoreInt32(&upstream.Failures, 0)
+
+	upstream.mutex.Lock()
+	wasUnhealthy := !upstream.Healthy
+	upstream.Healthy = true
+	upstream.mutex.Unlock()
+
+	if wasUnhealthy {
+		log.Printf("Upstream %s marked healthy", upstreamURL)
+		metrics.UpdateUpstreamHealth(upstream.URL.String(), 1)
+		lb.notifyHealthChange(upstream, true)
+	}
+}
+
+// OnHealthChange registers a callback for upstream health transitions
+func (lb *LoadBalancer) OnHealthChange(fn HealthChangeFunc) {
 	lb.mutex.Lock()
 	defer lb.mutex.Unlock()
 
+	lb.onHealthChange = fn
+}
+
+// notifyHealthChange reports a transition unless the load balancer has been stopped
+func (lb *LoadBalancer) notifyHealthChange(upstream *UpstreamState, healthy bool) {
+	select {
+	case <-lb.stopCh:
+		return
+	default:
+	}
+
+	lb.mutex.RLock()
+	fn := lb.onHealthChange
+	lb.mutex.RUnlock()
+
+	if fn != nil {
+		fn(upstream.URL.String(), healthy)
+	}
+}
+
+// findUpstream returns the state tracked for an upstream URL
+func (lb *LoadBalancer) findUpstream(upstreamURL *url.URL) *UpstreamState {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
 	for _, upstream := range lb.upstreams {
 		if upstream.URL.String() == upstreamURL.String() {
-			atomic.StoreInt32(&upstream.Failures, 0)
-
-			upstream.mutex.Lock()
-			if !upstream.Healthy {
-				upstream.Healthy = true
-				log.Printf("Upstream %s marked healthy", upstreamURL)
-			}
-			upstream.mutex.Unlock()
-			break
+			return upstream
 		}
 	}
+	return nil
 }
 
 // getHealthyUpstreams returns list of healthy upstreams
@@ -263,6 +491,56 @@ func (lb *LoadBalancer) getHealthyUpstreams() []*UpstreamState {
 	return healthy
 }
 
+// admittedByBreaker returns the upstreams whose circuit breaker would admit
+// a call, without changing any breaker's state
+func admittedByBreaker(upstreams []*UpstreamState) []*UpstreamState {
+	admitted := make([]*UpstreamState, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if upstream.breaker == nil || upstream.breaker.Admits() {
+			admitted = append(admitted, upstream)
+		}
+	}
+	return admitted
+}
+
+// CircuitBreakers returns the per-upstream breakers, limited to one upstream
+// when upstreamURL is set
+func (lb *LoadBalancer) CircuitBreakers(upstreamURL string) []*resilience.CircuitBreaker {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
+	var breakers []*resilience.CircuitBreaker
+	for _, upstream := range lb.upstreams {
+		if upstream.breaker == nil {
+			continue
+		}
+		if upstreamURL != "" && upstream.URL.String() != upstreamURL {
+			continue
+		}
+		breakers = append(breakers, upstream.breaker)
+	}
+	return breakers
+}
+
+// CircuitBreakerStats returns the per-upstream breaker stats, or nil when
+// circuit breakers are not enabled
+func (lb *LoadBalancer) CircuitBreakerStats() []resilience.Stats {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
+	if lb.breakerConfig == nil {
+		return nil
+	}
+
+	stats := make([]resilience.Stats, 0, len(lb.upstreams))
+	for _, upstream := range lb.upstreams {
+		if upstream.breaker != nil {
+			stats = append(stats, upstream.breaker.Stats())
+		}
+	}
+	return stats
+}
+
 // healthCheckLoop periodically checks upstream health
 func (lb *LoadBalancer) healthCheckLoop() {
 	ticker := time.NewTicker(lb.healthInterval)
@@ -296,7 +574,9 @@ func (lb *LoadBalancer) checkUpstreamHealth(upstream *UpstreamState) {
 	defer cancel()
 
 	// Try to connect to the configured health endpoint, falling back to the upstream URL.
+	upstream.mutex.RLock()
 	healthURL := lb.healthCheckURL(upstream)
+	upstream.mutex.RUnlock()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
 	if err != nil {
@@ -304,12 +584,17 @@ func (lb *LoadBalancer) checkUpstreamHealth(upstream *UpstreamState) {
 		return
 	}
 
+	dialContext := (&net.Dialer{
+		Timeout: lb.healthTimeout,
+	}).DialContext
+	if unixsock.IsUnix(upstream.URL) {
+		dialContext = unixsock.Dialer(unixsock.Path(upstream.URL), lb.healthTimeout)
+	}
+
 	client := &http.Client{
 		Timeout: lb.healthTimeout,
 		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout: lb.healthTimeout,
-			}).DialContext,
+			DialContext: dialContext,
 		},
 	}
 
@@ -329,30 +614,37 @@ func (lb *LoadBalancer) checkUpstreamHealth(upstream *UpstreamState) {
 }
 
 func (lb *LoadBalancer) healthCheckURL(upstream *UpstreamState) string {
+	base := upstream.URL
+	if unixsock.IsUnix(base) {
+		// Socket upstreams are dialed directly, so the request URL only
+		// carries the Host header and the health check path.
+		base = &url.URL{Scheme: "http", Host: unixsock.Host, Path: "/"}
+	}
+
 	if upstream.HealthCheck == "" {
-		return upstream.URL.String()
+		return base.String()
 	}
 
 	healthRef, err := url.Parse(upstream.HealthCheck)
 	if err != nil {
-		return upstream.URL.String()
+		return base.String()
 	}
 
-	return upstream.URL.ResolveReference(healthRef).String()
+	return base.ResolveReference(healthRef).String()
 }
 
 // markHealthy marks upstream as healthy
 func (lb *LoadBalancer) markHealthy(upstream *UpstreamState) {
 	upstream.mutex.Lock()
-	defer upstream.mutex.Unlock()
-
 	wasUnhealthy := !upstream.Healthy
 	upstream.Healthy = true
 	upstream.LastCheck = time.Now()
 	atomic.StoreInt32(&upstream.Failures, 0)
+	upstream.mutex.Unlock()
 
 	if wasUnhealthy {
 		log.Printf("Upstream %s recovered and marked healthy", upstream.URL)
+		lb.notifyHealthChange(upstream, true)
 	}
 	metrics.UpdateUpstreamHealth(upstream.URL.String(), 1)
 }
@@ -360,14 +652,14 @@ func (lb *LoadBalancer) markHealthy(upstream *UpstreamState) {
 // markUnhealthy marks upstream as unhealthy
 func (lb *LoadBalancer) markUnhealthy(upstream *UpstreamState) {
 	upstream.mutex.Lock()
-	defer upstream.mutex.Unlock()
-
 	wasHealthy := upstream.Healthy
 	upstream.Healthy = false
 	upstream.LastCheck = time.Now()
+	upstream.mutex.Unlock()
 
 	if wasHealthy {
 		log.Printf("Upstream %s failed health check and marked unhealthy", upstream.URL)
+		lb.notifyHealthChange(upstream, false)
 	}
 	metrics.UpdateUpstreamHealth(upstream.URL.String(), 0)
 }
@@ -379,6 +671,18 @@ func (lb *LoadBalancer) Stop() {
 	})
 }
 
+// UpstreamURLs returns the URLs of the current upstream set
+func (lb *LoadBalancer) UpstreamURLs() []string {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
+	urls := make([]string, 0, len(lb.upstreams))
+	for _, upstream := range lb.upstreams {
+		urls = append(urls, upstream.URL.String())
+	}
+	return urls
+}
+
 // GetStats returns load balancer statistics
 func (lb *LoadBalancer) GetStats() map[string]interface{} {
 	lb.mutex.RLock()
@@ -389,19 +693,27 @@ func (lb *LoadBalancer) GetStats() map[string]interface{} {
 
 	for _, upstream := range lb.upstreams {
 		upstream.mutex.RLock()
-		upstreamStats = append(upstreamStats, map[string]interface{}{
+		upstreamStat := map[string]interface{}{
 			"url":            upstream.URL.String(),
 			"healthy":        upstream.Healthy,
 			"active_conns":   atomic.LoadInt32(&upstream.ActiveConns),
 			"total_requests": atomic.LoadInt64(&upstream.TotalRequests),
 			"failures":       atomic.LoadInt32(&upstream.Failures),
 			"last_check":     upstream.LastCheck,
-		})
+		}
 		upstream.mutex.RUnlock()
+
+		if upstream.breaker != nil {
+			upstreamStat["circuit_breaker"] = upstream.breaker.Stats()
+		}
+		upstreamStats = append(upstreamStats, upstreamStat)
 	}
 
 	stats["upstreams"] = upstreamStats
 	stats["strategy"] = lb.strategy
+	if lb.maxConns > 0 {
+		stats["max_conns_per_upstream"] = lb.maxConns
+	}
 	stats["total_upstreams"] = len(lb.upstreams)
 	stats["healthy_upstreams"] = len(lb.getHealthyUpstreams())
 
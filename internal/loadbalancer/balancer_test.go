@@ -1,14 +1,18 @@
 package loadbalancer
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/resilience"
 )
 
 func TestHealthCheckURLUsesConfiguredPath(t *testing.T) {
@@ -166,3 +170,236 @@ func TestCheckUpstreamHealthMarksHealthyAndUnhealthy(t *testing.T) {
 		t.Fatal("unhealthy upstream should be marked unhealthy")
 	}
 }
+
+func TestCheckUpstreamHealthOverUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "svc.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	upstreamURL, _ := url.Parse("unix://" + socketPath)
+	upstream := &UpstreamState{URL: upstreamURL, HealthCheck: "/health", Healthy: false}
+
+	lb := &LoadBalancer{healthTimeout: time.Second}
+	if got := lb.healthCheckURL(upstream); got != "http://localhost/health" {
+		t.Fatalf("healthCheckURL() = %q, want http://localhost/health", got)
+	}
+
+	lb.checkUpstreamHealth(upstream)
+	if !upstream.Healthy {
+		t.Fatal("unix socket upstream should be marked healthy")
+	}
+}
+
+func TestHealthTransitionsNotifyObserver(t *testing.T) {
+	lb, err := NewLoadBalancer([]config.Upstream{
+		{URL: "http://backend-a:3000", Weight: 100},
+	}, &config.LoadBalancingConfig{Strategy: "round-robin", HealthCheckInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() returned error: %v", err)
+	}
+	defer lb.Stop()
+
+	var transitions []bool
+	lb.OnHealthChange(func(upstream string, healthy bool) {
+		if upstream != "http://backend-a:3000" {
+			t.Fatalf("upstream = %q, want http://backend-a:3000", upstream)
+		}
+		transitions = append(transitions, healthy)
+	})
+
+	for i := 0; i < 4; i++ {
+		lb.RecordFailure(lb.upstreams[0].URL)
+	}
+	lb.RecordSuccess(lb.upstreams[0].URL)
+	lb.RecordSuccess(lb.upstreams[0].URL)
+
+	if len(transitions) != 2 || transitions[0] || !transitions[1] {
+		t.Fatalf("transitions = %v, want [false true]", transitions)
+	}
+
+	lb.Stop()
+	lb.markUnhealthy(lb.upstreams[0])
+	if len(transitions) != 2 {
+		t.Fatalf("stopped load balancer should not notify, transitions = %v", transitions)
+	}
+}
+
+func TestSetUpstreamsPreservesCountersForRemainingUpstreams(t *testing.T) {
+	lb, err := NewLoadBalancer([]config.Upstream{
+		{URL: "http://10.0.0.1:3000", Weight: 100},
+		{URL: "http://10.0.0.2:3000", Weight: 100},
+	}, &config.LoadBalancingConfig{Strategy: "least-connections", HealthCheckInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() returned error: %v", err)
+	}
+	defer lb.Stop()
+
+	kept := lb.upstreams[0]
+	atomic.StoreInt32(&kept.ActiveConns, 4)
+	atomic.StoreInt64(&kept.TotalRequests, 12)
+
+	if err := lb.SetUpstreams([]config.Upstream{
+		{URL: "http://10.0.0.1:3000", Weight: 50},
+		{URL: "http://10.0.0.3:3000", Weight: 100},
+	}); err != nil {
+		t.Fatalf("SetUpstreams() returned error: %v", err)
+	}
+
+	if len(lb.upstreams) != 2 || lb.upstreams[0] != kept {
+		t.Fatalf("remaining upstream state was not reused: %#v", lb.upstreams)
+	}
+	if atomic.LoadInt32(&kept.ActiveConns) != 4 || atomic.LoadInt64(&kept.TotalRequests) != 12 || kept.Weight != 50 {
+		t.Fatalf("kept upstream counters = conns %d total %d weight %d", kept.ActiveConns, kept.TotalRequests, kept.Weight)
+	}
+	if got := lb.UpstreamURLs(); got[1] != "http://10.0.0.3:3000" {
+		t.Fatalf("UpstreamURLs() = %v, want new upstream", got)
+	}
+}
+
+func TestDynamicLoadBalancerRejectsUntilUpstreamsAreSet(t *testing.T) {
+	lb := NewDynamicLoadBalancer(&config.LoadBalancingConfig{HealthCheckInterval: time.Hour})
+	defer lb.Stop()
+
+	if _, err := lb.GetNextUpstream("192.0.2.10"); err == nil {
+		t.Fatal("GetNextUpstream() should fail without upstreams")
+	}
+
+	if err := lb.SetUpstreams([]config.Upstream{{URL: "http://10.0.0.9:3000"}}); err != nil {
+		t.Fatalf("SetUpstreams() returned error: %v", err)
+	}
+	next, err := lb.GetNextUpstream("192.0.2.10")
+	if err != nil || next.String() != "http://10.0.0.9:3000" {
+		t.Fatalf("GetNextUpstream() = %v, %v", next, err)
+	}
+}
+
+func TestUpstreamCircuitBreakersRouteAroundOpenUpstream(t *testing.T) {
+	lb, err := NewLoadBalancer([]config.Upstream{
+		{URL: "http://plc-1:3000", Weight: 100},
+		{URL: "http://plc-2:3000", Weight: 100},
+	}, &config.LoadBalancingConfig{Strategy: "round-robin", HealthCheckInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() returned error: %v", err)
+	}
+	defer lb.Stop()
+
+	lb.EnableCircuitBreakers("plc", &config.CircuitBreakerConfig{
+		MaxFailures:     1,
+		ResetTimeout:    time.Hour,
+		HalfOpenMaxReqs: 1,
+	})
+
+	broken, _ := url.Parse("http://plc-1:3000")
+	lb.RecordResult(broken, http.StatusBadGateway, nil, time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		got, err := lb.GetNextUpstream("")
+		if err != nil {
+			t.Fatalf("GetNextUpstream() returned error: %v", err)
+		}
+		if got.String() != "http://plc-2:3000" {
+			t.Fatalf("GetNextUpstream() = %s, want http://plc-2:3000 while plc-1 is open", got)
+		}
+		lb.ReleaseConnection(got)
+	}
+
+	upstreamStats := lb.GetStats()["upstreams"].([]map[string]interface{})
+	if state := upstreamStats[0]["circuit_breaker"].(resilience.Stats).State; state != "open" {
+		t.Fatalf("plc-1 breaker state = %s, want open", state)
+	}
+
+	healthy, _ := url.Parse("http://plc-2:3000")
+	lb.RecordResult(healthy, 0, errors.New("connection refused"), time.Millisecond)
+
+	if _, err := lb.GetNextUpstream(""); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GetNextUpstream() error = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestConnectionCapSkipsSaturatedUpstreams(t *testing.T) {
+	lb, err := NewLoadBalancer([]config.Upstream{
+		{URL: "http://a:3000", Weight: 100},
+		{URL: "http://b:3000", Weight: 100},
+	}, &config.LoadBalancingConfig{Strategy: "ip-hash"})
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() returned error: %v", err)
+	}
+	defer lb.Stop()
+	lb.SetMaxConnsPerUpstream(1)
+
+	first, err := lb.GetNextUpstream("192.0.2.10")
+	if err != nil {
+		t.Fatalf("first GetNextUpstream() returned error: %v", err)
+	}
+	second, err := lb.GetNextUpstream("192.0.2.10")
+	if err != nil {
+		t.Fatalf("second GetNextUpstream() returned error: %v", err)
+	}
+	if first.String() == second.String() {
+		t.Fatalf("both requests went to %s, want the capped upstream skipped", first)
+	}
+
+	if _, err := lb.GetNextUpstream("192.0.2.10"); !errors.Is(err, ErrUpstreamsSaturated) {
+		t.Fatalf("GetNextUpstream() error = %v, want ErrUpstreamsSaturated", err)
+	}
+
+	lb.ReleaseConnection(first)
+	next, err := lb.GetNextUpstream("192.0.2.10")
+	if err != nil {
+		t.Fatalf("GetNextUpstream() after release returned error: %v", err)
+	}
+	if next.String() != first.String() {
+		t.Fatalf("next upstream = %s, want released %s", next, first)
+	}
+}
+
+func TestUpstreamCircuitBreakersOnlyProbeThePickedUpstream(t *testing.T) {
+	lb, err := NewLoadBalancer([]config.Upstream{
+		{URL: "http://plc-1:3000", Weight: 100},
+		{URL: "http://plc-2:3000", Weight: 100},
+	}, &config.LoadBalancingConfig{Strategy: "least-connections", HealthCheckInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewLoadBalancer() returned error: %v", err)
+	}
+	defer lb.Stop()
+
+	lb.EnableCircuitBreakers("plc", &config.CircuitBreakerConfig{
+		MaxFailures:     1,
+		ResetTimeout:    time.Millisecond,
+		HalfOpenMaxReqs: 1,
+	})
+
+	busy, _ := url.Parse("http://plc-2:3000")
+	lb.RecordResult(busy, http.StatusBadGateway, nil, time.Millisecond)
+	// plc-2 may be probed again, but least-connections picks plc-1
+	lb.upstreams[1].ActiveConns = 5
+	time.Sleep(5 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		got, err := lb.GetNextUpstream("")
+		if err != nil {
+			t.Fatalf("GetNextUpstream() returned error: %v", err)
+		}
+		if got.String() != "http://plc-1:3000" {
+			t.Fatalf("GetNextUpstream() = %s, want http://plc-1:3000", got)
+		}
+		lb.ReleaseConnection(got)
+	}
+
+	if state := lb.CircuitBreakers(busy.String())[0].State(); state != resilience.StateOpen {
+		t.Fatalf("plc-2 breaker state = %s, want open until plc-2 is picked", state)
+	}
+}
@@ -36,12 +36,48 @@ var (
 		},
 		[]string{"upstream"},
 	)
+
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gonk_circuit_breaker_state",
+			Help: "Current circuit breaker state, where 0 is closed, 1 is open and 2 is half-open",
+		},
+		[]string{"breaker"},
+	)
+
+	circuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gonk_circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker state transitions",
+		},
+		[]string{"breaker", "from", "to"},
+	)
+
+	concurrencyRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gonk_concurrency_rejections_total",
+			Help: "Total number of requests rejected by route or upstream concurrency limits",
+		},
+		[]string{"route", "reason"},
+	)
+
+	concurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gonk_concurrency_limit",
+			Help: "Current adaptive concurrency limit of a route",
+		},
+		[]string{"route"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(httpRequestsTotal)
 	prometheus.MustRegister(httpRequestDuration)
 	prometheus.MustRegister(upstreamHealthy)
+	prometheus.MustRegister(circuitBreakerState)
+	prometheus.MustRegister(circuitBreakerTransitions)
+	prometheus.MustRegister(concurrencyRejections)
+	prometheus.MustRegister(concurrencyLimit)
 }
 
 func Middleware(next http.Handler) http.Handler {
@@ -118,6 +154,22 @@ func UpdateUpstreamHealth(name string, healthy float64) {
 	upstreamHealthy.WithLabelValues(name).Set(healthy)
 }
 
+func UpdateCircuitBreakerState(name string, state float64) {
+	circuitBreakerState.WithLabelValues(name).Set(state)
+}
+
+func RecordCircuitBreakerTransition(name, from, to string) {
+	circuitBreakerTransitions.WithLabelValues(name, from, to).Inc()
+}
+
+func RecordConcurrencyRejection(route, reason string) {
+	concurrencyRejections.WithLabelValues(route, reason).Inc()
+}
+
+func UpdateConcurrencyLimit(route string, limit float64) {
+	concurrencyLimit.WithLabelValues(route).Set(limit)
+}
+
 func routeLabel(r *http.Request) string {
 	route := mux.CurrentRoute(r)
 	if route == nil {
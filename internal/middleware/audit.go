@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/JustVugg/gonk/internal/auth"
+	"github.com/JustVugg/gonk/internal/clientip"
 )
 
 func Audit(routeName string, next http.Handler) http.Handler {
@@ -74,9 +75,5 @@ func (w *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 }
 
 func clientIP(r *http.Request) string {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		return host
-	}
-	return r.RemoteAddr
+	return clientip.FromRequest(r)
 }
@@ -1,88 +1,751 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/JustVugg/gonk/internal/config"
-	"golang.org/x/time/rate"
-	"net"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/auth"
+	"github.com/JustVugg/gonk/internal/config"
 )
 
-type rateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.RWMutex
-	rate     int
-	burst    int
+// rateLimitResult is the outcome of one rate limit check
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	resetAfter time.Duration // until the limit is fully replenished
+	retryAfter time.Duration // until a rejected request would be allowed
+}
+
+// errRateLimitContention is returned when concurrent updates to one key keep
+// winning the compare-and-swap race
+var errRateLimitContention = errors.New("rate limit key is contended")
+
+// storeErrorLogged holds the unix time of the last logged store error, so an
+// unreachable store does not log once per request
+var storeErrorLogged int64
+
+// rateLimitRule is one limit of limit requests per period, normalized from
+// the primary limit of a RateLimitConfig or one of its stacked limits
+type rateLimitRule struct {
+	name      string
+	limit     int
+	period    time.Duration
+	burst     int
+	algorithm string
+	key       []string
+}
+
+// maxTrackedConsumers bounds the clients a RateLimiter keeps usage for.
+// Limits still apply to clients beyond it; they are only not listed.
+const maxTrackedConsumers = 10000
+
+// RateLimiter limits requests per client using the configured algorithm and
+// stacked limits, and keeps per-client usage for the admin API
+type RateLimiter struct {
+	name      string
+	cfg       *config.RateLimitConfig
+	store     RateLimitStore
+	rules     []rateLimitRule
+	idleAfter time.Duration // usage idle this long is dropped when the table is full
+
+	consumers map[string]*rateLimitConsumer
+	mu        sync.Mutex
+}
+
+// rateLimitConsumer is the usage of one client, keyed by the primary limit's key
+type rateLimitConsumer struct {
+	keys     []string // client key per rule, as of the last request
+	requests int64
+	rejected int64
+	lastSeen time.Time
 }
 
-var limiterInstance *rateLimiter
+// RateLimitConsumer describes a client and its current buckets
+type RateLimitConsumer struct {
+	Key      string            `json:"key"`
+	Requests int64             `json:"requests"`
+	Rejected int64             `json:"rejected"`
+	LastSeen time.Time         `json:"last_seen"`
+	Buckets  []RateLimitBucket `json:"buckets"`
+}
+
+// RateLimitBucket is the state of one limit for one client
+type RateLimitBucket struct {
+	Rule         string `json:"rule"`
+	Algorithm    string `json:"algorithm"`
+	Limit        int    `json:"limit"`
+	Remaining    int    `json:"remaining"`
+	ResetAfterMs int64  `json:"reset_after_ms"`
+	Error        string `json:"error,omitempty"`
+}
 
-func init() {
-	limiterInstance = &rateLimiter{
-		limiters: make(map[string]*rate.Limiter),
+func NewRateLimiter(name string, cfg *config.RateLimitConfig, store RateLimitStore) *RateLimiter {
+	rules := rateLimitRules(cfg)
+	idleAfter := time.Duration(0)
+	for _, rule := range rules {
+		if rule.period > idleAfter {
+			idleAfter = rule.period
+		}
+	}
+
+	return &RateLimiter{
+		name:      name,
+		cfg:       cfg,
+		store:     store,
+		rules:     rules,
+		idleAfter: idleAfter,
+		consumers: make(map[string]*rateLimitConsumer),
 	}
 }
 
-func RateLimit(cfg *config.RateLimitConfig, next http.Handler) http.Handler {
+// RateLimit limits requests with a RateLimiter that is not tracked elsewhere
+func RateLimit(cfg *config.RateLimitConfig, store RateLimitStore, next http.Handler) http.Handler {
+	if cfg == nil || !cfg.Enabled {
+		return next
+	}
+	return NewRateLimiter("", cfg, store).Middleware(next)
+}
+
+// Middleware checks the limits in order and stops at the first rejection.
+// Earlier limits get the request back, so a rejected request counts against
+// none of them. Every response
+// carries RateLimit headers for the limit closest to running out. When the
+// store fails, requests are let through so a store outage does not take the
+// gateway down with it.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if cfg == nil || !cfg.Enabled {
-			next.ServeHTTP(w, r)
-			return
-		}
+		now := time.Now()
+		keys := make([]string, len(l.rules))
+		var tightest *rateLimitResult
 
-		key := limiterKey(getKey(r, cfg.By), cfg)
-		limiter := getLimiter(key, cfg.RequestsPerSecond, cfg.Burst)
+		for i, rule := range l.rules {
+			keys[i] = clientKey(r, rule.key)
+			result, err := checkRateLimit(r.Context(), l.store, rule.storeKey(keys[i]), rule, now)
+			if err != nil {
+				logStoreError(err)
+				tightest = nil
+				break
+			}
 
-		if !limiter.Allow() {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", cfg.RequestsPerSecond))
-			w.Header().Set("X-RateLimit-Remaining", "0")
-			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(time.Second).Unix()))
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte(`{"error":"rate limit exceeded"}`))
-			return
+			if !result.allowed {
+				for j := 0; j < i; j++ {
+					if err := refundRateLimit(r.Context(), l.store, l.rules[j].storeKey(keys[j]), l.rules[j], now); err != nil {
+						logStoreError(err)
+					}
+				}
+				l.record(keys, false, now)
+				writeRateLimitHeaders(w, result, now)
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(result.retryAfter), 10))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error":"rate limit exceeded"}`))
+				return
+			}
+			if tightest == nil || result.remaining < tightest.remaining {
+				result := result
+				tightest = &result
+			}
 		}
 
+		l.record(keys, true, now)
+		if tightest != nil {
+			writeRateLimitHeaders(w, *tightest, now)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func limiterKey(identity string, cfg *config.RateLimitConfig) string {
-	return fmt.Sprintf("%s:%d:%d", identity, cfg.RequestsPerSecond, cfg.Burst)
+// writeRateLimitHeaders sets the IETF RateLimit fields, where the reset is
+// in seconds from now, and the older X-RateLimit fields, where it is a Unix time
+func writeRateLimitHeaders(w http.ResponseWriter, result rateLimitResult, now time.Time) {
+	reset := ceilSeconds(result.resetAfter)
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(result.limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	header.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+reset, 10))
 }
 
-func getKey(r *http.Request, by string) string {
-	switch by {
-	case "client_id":
-		if clientID := r.Header.Get("X-Client-ID"); clientID != "" {
-			return clientID
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// record updates the usage of the client. keys holds the client key per
+// rule; the primary key identifies the client.
+func (l *RateLimiter) record(keys []string, allowed bool, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	consumer := l.consumers[keys[0]]
+	if consumer == nil {
+		if len(l.consumers) >= maxTrackedConsumers {
+			l.pruneIdle(now)
+			if len(l.consumers) >= maxTrackedConsumers {
+				return
+			}
 		}
-	default: // "ip"
-		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-			return ip
+		consumer = &rateLimitConsumer{}
+		l.consumers[keys[0]] = consumer
+	}
+
+	consumer.keys = keys
+	consumer.requests++
+	if !allowed {
+		consumer.rejected++
+	}
+	consumer.lastSeen = now
+}
+
+// pruneIdle drops clients whose every window has passed since their last
+// request. Callers must hold l.mu.
+func (l *RateLimiter) pruneIdle(now time.Time) {
+	for key, consumer := range l.consumers {
+		if now.Sub(consumer.lastSeen) > l.idleAfter {
+			delete(l.consumers, key)
+		}
+	}
+}
+
+// Name returns the route the limiter belongs to
+func (l *RateLimiter) Name() string {
+	return l.name
+}
+
+// Config returns the limits the limiter enforces
+func (l *RateLimiter) Config() *config.RateLimitConfig {
+	return l.cfg
+}
+
+// TrackedConsumers returns the number of clients with recorded usage
+func (l *RateLimiter) TrackedConsumers() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.consumers)
+}
+
+// TopConsumers returns up to n clients with the most requests, with the
+// current state of their buckets. Reading buckets does not consume them.
+func (l *RateLimiter) TopConsumers(ctx context.Context, n int) []RateLimitConsumer {
+	l.mu.Lock()
+	consumers := make([]RateLimitConsumer, 0, len(l.consumers))
+	keys := make(map[string][]string, len(l.consumers))
+	for key, consumer := range l.consumers {
+		consumers = append(consumers, RateLimitConsumer{
+			Key:      key,
+			Requests: consumer.requests,
+			Rejected: consumer.rejected,
+			LastSeen: consumer.lastSeen,
+		})
+		keys[key] = consumer.keys
+	}
+	l.mu.Unlock()
+
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].Requests != consumers[j].Requests {
+			return consumers[i].Requests > consumers[j].Requests
+		}
+		return consumers[i].Key < consumers[j].Key
+	})
+	if n > 0 && len(consumers) > n {
+		consumers = consumers[:n]
+	}
+
+	now := time.Now()
+	for i := range consumers {
+		consumers[i].Buckets = l.buckets(ctx, keys[consumers[i].Key], now)
+	}
+	return consumers
+}
+
+// Consumer returns the usage and buckets of one client. Clients without
+// recorded usage are reported with the buckets of every limit that uses the
+// primary key.
+func (l *RateLimiter) Consumer(ctx context.Context, key string) RateLimitConsumer {
+	consumer := RateLimitConsumer{Key: key}
+	keys := l.consumerKeys(key, &consumer)
+	consumer.Buckets = l.buckets(ctx, keys, time.Now())
+	return consumer
+}
+
+// Reset clears the client's buckets, so its next request starts with every
+// limit full
+func (l *RateLimiter) Reset(ctx context.Context, key string) error {
+	keys := l.consumerKeys(key, nil)
+	now := time.Now()
+	for i, rule := range l.rules {
+		if keys[i] == "" {
+			continue
+		}
+		if err := resetRateLimit(ctx, l.store, rule.storeKey(keys[i]), rule, now); err != nil {
+			return err
 		}
 	}
-	return r.RemoteAddr
+	return nil
 }
 
-func getLimiter(key string, rps, burst int) *rate.Limiter {
-	limiterInstance.mu.RLock()
-	limiter, exists := limiterInstance.limiters[key]
-	limiterInstance.mu.RUnlock()
+// consumerKeys returns the client key per rule for the client, leaving rules
+// it cannot derive empty, and copies the usage into consumer when given
+func (l *RateLimiter) consumerKeys(key string, consumer *RateLimitConsumer) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	if !exists {
-		limiterInstance.mu.Lock()
-		if existing, exists := limiterInstance.limiters[key]; exists {
-			limiter = existing
+	if tracked := l.consumers[key]; tracked != nil {
+		if consumer != nil {
+			consumer.Requests = tracked.requests
+			consumer.Rejected = tracked.rejected
+			consumer.LastSeen = tracked.lastSeen
+		}
+		return tracked.keys
+	}
+
+	keys := make([]string, len(l.rules))
+	for i, rule := range l.rules {
+		if equalParts(rule.key, l.rules[0].key) {
+			keys[i] = key
+		}
+	}
+	return keys
+}
+
+func (l *RateLimiter) buckets(ctx context.Context, keys []string, now time.Time) []RateLimitBucket {
+	buckets := make([]RateLimitBucket, 0, len(l.rules))
+	for i, rule := range l.rules {
+		if keys[i] == "" {
+			continue
+		}
+		bucket := RateLimitBucket{Rule: rule.name, Algorithm: rule.algorithm}
+		result, err := inspectRateLimit(ctx, l.store, rule.storeKey(keys[i]), rule, now)
+		if err != nil {
+			bucket.Error = err.Error()
 		} else {
-			limiter = rate.NewLimiter(rate.Limit(rps), burst)
-			limiterInstance.limiters[key] = limiter
+			bucket.Limit = result.limit
+			bucket.Remaining = result.remaining
+			bucket.ResetAfterMs = result.resetAfter.Milliseconds()
 		}
-		limiterInstance.mu.Unlock()
+		buckets = append(buckets, bucket)
 	}
+	return buckets
+}
 
-	return limiter
+func equalParts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimitRules returns the primary limit followed by the stacked limits
+func rateLimitRules(cfg *config.RateLimitConfig) []rateLimitRule {
+	key := cfg.Key
+	if len(key) == 0 {
+		key = []string{cfg.By}
+	}
 
+	primary := rateLimitRule{
+		name:      "default",
+		limit:     cfg.RequestsPerSecond,
+		period:    time.Second,
+		burst:     cfg.Burst,
+		algorithm: cfg.Algorithm,
+		key:       key,
+	}
+	if cfg.Algorithm == "sliding_window" || cfg.Algorithm == "fixed_window" {
+		window := cfg.Window
+		if window <= 0 {
+			window = time.Second
+		}
+		primary.limit = int(int64(cfg.RequestsPerSecond) * int64(window) / int64(time.Second))
+		primary.period = window
+	}
+
+	rules := []rateLimitRule{primary}
+	for _, limit := range cfg.Limits {
+		ruleKey := limit.Key
+		if len(ruleKey) == 0 {
+			ruleKey = key
+		}
+		rules = append(rules, rateLimitRule{
+			name:      limit.Name,
+			limit:     limit.Requests,
+			period:    limit.Period,
+			burst:     limit.Burst,
+			algorithm: limit.Algorithm,
+			key:       ruleKey,
+		})
+	}
+	return rules
+}
+
+// storeKey scopes the client key to the rule's parameters, so changing a
+// limit starts from a clean slate instead of reusing state kept for another
+func (rule rateLimitRule) storeKey(client string) string {
+	return fmt.Sprintf("%s:%s:%s:%d:%d:%s", client, rule.name, rule.algorithm, rule.limit, rule.burst, rule.period)
+}
+
+func checkRateLimit(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) (rateLimitResult, error) {
+	rule = normalizeRule(rule)
+
+	switch rule.algorithm {
+	case "sliding_window":
+		return slidingWindow(ctx, store, key, rule, now)
+	case "fixed_window":
+		return fixedWindow(ctx, store, key, rule, now)
+	default:
+		return gcra(ctx, store, key, rule, now)
+	}
+}
+
+// inspectRateLimit reports the state of a bucket without consuming it
+func inspectRateLimit(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) (rateLimitResult, error) {
+	rule = normalizeRule(rule)
+
+	switch rule.algorithm {
+	case "sliding_window", "fixed_window":
+		window := rule.period
+		index := now.UnixNano() / int64(window)
+		elapsed := time.Duration(now.UnixNano() - index*int64(window))
+
+		current, err := store.Get(ctx, key+":"+strconv.FormatInt(index, 10))
+		if err != nil {
+			return rateLimitResult{}, err
+		}
+		used := float64(current)
+		if rule.algorithm == "sliding_window" {
+			previous, err := store.Get(ctx, key+":"+strconv.FormatInt(index-1, 10))
+			if err != nil {
+				return rateLimitResult{}, err
+			}
+			used += float64(previous) * float64(window-elapsed) / float64(window)
+		}
+
+		remaining := int(float64(rule.limit) - used)
+		if remaining < 0 {
+			remaining = 0
+		}
+		return rateLimitResult{
+			allowed:    remaining > 0,
+			limit:      rule.limit,
+			remaining:  remaining,
+			resetAfter: window - elapsed,
+		}, nil
+	default:
+		interval := rule.period / time.Duration(rule.limit)
+		burst := rule.burst
+		if burst < 1 {
+			burst = 1
+		}
+
+		stored, err := store.Get(ctx, key)
+		if err != nil {
+			return rateLimitResult{}, err
+		}
+		tat := time.Unix(0, stored)
+		if tat.Before(now) {
+			tat = now
+		}
+
+		remaining := int(now.Add(interval*time.Duration(burst)).Sub(tat) / interval)
+		if remaining < 0 {
+			remaining = 0
+		}
+		return rateLimitResult{
+			allowed:    remaining > 0,
+			limit:      burst,
+			remaining:  remaining,
+			resetAfter: tat.Sub(now),
+		}, nil
+	}
+}
+
+// resetRateLimit deletes a bucket. Window algorithms keep one key per window,
+// so the current and previous windows are both removed.
+func resetRateLimit(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) error {
+	rule = normalizeRule(rule)
+
+	switch rule.algorithm {
+	case "sliding_window", "fixed_window":
+		index := now.UnixNano() / int64(rule.period)
+		for _, i := range []int64{index, index - 1} {
+			if err := store.Delete(ctx, key+":"+strconv.FormatInt(i, 10)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return store.Delete(ctx, key)
+	}
+}
+
+// refundRateLimit gives back a request checkRateLimit allowed at now, for
+// when a later limit rejects it
+func refundRateLimit(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) error {
+	rule = normalizeRule(rule)
+
+	switch rule.algorithm {
+	case "sliding_window":
+		index := now.UnixNano() / int64(rule.period)
+		_, err := store.IncrBy(ctx, key+":"+strconv.FormatInt(index, 10), -1, 2*rule.period)
+		return err
+	case "fixed_window":
+		index := now.UnixNano() / int64(rule.period)
+		resetAfter := time.Duration((index+1)*int64(rule.period) - now.UnixNano())
+		_, err := store.IncrBy(ctx, key+":"+strconv.FormatInt(index, 10), -1, resetAfter)
+		return err
+	default:
+		interval := rule.period / time.Duration(rule.limit)
+		for attempt := 0; attempt < 5; attempt++ {
+			stored, err := store.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			tat := time.Unix(0, stored).Add(-interval)
+			swapped, err := store.CompareAndSwap(ctx, key, stored, tat.UnixNano(), tat.Sub(now))
+			if err != nil || swapped {
+				return err
+			}
+		}
+		return errRateLimitContention
+	}
+}
+
+// normalizeRule guards the algorithms against a zero limit or period
+func normalizeRule(rule rateLimitRule) rateLimitRule {
+	if rule.limit < 1 {
+		rule.limit = 1
+	}
+	if rule.period <= 0 {
+		rule.period = time.Second
+	}
+	return rule
+}
+
+// gcra implements the generic cell rate algorithm. The store holds the
+// theoretical arrival time of the next request; a request is allowed while
+// that time is at most burst emission intervals ahead of now. It behaves like
+// a token bucket holding burst tokens refilled at limit per period.
+func gcra(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) (rateLimitResult, error) {
+	interval := rule.period / time.Duration(rule.limit)
+	burst := rule.burst
+	if burst < 1 {
+		burst = 1
+	}
+	tolerance := interval * time.Duration(burst)
+
+	for attempt := 0; attempt < 5; attempt++ {
+		stored, err := store.Get(ctx, key)
+		if err != nil {
+			return rateLimitResult{}, err
+		}
+
+		tat := time.Unix(0, stored)
+		if tat.Before(now) {
+			tat = now
+		}
+		newTAT := tat.Add(interval)
+		allowAt := newTAT.Add(-tolerance)
+
+		if now.Before(allowAt) {
+			return rateLimitResult{
+				limit:      burst,
+				resetAfter: tat.Sub(now),
+				retryAfter: allowAt.Sub(now),
+			}, nil
+		}
+
+		swapped, err := store.CompareAndSwap(ctx, key, stored, newTAT.UnixNano(), newTAT.Sub(now))
+		if err != nil {
+			return rateLimitResult{}, err
+		}
+		if swapped {
+			return rateLimitResult{
+				allowed:    true,
+				limit:      burst,
+				remaining:  int(now.Sub(allowAt) / interval),
+				resetAfter: newTAT.Sub(now),
+			}, nil
+		}
+	}
+
+	return rateLimitResult{}, errRateLimitContention
+}
+
+// slidingWindow approximates a sliding window by weighting the previous fixed
+// window's count by how much of it still overlaps the sliding window
+func slidingWindow(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) (rateLimitResult, error) {
+	window := rule.period
+	limit := int64(rule.limit)
+
+	index := now.UnixNano() / int64(window)
+	elapsed := time.Duration(now.UnixNano() - index*int64(window))
+	currentKey := key + ":" + strconv.FormatInt(index, 10)
+	previousKey := key + ":" + strconv.FormatInt(index-1, 10)
+
+	previous, err := store.Get(ctx, previousKey)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	current, err := store.IncrBy(ctx, currentKey, 1, 2*window)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+
+	overlap := float64(window-elapsed) / float64(window)
+	weighted := float64(previous)*overlap + float64(current)
+	resetAfter := window - elapsed
+
+	if weighted <= float64(limit) {
+		return rateLimitResult{
+			allowed:    true,
+			limit:      int(limit),
+			remaining:  int(float64(limit) - weighted),
+			resetAfter: resetAfter,
+		}, nil
+	}
+
+	// Rejected requests do not count against the window
+	if _, err := store.IncrBy(ctx, currentKey, -1, 2*window); err != nil {
+		return rateLimitResult{}, err
+	}
+
+	// Wait for the previous window's weight to decay enough, or for the
+	// current window to end when it is full by itself
+	retryAfter := resetAfter
+	if current <= limit && previous > 0 {
+		freeAt := time.Duration(float64(window) * (1 - float64(limit-current)/float64(previous)))
+		if freeAt > elapsed {
+			retryAfter = freeAt - elapsed
+		}
+	}
+
+	return rateLimitResult{
+		limit:      int(limit),
+		resetAfter: resetAfter,
+		retryAfter: retryAfter,
+	}, nil
+}
+
+// fixedWindow counts requests in windows aligned to the Unix epoch, so a 24h
+// quota resets at midnight UTC
+func fixedWindow(ctx context.Context, store RateLimitStore, key string, rule rateLimitRule, now time.Time) (rateLimitResult, error) {
+	window := rule.period
+	limit := int64(rule.limit)
+
+	index := now.UnixNano() / int64(window)
+	resetAfter := time.Duration((index+1)*int64(window) - now.UnixNano())
+	windowKey := key + ":" + strconv.FormatInt(index, 10)
+
+	// The key lives until its window ends, which is what lets the memory
+	// store persist long windows and expire them on time
+	count, err := store.IncrBy(ctx, windowKey, 1, resetAfter)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+
+	if count <= limit {
+		return rateLimitResult{
+			allowed:    true,
+			limit:      int(limit),
+			remaining:  int(limit - count),
+			resetAfter: resetAfter,
+		}, nil
+	}
+
+	if _, err := store.IncrBy(ctx, windowKey, -1, resetAfter); err != nil {
+		return rateLimitResult{}, err
+	}
+	return rateLimitResult{
+		limit:      int(limit),
+		resetAfter: resetAfter,
+		retryAfter: resetAfter,
+	}, nil
+}
+
+func logStoreError(err error) {
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&storeErrorLogged)
+	if now-last < 10 || !atomic.CompareAndSwapInt64(&storeErrorLogged, last, now) {
+		return
+	}
+	log.Printf("Rate limit store error, allowing requests: %v", err)
+}
+
+// clientKey builds the composite client key from the configured parts.
+// Values are escaped so one part cannot forge another. An auth part that is
+// empty, as for anonymous requests, is replaced by the client IP, so that
+// anonymous clients do not share one bucket.
+func clientKey(r *http.Request, parts []string) string {
+	values := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name, value := part, keyPart(r, part)
+		if value == "" && strings.HasPrefix(part, "auth.") {
+			name, value = "ip", clientIP(r)
+		}
+		values = append(values, name+"="+url.QueryEscape(value))
+	}
+	return strings.Join(values, "&")
+}
+
+func keyPart(r *http.Request, part string) string {
+	if name, ok := strings.CutPrefix(part, "header:"); ok {
+		return r.Header.Get(name)
+	}
+	if name, ok := strings.CutPrefix(part, "path:"); ok {
+		return mux.Vars(r)[name]
+	}
+
+	authCtx := auth.GetAuthContext(r)
+	switch part {
+	case "method":
+		return r.Method
+	case "route":
+		if route := mux.CurrentRoute(r); route != nil {
+			return route.GetName()
+		}
+		return ""
+	case "client_id":
+		// Only an authenticated identity counts; X-Client-ID is client-settable
+		if authCtx.ClientID != "" {
+			return authCtx.ClientID
+		}
+		return clientIP(r)
+	case "auth.user_id":
+		return authCtx.UserID
+	case "auth.client_id":
+		return authCtx.ClientID
+	case "auth.identity":
+		if authCtx.ClientID != "" {
+			return authCtx.ClientID
+		}
+		return authCtx.UserID
+	case "auth.identity_type":
+		return authCtx.IdentityType
+	case "auth.cert_common_name":
+		return authCtx.CertCommonName
+	default: // "ip"
+		return clientIP(r)
+	}
 }
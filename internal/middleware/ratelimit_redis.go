@@ -0,0 +1,351 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// RedisRateLimitStore keeps state in any server that speaks the Redis
+// protocol. Compare-and-swap uses WATCH/MULTI/EXEC, so no scripting support
+// is needed.
+type RedisRateLimitStore struct {
+	address  string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+	pool     chan *redisConn
+}
+
+// redisError is an error reply from the server. The connection stays usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// errRedisNil is returned for nil bulk and nil array replies
+var errRedisNil = errors.New("redis: nil reply")
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// NewRedisRateLimitStore creates a store that connects lazily, so the gateway
+// starts even while Redis is unreachable
+func NewRedisRateLimitStore(cfg config.RateLimitStoreConfig) *RedisRateLimitStore {
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = 10
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 250 * time.Millisecond
+	}
+
+	return &RedisRateLimitStore{
+		address:  cfg.Address,
+		password: cfg.Password,
+		db:       cfg.DB,
+		prefix:   cfg.KeyPrefix,
+		timeout:  timeout,
+		pool:     make(chan *redisConn, poolSize),
+	}
+}
+
+func (s *RedisRateLimitStore) Get(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := s.withConn(ctx, func(c *redisConn) error {
+		reply, err := c.do("GET", s.prefix+key)
+		if err == errRedisNil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value, err = replyInt(reply)
+		return err
+	})
+	return value, err
+}
+
+func (s *RedisRateLimitStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	var value int64
+	err := s.withConn(ctx, func(c *redisConn) error {
+		replies, err := c.pipeline(
+			[]string{"MULTI"},
+			[]string{"INCRBY", s.prefix + key, strconv.FormatInt(delta, 10)},
+			[]string{"PEXPIRE", s.prefix + key, formatMillis(ttl)},
+			[]string{"EXEC"},
+		)
+		if err != nil {
+			return err
+		}
+		results, ok := replies[3].([]interface{})
+		if !ok || len(results) != 2 {
+			return fmt.Errorf("redis: unexpected EXEC reply %v", replies[3])
+		}
+		value, err = replyInt(results[0])
+		return err
+	})
+	return value, err
+}
+
+func (s *RedisRateLimitStore) CompareAndSwap(ctx context.Context, key string, old, value int64, ttl time.Duration) (bool, error) {
+	swapped := false
+	err := s.withConn(ctx, func(c *redisConn) error {
+		fullKey := s.prefix + key
+		if _, err := c.do("WATCH", fullKey); err != nil {
+			return err
+		}
+
+		// A connection goes back to the pool only after the watch has ended,
+		// or its next transaction would also depend on this key
+		unwatch := func(err error) error {
+			if _, unwatchErr := c.do("UNWATCH"); err == nil {
+				err = unwatchErr
+			}
+			return err
+		}
+
+		var current int64
+		reply, err := c.do("GET", fullKey)
+		if err != nil && err != errRedisNil {
+			return unwatch(err)
+		}
+		if err == nil {
+			if current, err = replyInt(reply); err != nil {
+				return unwatch(err)
+			}
+		}
+		if current != old {
+			return unwatch(nil)
+		}
+
+		replies, err := c.pipeline(
+			[]string{"MULTI"},
+			[]string{"SET", fullKey, strconv.FormatInt(value, 10), "PX", formatMillis(ttl)},
+			[]string{"EXEC"},
+		)
+		if err == errRedisNil {
+			return nil // another gateway changed the key first
+		}
+		if err != nil {
+			// EXEC ends the watch, but not when MULTI itself was refused
+			return unwatch(err)
+		}
+		_, swapped = replies[2].([]interface{})
+		return nil
+	})
+	return swapped, err
+}
+
+func (s *RedisRateLimitStore) Delete(ctx context.Context, key string) error {
+	return s.withConn(ctx, func(c *redisConn) error {
+		_, err := c.do("DEL", s.prefix+key)
+		return err
+	})
+}
+
+func (s *RedisRateLimitStore) Close() error {
+	for {
+		select {
+		case c := <-s.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// withConn runs fn on a pooled connection. Connections that saw an I/O
+// error are closed instead of being returned to the pool.
+func (s *RedisRateLimitStore) withConn(ctx context.Context, fn func(c *redisConn) error) error {
+	c, err := s.getConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	c.conn.SetDeadline(deadline)
+
+	err = fn(c)
+	var replyErr redisError
+	if err != nil && err != errRedisNil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return err
+	}
+
+	select {
+	case s.pool <- c:
+	default:
+		c.conn.Close()
+	}
+	return err
+}
+
+func (s *RedisRateLimitStore) getConn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-s.pool:
+		return c, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	c := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if s.password != "" {
+		if _, err := c.do("AUTH", s.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	replies, err := c.pipeline(args)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends all commands before reading any reply. The first error
+// reply is returned after every reply has been read, keeping the connection
+// in sync.
+func (c *redisConn) pipeline(commands ...[]string) ([]interface{}, error) {
+	for _, args := range commands {
+		fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	var firstErr error
+	for i := range commands {
+		reply, err := readReply(c.reader)
+		if err != nil && err != errRedisNil {
+			var replyErr redisError
+			if !errors.As(err, &replyErr) {
+				return nil, err
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if size < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if count < 0 {
+			return nil, errRedisNil
+		}
+		// Every element is read, so the connection stays in sync. Error
+		// replies, such as a failed command inside EXEC, are kept as values.
+		items := make([]interface{}, count)
+		for i := range items {
+			item, err := readReply(r)
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil && err != errRedisNil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
+
+func replyInt(reply interface{}) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case redisError:
+		return 0, v
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+}
+
+func formatMillis(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
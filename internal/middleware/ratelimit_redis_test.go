@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// fakeRedis is a minimal stand-in for a Redis server that implements the
+// commands used by RedisRateLimitStore
+type fakeRedis struct {
+	listener net.Listener
+	values   map[string]int64
+	versions map[string]int
+	lists    map[string]bool // keys holding a list, which GET refuses
+	password string
+	mu       sync.Mutex
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &fakeRedis{
+		listener: listener,
+		values:   make(map[string]int64),
+		versions: make(map[string]int),
+		lists:    make(map[string]bool),
+		password: password,
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authed := f.password == ""
+	watched := map[string]int{}
+	var queued [][]string
+	inMulti := false
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+
+		if !authed && name != "AUTH" {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		switch {
+		case name == "AUTH":
+			if args[1] != f.password {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case name == "MULTI":
+			inMulti = true
+			queued = nil
+			fmt.Fprint(conn, "+OK\r\n")
+		case name == "EXEC":
+			f.mu.Lock()
+			aborted := false
+			for key, version := range watched {
+				if f.versions[key] != version {
+					aborted = true
+				}
+			}
+			if aborted {
+				fmt.Fprint(conn, "*-1\r\n")
+			} else {
+				fmt.Fprintf(conn, "*%d\r\n", len(queued))
+				for _, command := range queued {
+					fmt.Fprint(conn, f.apply(command))
+				}
+			}
+			f.mu.Unlock()
+			inMulti, queued, watched = false, nil, map[string]int{}
+		case inMulti:
+			queued = append(queued, args)
+			fmt.Fprint(conn, "+QUEUED\r\n")
+		case name == "WATCH":
+			f.mu.Lock()
+			watched[args[1]] = f.versions[args[1]]
+			f.mu.Unlock()
+			fmt.Fprint(conn, "+OK\r\n")
+		case name == "UNWATCH":
+			watched = map[string]int{}
+			fmt.Fprint(conn, "+OK\r\n")
+		default:
+			f.mu.Lock()
+			fmt.Fprint(conn, f.apply(args))
+			f.mu.Unlock()
+		}
+	}
+}
+
+// apply runs one data command. Callers must hold f.mu.
+func (f *fakeRedis) apply(args []string) string {
+	key := args[1]
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if f.lists[key] {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		value, exists := f.values[key]
+		if !exists {
+			return "$-1\r\n"
+		}
+		text := strconv.FormatInt(value, 10)
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(text), text)
+	case "INCRBY":
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		f.values[key] += delta
+		f.versions[key]++
+		return fmt.Sprintf(":%d\r\n", f.values[key])
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "SET":
+		value, _ := strconv.ParseInt(args[2], 10, 64)
+		f.values[key] = value
+		f.versions[key]++
+		return "+OK\r\n"
+	case "DEL":
+		_, exists := f.values[key]
+		delete(f.values, key)
+		f.versions[key]++
+		if !exists {
+			return ":0\r\n"
+		}
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// touch changes a key the way another client would
+func (f *fakeRedis) touch(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.values[key]++
+	f.versions[key]++
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	reply, err := readReply(reader)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("expected command array")
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i] = item.(string)
+	}
+	return args, nil
+}
+
+func TestRedisRateLimitStoreSharesLimitAcrossGateways(t *testing.T) {
+	server := startFakeRedis(t, "s3cret")
+	storeConfig := config.RateLimitStoreConfig{
+		Type:      "redis",
+		Address:   server.listener.Addr().String(),
+		Password:  "s3cret",
+		KeyPrefix: "gonk:",
+		Timeout:   time.Second,
+	}
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Burst: 2, By: "ip", Algorithm: "gcra"}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	gateways := []http.Handler{
+		RateLimit(cfg, NewRedisRateLimitStore(storeConfig), next),
+		RateLimit(cfg, NewRedisRateLimitStore(storeConfig), next),
+	}
+
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = "198.51.100.10:1234"
+		rr := httptest.NewRecorder()
+		gateways[i%2].ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+
+	want := []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", codes, want)
+		}
+	}
+}
+
+func TestRedisRateLimitStoreCompareAndSwapDetectsConcurrentWrite(t *testing.T) {
+	server := startFakeRedis(t, "")
+	store := NewRedisRateLimitStore(config.RateLimitStoreConfig{Address: server.listener.Addr().String(), Timeout: time.Second})
+	defer store.Close()
+
+	ctx := context.Background()
+	if swapped, err := store.CompareAndSwap(ctx, "tat", 0, 42, time.Minute); err != nil || !swapped {
+		t.Fatalf("CompareAndSwap() on missing key = %v, %v, want swapped", swapped, err)
+	}
+	if swapped, err := store.CompareAndSwap(ctx, "tat", 0, 43, time.Minute); err != nil || swapped {
+		t.Fatalf("CompareAndSwap() with stale value = %v, %v, want not swapped", swapped, err)
+	}
+
+	server.touch("tat")
+	if value, err := store.Get(ctx, "tat"); err != nil || value != 43 {
+		t.Fatalf("Get() = %d, %v, want 43", value, err)
+	}
+	if value, err := store.IncrBy(ctx, "counter", 5, time.Minute); err != nil || value != 5 {
+		t.Fatalf("IncrBy() = %d, %v, want 5", value, err)
+	}
+}
+
+func TestRedisReadReplyReadsWholeArrayPastErrorReply(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("*3\r\n-ERR value is not an integer\r\n:1\r\n$-1\r\n+OK\r\n"))
+
+	reply, err := readReply(reader)
+	if err != nil {
+		t.Fatalf("readReply() returned error: %v", err)
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 3 || items[0] != redisError("ERR value is not an integer") || items[1] != int64(1) || items[2] != nil {
+		t.Fatalf("reply = %#v, want the error reply kept as the first of three elements", reply)
+	}
+	if _, err := replyInt(items[0]); err != redisError("ERR value is not an integer") {
+		t.Fatalf("replyInt() on an error element = %v, want the error reply", err)
+	}
+
+	// The next reply is read from where the array ended
+	if reply, err := readReply(reader); err != nil || reply != "OK" {
+		t.Fatalf("next readReply() = %#v, %v, want OK", reply, err)
+	}
+}
+
+func TestRedisRateLimitStoreCompareAndSwapUnwatchesOnError(t *testing.T) {
+	server := startFakeRedis(t, "")
+	server.lists["state"] = true
+	store := NewRedisRateLimitStore(config.RateLimitStoreConfig{Address: server.listener.Addr().String(), Timeout: time.Second, PoolSize: 1})
+	defer store.Close()
+
+	ctx := context.Background()
+	if _, err := store.CompareAndSwap(ctx, "state", 0, 1, time.Minute); err == nil {
+		t.Fatal("CompareAndSwap() on a list returned no error")
+	}
+
+	// The pooled connection must not still watch the first key
+	server.touch("state")
+	if swapped, err := store.CompareAndSwap(ctx, "tat", 0, 42, time.Minute); err != nil || !swapped {
+		t.Fatalf("CompareAndSwap() after a failed swap = %v, %v, want swapped", swapped, err)
+	}
+}
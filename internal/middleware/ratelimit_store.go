@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// RateLimitStore holds rate limit state. Values are integers that expire
+// after their TTL, so idle keys do not accumulate. Implementations must be
+// safe for concurrent use; a shared store makes limits span gateways.
+type RateLimitStore interface {
+	// Get returns the value at key, or 0 when the key does not exist
+	Get(ctx context.Context, key string) (int64, error)
+	// IncrBy adds delta to the value at key, creating it at 0, refreshes
+	// its TTL and returns the new value
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// CompareAndSwap stores value with the given TTL only if key still holds
+	// old, where a missing key holds 0
+	CompareAndSwap(ctx context.Context, key string, old, value int64, ttl time.Duration) (bool, error)
+	// Delete removes key, which is not an error when it does not exist
+	Delete(ctx context.Context, key string) error
+	Close() error
+}
+
+// NewRateLimitStore creates the store selected by the configuration
+func NewRateLimitStore(cfg config.RateLimitStoreConfig) (RateLimitStore, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewMemoryRateLimitStore(cfg), nil
+	case "redis":
+		return NewRedisRateLimitStore(cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store %q", cfg.Type)
+	}
+}
+
+type memoryEntry struct {
+	value   int64
+	expires time.Time
+	ttl     time.Duration
+}
+
+// MemoryRateLimitStore keeps state in process and evicts expired keys in the
+// background. With a persist path, keys that live at least the persist
+// minimum TTL, such as daily quotas, are written to disk and reloaded on start.
+type MemoryRateLimitStore struct {
+	entries     map[string]memoryEntry
+	persistPath string
+	persistTTL  time.Duration
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	mu          sync.Mutex
+
+	persistMu sync.Mutex // serializes writes to the persist path
+	handedOff bool       // the persist path belongs to a newer store
+}
+
+// persistedEntry is the on-disk form of a memory store entry
+type persistedEntry struct {
+	Value   int64         `json:"value"`
+	Expires time.Time     `json:"expires"`
+	TTL     time.Duration `json:"ttl"`
+}
+
+// NewMemoryRateLimitStore creates an in-memory store. Zero intervals fall
+// back to sweeping every minute and persisting every 30 seconds.
+func NewMemoryRateLimitStore(cfg config.RateLimitStoreConfig) *MemoryRateLimitStore {
+	cleanupInterval := cfg.CleanupInterval
+	if cleanupInterval <= 0 {
+		cleanupInterval = time.Minute
+	}
+	persistInterval := cfg.PersistInterval
+	if persistInterval <= 0 {
+		persistInterval = 30 * time.Second
+	}
+
+	s := &MemoryRateLimitStore{
+		entries:     make(map[string]memoryEntry),
+		persistPath: cfg.PersistPath,
+		persistTTL:  cfg.PersistMinTTL,
+		stopCh:      make(chan struct{}),
+	}
+	if s.persistPath != "" {
+		if err := s.restore(time.Now()); err != nil {
+			log.Printf("Failed to restore rate limit quotas from %s, starting empty: %v", s.persistPath, err)
+		}
+	}
+	go s.maintenanceLoop(cleanupInterval, persistInterval)
+	return s
+}
+
+func (s *MemoryRateLimitStore) Get(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load(key, time.Now()), nil
+}
+
+func (s *MemoryRateLimitStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	value := s.load(key, now) + delta
+	s.entries[key] = memoryEntry{value: value, expires: now.Add(ttl), ttl: ttl}
+	return value, nil
+}
+
+func (s *MemoryRateLimitStore) CompareAndSwap(ctx context.Context, key string, old, value int64, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.load(key, now) != old {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{value: value, expires: now.Add(ttl), ttl: ttl}
+	return true, nil
+}
+
+func (s *MemoryRateLimitStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// Len returns the number of keys held, including expired keys not yet swept
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// Close stops background maintenance and writes persisted quotas one last time
+func (s *MemoryRateLimitStore) Close() error {
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.persistPath != "" {
+			err = s.persist(time.Now())
+		}
+	})
+	return err
+}
+
+// Handoff writes persisted quotas one last time and stops writing them, so a
+// store that replaces this one owns the persist path from then on. The store
+// keeps serving the requests still using it.
+func (s *MemoryRateLimitStore) Handoff() error {
+	if s.persistPath == "" {
+		return nil
+	}
+
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	if s.handedOff {
+		return nil
+	}
+	s.handedOff = true
+	return s.writeSnapshot(time.Now())
+}
+
+// load returns the live value at key. Callers must hold s.mu.
+func (s *MemoryRateLimitStore) load(key string, now time.Time) int64 {
+	entry, exists := s.entries[key]
+	if !exists {
+		return 0
+	}
+	if !now.Before(entry.expires) {
+		delete(s.entries, key)
+		return 0
+	}
+	return entry.value
+}
+
+func (s *MemoryRateLimitStore) maintenanceLoop(cleanupInterval, persistInterval time.Duration) {
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	var persistC <-chan time.Time
+	if s.persistPath != "" {
+		persist := time.NewTicker(persistInterval)
+		defer persist.Stop()
+		persistC = persist.C
+	}
+
+	for {
+		select {
+		case <-cleanup.C:
+			s.sweep(time.Now())
+		case <-persistC:
+			if err := s.persist(time.Now()); err != nil {
+				log.Printf("Failed to persist rate limit quotas to %s: %v", s.persistPath, err)
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// persist writes the long-lived keys to the persist path, unless the store
+// has handed it off
+func (s *MemoryRateLimitStore) persist(now time.Time) error {
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+	if s.handedOff {
+		return nil
+	}
+	return s.writeSnapshot(now)
+}
+
+// writeSnapshot writes the long-lived keys to a temporary file and renames it
+// over the persist path, so a crash never leaves a half-written file behind.
+// Callers must hold s.persistMu.
+func (s *MemoryRateLimitStore) writeSnapshot(now time.Time) error {
+	s.mu.Lock()
+	snapshot := make(map[string]persistedEntry)
+	for key, entry := range s.entries {
+		if entry.ttl >= s.persistTTL && now.Before(entry.expires) {
+			snapshot[key] = persistedEntry{Value: entry.value, Expires: entry.expires, TTL: entry.ttl}
+		}
+	}
+	s.mu.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.persistPath), filepath.Base(s.persistPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.persistPath)
+}
+
+func (s *MemoryRateLimitStore) restore(now time.Time) error {
+	data, err := os.ReadFile(s.persistPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot map[string]persistedEntry
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range snapshot {
+		if now.Before(entry.Expires) {
+			s.entries[key] = memoryEntry{value: entry.Value, expires: entry.Expires, ttl: entry.TTL}
+		}
+	}
+	return nil
+}
@@ -1,10 +1,15 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/JustVugg/gonk/internal/auth"
 	"github.com/JustVugg/gonk/internal/config"
 )
 
@@ -16,8 +21,11 @@ func TestRateLimitRejectsRequestsAfterBurst(t *testing.T) {
 		By:                "ip",
 	}
 
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{})
+	defer store.Close()
+
 	calls := 0
-	handler := RateLimit(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := RateLimit(cfg, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		calls++
 		w.WriteHeader(http.StatusNoContent)
 	}))
@@ -41,3 +49,301 @@ func TestRateLimitRejectsRequestsAfterBurst(t *testing.T) {
 		t.Fatalf("next handler calls = %d, want 1", calls)
 	}
 }
+
+func TestGCRAAllowsBurstThenRefillsAtRate(t *testing.T) {
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{})
+	defer store.Close()
+
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 10, Burst: 3, Algorithm: "gcra"}
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		result, err := checkRateLimit(context.Background(), store, "client", rateLimitRules(cfg)[0], now)
+		if err != nil || !result.allowed {
+			t.Fatalf("request %d = %+v, %v, want allowed within burst", i, result, err)
+		}
+		if result.remaining != 2-i {
+			t.Fatalf("request %d remaining = %d, want %d", i, result.remaining, 2-i)
+		}
+	}
+
+	result, err := checkRateLimit(context.Background(), store, "client", rateLimitRules(cfg)[0], now)
+	if err != nil || result.allowed {
+		t.Fatalf("request over burst = %+v, %v, want rejected", result, err)
+	}
+	if result.retryAfter != 100*time.Millisecond {
+		t.Fatalf("retryAfter = %s, want 100ms", result.retryAfter)
+	}
+
+	result, err = checkRateLimit(context.Background(), store, "client", rateLimitRules(cfg)[0], now.Add(100*time.Millisecond))
+	if err != nil || !result.allowed {
+		t.Fatalf("request after one interval = %+v, %v, want allowed", result, err)
+	}
+}
+
+func TestSlidingWindowWeightsPreviousWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{})
+	defer store.Close()
+
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Algorithm: "sliding_window", Window: 10 * time.Second}
+	start := time.Unix(1700000000, 0)
+
+	for i := 0; i < 10; i++ {
+		if result, _ := checkRateLimit(context.Background(), store, "client", rateLimitRules(cfg)[0], start); !result.allowed {
+			t.Fatalf("request %d rejected, want 10 allowed per window", i)
+		}
+	}
+	if result, _ := checkRateLimit(context.Background(), store, "client", rateLimitRules(cfg)[0], start); result.allowed {
+		t.Fatal("11th request in the window was allowed")
+	}
+
+	// A quarter into the next window, 75% of the previous count still applies
+	midway := start.Add(12500 * time.Millisecond)
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if result, _ := checkRateLimit(context.Background(), store, "client", rateLimitRules(cfg)[0], midway); result.allowed {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("allowed = %d a quarter into the next window, want 2", allowed)
+	}
+}
+
+func TestMemoryRateLimitStoreExpiresIdleKeys(t *testing.T) {
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{CleanupInterval: time.Hour})
+	defer store.Close()
+
+	ctx := context.Background()
+	if _, err := store.IncrBy(ctx, "idle", 1, time.Millisecond); err != nil {
+		t.Fatalf("IncrBy() returned error: %v", err)
+	}
+	if _, err := store.IncrBy(ctx, "active", 1, time.Hour); err != nil {
+		t.Fatalf("IncrBy() returned error: %v", err)
+	}
+
+	store.sweep(time.Now().Add(time.Second))
+	if store.Len() != 1 {
+		t.Fatalf("keys after sweep = %d, want only the active key", store.Len())
+	}
+	if value, _ := store.Get(ctx, "idle"); value != 0 {
+		t.Fatalf("expired value = %d, want 0", value)
+	}
+}
+
+func TestRateLimitFailsOpenWhenStoreIsUnavailable(t *testing.T) {
+	store := NewRedisRateLimitStore(config.RateLimitStoreConfig{Address: "127.0.0.1:1", Timeout: 50 * time.Millisecond})
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Burst: 1, By: "ip"}
+
+	handler := RateLimit(cfg, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/limited", nil))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("status with unreachable store = %d, want %d", rr.Code, http.StatusNoContent)
+		}
+	}
+}
+
+func TestRateLimitStackedQuotaIgnoresRequestsRejectedEarlier(t *testing.T) {
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{})
+	defer store.Close()
+
+	cfg := &config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 1,
+		Burst:             2,
+		Algorithm:         "gcra",
+		Key:               []string{"header:X-Device-ID"},
+		Limits: []config.RateLimitRule{
+			{Name: "daily", Requests: 3, Period: 24 * time.Hour, Algorithm: "fixed_window"},
+		},
+	}
+	handler := RateLimit(cfg, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	codes := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/telemetry", nil)
+		req.Header.Set("X-Device-ID", "plc-7")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		codes = append(codes, rr.Code)
+	}
+	want := []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", codes, want)
+		}
+	}
+
+	// The third request was stopped by the per-second limit, so the daily
+	// quota has one request left
+	rule := rateLimitRules(cfg)[1]
+	req := httptest.NewRequest(http.MethodGet, "/telemetry", nil)
+	req.Header.Set("X-Device-ID", "plc-7")
+	result, err := checkRateLimit(context.Background(), store, rule.storeKey(clientKey(req, rule.key)), rule, time.Now())
+	if err != nil || !result.allowed || result.remaining != 0 {
+		t.Fatalf("daily quota = %+v, %v, want the last request allowed", result, err)
+	}
+}
+
+func TestRateLimitStackedRejectionRefundsEarlierLimits(t *testing.T) {
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{})
+	defer store.Close()
+
+	cfg := &config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 1,
+		Burst:             100,
+		Algorithm:         "gcra",
+		Key:               []string{"header:X-Device-ID"},
+		Limits: []config.RateLimitRule{
+			{Name: "daily", Requests: 5, Period: 24 * time.Hour, Algorithm: "fixed_window"},
+			{Name: "hourly", Requests: 3, Period: time.Hour, Algorithm: "sliding_window"},
+			{Name: "minute", Requests: 2, Period: time.Minute, Algorithm: "gcra", Burst: 2},
+		},
+	}
+	handler := RateLimit(cfg, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/telemetry", nil)
+		req.Header.Set("X-Device-ID", "plc-7")
+		return req
+	}
+	codes := make([]int, 0, 4)
+	for i := 0; i < 4; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, request())
+		codes = append(codes, rr.Code)
+	}
+	want := []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", codes, want)
+		}
+	}
+
+	// Only the two allowed requests count against the limits before the
+	// per-minute one that rejected the rest
+	remaining := map[string]int{"default": 98, "daily": 3, "hourly": 1}
+	for _, rule := range rateLimitRules(cfg)[:3] {
+		result, err := inspectRateLimit(context.Background(), store, rule.storeKey(clientKey(request(), rule.key)), rule, time.Now())
+		if err != nil || result.remaining != remaining[rule.name] {
+			t.Fatalf("%s limit remaining = %d, %v, want %d", rule.name, result.remaining, err, remaining[rule.name])
+		}
+	}
+}
+
+func TestRateLimitCompositeKeyUsesAuthContextNotClientHeader(t *testing.T) {
+	request := func(clientID, headerClientID, method string) *http.Request {
+		req := httptest.NewRequest(method, "/limited", nil)
+		req.RemoteAddr = "198.51.100.10:1234"
+		req.Header.Set("X-Client-ID", headerClientID)
+		if clientID != "" {
+			req = auth.StoreAuthContext(req, &auth.AuthContext{Authenticated: true, ClientID: clientID})
+		}
+		return req
+	}
+
+	// Anonymous callers cannot pick a fresh bucket by changing X-Client-ID
+	if a, b := clientKey(request("", "one", http.MethodGet), []string{"client_id"}), clientKey(request("", "two", http.MethodGet), []string{"client_id"}); a != b {
+		t.Fatalf("client_id keys differ by X-Client-ID header: %q and %q", a, b)
+	}
+
+	parts := []string{"auth.client_id", "method"}
+	get := clientKey(request("device-1", "", http.MethodGet), parts)
+	post := clientKey(request("device-1", "", http.MethodPost), parts)
+	other := clientKey(request("device-2", "", http.MethodGet), parts)
+	if get == post || get == other {
+		t.Fatalf("composite keys collide: %q, %q, %q", get, post, other)
+	}
+	if get != "auth.client_id=device-1&method=GET" {
+		t.Fatalf("composite key = %q", get)
+	}
+
+	// Anonymous callers are told apart by IP rather than sharing the key of
+	// an empty identity
+	anonymous := request("", "", http.MethodGet)
+	neighbour := request("", "", http.MethodGet)
+	neighbour.RemoteAddr = "198.51.100.11:1234"
+	if a, b := clientKey(anonymous, parts), clientKey(neighbour, parts); a == b || a != "ip=198.51.100.10&method=GET" {
+		t.Fatalf("anonymous keys = %q and %q, want one per client IP", a, b)
+	}
+}
+
+func TestMemoryRateLimitStorePersistsLongWindowQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	cfg := config.RateLimitStoreConfig{PersistPath: path, PersistMinTTL: time.Hour}
+
+	store := NewMemoryRateLimitStore(cfg)
+	ctx := context.Background()
+	store.IncrBy(ctx, "daily", 41, 24*time.Hour)
+	store.IncrBy(ctx, "per-second", 5, 2*time.Second)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	restored := NewMemoryRateLimitStore(cfg)
+	defer restored.Close()
+
+	if value, _ := restored.Get(ctx, "daily"); value != 41 {
+		t.Fatalf("restored daily quota = %d, want 41", value)
+	}
+	if value, _ := restored.Get(ctx, "per-second"); value != 0 {
+		t.Fatalf("restored short-window counter = %d, want 0", value)
+	}
+}
+
+func TestRateLimitHeadersReportTightestLimit(t *testing.T) {
+	store := NewMemoryRateLimitStore(config.RateLimitStoreConfig{})
+	defer store.Close()
+
+	cfg := &config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             10,
+		By:                "ip",
+		Algorithm:         "gcra",
+		Limits: []config.RateLimitRule{
+			{Name: "daily", Requests: 2, Period: 24 * time.Hour, Algorithm: "fixed_window"},
+		},
+	}
+	handler := RateLimit(cfg, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = "203.0.113.9:4000"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve()
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("first status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("headers = %v, want the daily limit with 1 remaining", rr.Header())
+	}
+	reset, err := strconv.Atoi(rr.Header().Get("RateLimit-Reset"))
+	if err != nil || reset < 1 || reset > 86400 {
+		t.Fatalf("RateLimit-Reset = %q, want seconds until the daily window ends", rr.Header().Get("RateLimit-Reset"))
+	}
+
+	serve()
+	rr = serve()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("third status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("Retry-After") != rr.Header().Get("RateLimit-Reset") {
+		t.Fatalf("rejection headers = %v, want Retry-After at the end of the daily window", rr.Header())
+	}
+}
@@ -2,24 +2,27 @@ package middleware
 
 import (
 	"bufio"
+	"bytes"
 	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/JustVugg/gonk/internal/auth"
 	"github.com/JustVugg/gonk/internal/config"
 )
 
 func Transform(config *config.TransformConfig, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := &transformVars{request: r}
+
 		// Apply request transformations
 		if config != nil && config.Request != nil {
 			// Add headers
 			if config.Request.AddHeaders != nil {
 				for k, v := range config.Request.AddHeaders {
-					// Simple variable substitution
-					v = strings.ReplaceAll(v, "${request_id}", generateRequestID())
-					v = strings.ReplaceAll(v, "${remote_addr}", r.RemoteAddr)
-					r.Header.Set(k, v)
+					r.Header.Set(k, vars.expand(v))
 				}
 			}
 
@@ -29,22 +32,106 @@ func Transform(config *config.TransformConfig, next http.Handler) http.Handler {
 					r.Header.Del(h)
 				}
 			}
+
+			transformQuery(r, config.Request, vars)
+
+			if config.Request.Method != "" {
+				r.Method = config.Request.Method
+			}
+
+			if hasBodyOperations(config.Request) {
+				transformRequestBody(r, config.Request, vars)
+			}
 		}
 
 		// Wrap response writer for response transformations
 		wrapped := &transformResponseWriter{
 			ResponseWriter: w,
 			config:         config,
+			vars:           vars,
+			head:           r.Method == http.MethodHead,
 		}
 
 		next.ServeHTTP(wrapped, r)
+		wrapped.finish()
 	})
 }
 
+// transformVars expands ${...} variables in configured values. The request
+// ID is generated once, so every value of one request carries the same ID.
+type transformVars struct {
+	request   *http.Request
+	requestID string
+}
+
+func (v *transformVars) expand(value string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	if strings.Contains(value, "${request_id}") {
+		if v.requestID == "" {
+			v.requestID = generateRequestID()
+		}
+		value = strings.ReplaceAll(value, "${request_id}", v.requestID)
+	}
+	value = strings.ReplaceAll(value, "${remote_addr}", v.request.RemoteAddr)
+
+	if strings.Contains(value, "${auth.") {
+		authCtx := auth.GetAuthContext(v.request)
+		value = strings.NewReplacer(
+			"${auth.user_id}", authCtx.UserID,
+			"${auth.client_id}", authCtx.ClientID,
+			"${auth.identity_type}", authCtx.IdentityType,
+			"${auth.cert_common_name}", authCtx.CertCommonName,
+			"${auth.roles}", strings.Join(authCtx.Roles, ","),
+			"${auth.scopes}", strings.Join(authCtx.Scopes, ","),
+		).Replace(value)
+	}
+	return value
+}
+
+// transformQuery renames, removes and then adds query parameters
+func transformQuery(r *http.Request, rule *config.TransformRule, vars *transformVars) {
+	if len(rule.RenameQuery) == 0 && len(rule.RemoveQuery) == 0 && len(rule.AddQuery) == 0 {
+		return
+	}
+
+	renames := make([]string, 0, len(rule.RenameQuery))
+	for from := range rule.RenameQuery {
+		renames = append(renames, from)
+	}
+	sort.Strings(renames)
+
+	query := r.URL.Query()
+	for _, from := range renames {
+		if values, ok := query[from]; ok {
+			to := rule.RenameQuery[from]
+			delete(query, from)
+			query[to] = append(query[to], values...)
+		}
+	}
+	for _, name := range rule.RemoveQuery {
+		query.Del(name)
+	}
+	for name, value := range rule.AddQuery {
+		query.Set(name, vars.expand(value))
+	}
+	r.URL.RawQuery = query.Encode()
+}
+
 type transformResponseWriter struct {
 	http.ResponseWriter
 	config      *config.TransformConfig
+	vars        *transformVars
+	head        bool
 	wroteHeader bool
+
+	// A JSON response with body operations is held back until the handler
+	// returns, unless it outgrows the body limit
+	buffering bool
+	status    int
+	body      bytes.Buffer
 }
 
 func (w *transformResponseWriter) WriteHeader(code int) {
@@ -54,7 +141,7 @@ func (w *transformResponseWriter) WriteHeader(code int) {
 			// Add headers
 			if w.config.Response.AddHeaders != nil {
 				for k, v := range w.config.Response.AddHeaders {
-					w.Header().Set(k, v)
+					w.Header().Set(k, w.vars.expand(v))
 				}
 			}
 
@@ -64,8 +151,16 @@ func (w *transformResponseWriter) WriteHeader(code int) {
 					w.Header().Del(h)
 				}
 			}
+
+			if hasBodyOperations(w.config.Response) && !w.head && bodyAllowed(code) && isTransformableJSON(w.Header()) {
+				w.buffering = true
+				w.status = code
+			}
 		}
 		w.wroteHeader = true
+		if w.buffering {
+			return
+		}
 	}
 	w.ResponseWriter.WriteHeader(code)
 }
@@ -74,14 +169,53 @@ func (w *transformResponseWriter) Write(b []byte) (int, error) {
 	if !w.wroteHeader {
 		w.WriteHeader(http.StatusOK)
 	}
-	return w.ResponseWriter.Write(b)
+	if !w.buffering {
+		return w.ResponseWriter.Write(b)
+	}
+
+	if int64(w.body.Len()+len(b)) > maxBodySize(w.config.Response) {
+		if err := w.passThrough(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// passThrough gives up on transforming and sends what was held back as is
+func (w *transformResponseWriter) passThrough() error {
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+// finish sends a held back response once the handler has returned
+func (w *transformResponseWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	w.buffering = false
+
+	body := w.body.Bytes()
+	if transformed, ok := transformJSON(body, w.config.Response, w.vars); ok {
+		body = transformed
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
 }
 
 func (w *transformResponseWriter) Unwrap() http.ResponseWriter {
 	return w.ResponseWriter
 }
 
+// Flush is a no-op while a response is held back for transformation
 func (w *transformResponseWriter) Flush() {
+	if w.buffering {
+		return
+	}
 	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
 	}
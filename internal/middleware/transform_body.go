@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// defaultMaxTransformBody is the largest body transformed when the rule does
+// not set max_body_size
+const defaultMaxTransformBody = 1 << 20
+
+func hasBodyOperations(rule *config.TransformRule) bool {
+	return len(rule.RenameFields) > 0 || len(rule.RemoveFields) > 0 || len(rule.SetFields) > 0
+}
+
+func maxBodySize(rule *config.TransformRule) int64 {
+	if rule.MaxBodySize > 0 {
+		return rule.MaxBodySize
+	}
+	return defaultMaxTransformBody
+}
+
+// isTransformableJSON reports whether a body with these headers is plain JSON.
+// Compressed bodies are left alone.
+func isTransformableJSON(header http.Header) bool {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// transformRequestBody rewrites a JSON request body and its Content-Length.
+// Bodies that are not JSON, do not parse or exceed the limit are sent on as
+// they came in.
+func transformRequestBody(r *http.Request, rule *config.TransformRule, vars *transformVars) {
+	if r.Body == nil || r.Body == http.NoBody || !isTransformableJSON(r.Header) {
+		return
+	}
+
+	limit := maxBodySize(rule)
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(body)) > limit {
+		// Put back what was read in front of the rest of the stream
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return
+	}
+	r.Body.Close()
+
+	if transformed, ok := transformJSON(body, rule, vars); ok {
+		body = transformed
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// transformJSON applies the rule's field operations to a JSON document. It
+// reports false when the body is not a JSON object or array.
+func transformJSON(body []byte, rule *config.TransformRule, vars *transformVars) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return nil, false
+	}
+	switch doc.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return nil, false
+	}
+
+	renames := make([]string, 0, len(rule.RenameFields))
+	for from := range rule.RenameFields {
+		renames = append(renames, from)
+	}
+	sort.Strings(renames)
+	for _, from := range renames {
+		fromPath := parseFieldPath(from)
+		if value, ok := getField(doc, fromPath); ok {
+			doc, _ = removeField(doc, fromPath)
+			doc, _ = setField(doc, parseFieldPath(rule.RenameFields[from]), value)
+		}
+	}
+	for _, field := range rule.RemoveFields {
+		doc, _ = removeField(doc, parseFieldPath(field))
+	}
+	sets := make([]string, 0, len(rule.SetFields))
+	for field := range rule.SetFields {
+		sets = append(sets, field)
+	}
+	sort.Strings(sets)
+	for _, field := range sets {
+		doc, _ = setField(doc, parseFieldPath(field), expandValue(rule.SetFields[field], vars))
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, false
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), true
+}
+
+// expandValue copies a configured value, expanding variables in its strings,
+// so documents never share maps with the configuration
+func expandValue(value interface{}, vars *transformVars) interface{} {
+	switch v := value.(type) {
+	case string:
+		return vars.expand(v)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = expandValue(item, vars)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = expandValue(item, vars)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// parseFieldPath splits a JSON pointer (RFC 6901) or a dotted path
+func parseFieldPath(path string) []string {
+	if !strings.HasPrefix(path, "/") {
+		return strings.Split(path, ".")
+	}
+	segments := strings.Split(path[1:], "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+	}
+	return segments
+}
+
+func getField(node interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, ok := n[segment]
+			if !ok {
+				return nil, false
+			}
+			node = value
+		case []interface{}:
+			i, ok := arrayIndex(n, segment)
+			if !ok {
+				return nil, false
+			}
+			node = n[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setField stores value at path, creating missing objects along the way. In
+// arrays, "-" appends. It returns the updated node, which differs from node
+// only when an array grew.
+func setField(node interface{}, path []string, value interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+	segment := path[0]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child := n[segment]
+		if child == nil && len(path) > 1 {
+			child = map[string]interface{}{}
+		}
+		updated, ok := setField(child, path[1:], value)
+		if ok {
+			n[segment] = updated
+		}
+		return n, ok
+	case []interface{}:
+		if segment == "-" && len(path) == 1 {
+			return append(n, value), true
+		}
+		i, ok := arrayIndex(n, segment)
+		if !ok {
+			return n, false
+		}
+		updated, ok := setField(n[i], path[1:], value)
+		if ok {
+			n[i] = updated
+		}
+		return n, ok
+	default:
+		return node, false
+	}
+}
+
+// removeField deletes the field at path. It returns the updated node, which
+// differs from node only when an array shrank.
+func removeField(node interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return node, false
+	}
+	segment := path[0]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, exists := n[segment]
+		if !exists {
+			return n, false
+		}
+		if len(path) == 1 {
+			delete(n, segment)
+			return n, true
+		}
+		updated, ok := removeField(child, path[1:])
+		if ok {
+			n[segment] = updated
+		}
+		return n, ok
+	case []interface{}:
+		i, ok := arrayIndex(n, segment)
+		if !ok {
+			return n, false
+		}
+		if len(path) == 1 {
+			return append(n[:i:i], n[i+1:]...), true
+		}
+		updated, ok := removeField(n[i], path[1:])
+		if ok {
+			n[i] = updated
+		}
+		return n, ok
+	default:
+		return node, false
+	}
+}
+
+func arrayIndex(array []interface{}, segment string) (int, bool) {
+	i, err := strconv.Atoi(segment)
+	if err != nil || i < 0 || i >= len(array) {
+		return 0, false
+	}
+	return i, true
+}
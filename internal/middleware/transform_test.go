@@ -1,10 +1,15 @@
 package middleware
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/JustVugg/gonk/internal/auth"
 	"github.com/JustVugg/gonk/internal/config"
 )
 
@@ -71,3 +76,109 @@ func TestTransformAppliesResponseRulesOnImplicitWriteHeader(t *testing.T) {
 		t.Fatalf("X-Response = %q, want implicit", got)
 	}
 }
+
+func TestTransformRewritesJSONRequestBody(t *testing.T) {
+	cfg := &config.TransformConfig{
+		Request: &config.TransformRule{
+			RenameFields: map[string]string{"temp": "reading.celsius"},
+			RemoveFields: []string{"/debug"},
+			SetFields: map[string]interface{}{
+				"device_id": "${auth.cert_common_name}",
+				"source":    map[string]interface{}{"gateway": true},
+			},
+		},
+	}
+
+	var gotBody string
+	var gotLength int64
+	handler := Transform(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody, gotLength = string(body), r.ContentLength
+		if r.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+			t.Errorf("Content-Length header = %q, want %d", r.Header.Get("Content-Length"), len(body))
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/telemetry", strings.NewReader(`{"temp":21.50,"debug":{"trace":1},"device_id":"spoofed"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req = auth.StoreAuthContext(req, &auth.AuthContext{Authenticated: true, CertCommonName: "plc-7"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := `{"device_id":"plc-7","reading":{"celsius":21.50},"source":{"gateway":true}}`
+	if gotBody != want {
+		t.Fatalf("body = %s, want %s", gotBody, want)
+	}
+	if gotLength != int64(len(want)) {
+		t.Fatalf("ContentLength = %d, want %d", gotLength, len(want))
+	}
+}
+
+func TestTransformStripsJSONResponseFieldsAndPassesOtherBodiesThrough(t *testing.T) {
+	cfg := &config.TransformConfig{
+		Response: &config.TransformRule{
+			RemoveFields: []string{"internal", "items.0.secret"},
+		},
+	}
+
+	responses := map[string]struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		"/json":   {"application/json", `{"internal":{"host":"db-1"},"items":[{"id":1,"secret":"x"}]}`, `{"items":[{"id":1}]}`},
+		"/text":   {"text/plain", `{"internal":true}`, `{"internal":true}`},
+		"/broken": {"application/json", `{"internal":`, `{"internal":`},
+	}
+
+	handler := Transform(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[r.URL.Path]
+		w.Header().Set("Content-Type", response.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(response.body)))
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, response.body)
+	}))
+
+	for path, response := range responses {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rr.Body.String() != response.want {
+			t.Fatalf("%s body = %s, want %s", path, rr.Body.String(), response.want)
+		}
+		if got := rr.Header().Get("Content-Length"); got != strconv.Itoa(len(response.want)) {
+			t.Fatalf("%s Content-Length = %q, want %d", path, got, len(response.want))
+		}
+	}
+}
+
+func TestTransformRewritesQueryAndMethod(t *testing.T) {
+	cfg := &config.TransformConfig{
+		Request: &config.TransformRule{
+			RenameQuery: map[string]string{"id": "deviceId"},
+			RemoveQuery: []string{"debug"},
+			AddQuery: map[string]string{
+				"caller": "${auth.client_id}",
+				"roles":  "${auth.roles}",
+			},
+			Method: http.MethodPost,
+		},
+	}
+
+	var gotMethod string
+	var gotQuery url.Values
+	handler := Transform(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotQuery = r.Method, r.URL.Query()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/soap?id=7&debug=1&caller=spoofed", nil)
+	req = auth.StoreAuthContext(req, &auth.AuthContext{Authenticated: true, ClientID: "historian", Roles: []string{"reader", "ops"}})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotMethod != http.MethodPost {
+		t.Fatalf("method = %s, want POST", gotMethod)
+	}
+	want := url.Values{"deviceId": {"7"}, "caller": {"historian"}, "roles": {"reader,ops"}}
+	if gotQuery.Encode() != want.Encode() {
+		t.Fatalf("query = %s, want %s", gotQuery.Encode(), want.Encode())
+	}
+}
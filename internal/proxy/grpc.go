@@ -106,14 +106,13 @@ func (h *Handler) handleGRPC(w http.ResponseWriter, r *http.Request) {
         h.grpcProxy.director(r)
     }
     
-    parts := strings.Split(r.URL.Path, "/")
+    fullMethod := h.rewriter.rewrite(r.URL.Path)
+    parts := strings.Split(fullMethod, "/")
     if len(parts) < 3 {
         http.Error(w, "invalid gRPC path", http.StatusBadRequest)
         return
     }
     
-    fullMethod := r.URL.Path
-    
     conn, err := h.grpcProxy.getConnection()
     if err != nil {
         writeGRPCError(w, status.Error(codes.Unavailable, "upstream unavailable"))
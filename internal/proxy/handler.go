@@ -1,27 +1,42 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/JustVugg/gonk/internal/clientip"
 	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/discovery"
 	"github.com/JustVugg/gonk/internal/loadbalancer"
+	"github.com/JustVugg/gonk/internal/metrics"
+	"github.com/JustVugg/gonk/internal/resilience"
+	"github.com/JustVugg/gonk/internal/unixsock"
 )
 
 type Handler struct {
-	route        *config.Route
-	httpProxy    *httputil.ReverseProxy
-	wsUpgrader   websocket.Upgrader
-	grpcProxy    *gRPCProxy
-	loadBalancer *loadbalancer.LoadBalancer
+	route             *config.Route
+	rewriter          *pathRewriter
+	httpProxy         *httputil.ReverseProxy
+	wsUpgrader        websocket.Upgrader
+	grpcProxy         *gRPCProxy
+	loadBalancer      *loadbalancer.LoadBalancer
+	discovery         *discovery.Watcher
+	onUpstreamsChange func(upstreams []string)
+	upstreamInFlight  int32 // single-upstream routes; load balancers track their own
+	upstreamRejected  int64
+	unixTransports    map[string]*http.Transport // by socket path; closed with the handler
+	closed            bool
+	mu                sync.RWMutex
 }
 
 func NewHandler(route *config.Route) (*Handler, error) {
@@ -35,8 +50,18 @@ func NewHandler(route *config.Route) (*Handler, error) {
 		},
 	}
 
-	// Initialize load balancer if multiple upstreams
-	if len(route.Upstreams) > 1 || route.LoadBalancing != nil {
+	rewriter, err := newPathRewriter(route)
+	if err != nil {
+		return nil, err
+	}
+	h.rewriter = rewriter
+
+	// Discovered routes are always load balanced so the upstream set can change live
+	if route.Discovery != nil {
+		if err := h.setupDiscovery(); err != nil {
+			return nil, err
+		}
+	} else if len(route.Upstreams) > 1 || route.LoadBalancing != nil {
 		lb, err := loadbalancer.NewLoadBalancer(route.Upstreams, route.LoadBalancing)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create load balancer: %w", err)
@@ -69,19 +94,205 @@ func NewHandler(route *config.Route) (*Handler, error) {
 		return nil, fmt.Errorf("no upstreams configured")
 	}
 
+	// Load-balanced routes break per upstream so traffic can route around a
+	// single failing upstream
+	if h.UpstreamCircuitBreakers() {
+		h.loadBalancer.EnableCircuitBreakers(route.Name, route.CircuitBreaker)
+	}
+
+	if h.loadBalancer != nil && h.maxPerUpstream() > 0 {
+		h.loadBalancer.SetMaxConnsPerUpstream(h.maxPerUpstream())
+	}
+
 	return h, nil
 }
 
+func (h *Handler) setupDiscovery() error {
+	if len(h.route.Upstreams) > 0 {
+		lb, err := loadbalancer.NewLoadBalancer(h.route.Upstreams, h.route.LoadBalancing)
+		if err != nil {
+			return fmt.Errorf("failed to create load balancer: %w", err)
+		}
+		h.loadBalancer = lb
+	} else {
+		h.loadBalancer = loadbalancer.NewDynamicLoadBalancer(h.route.LoadBalancing)
+	}
+
+	watcher, err := discovery.NewWatcher(h.route.Discovery, h.applyDiscoveredUpstreams)
+	if err != nil {
+		h.loadBalancer.Stop()
+		return fmt.Errorf("failed to create discovery: %w", err)
+	}
+
+	// A failed first lookup is not fatal: static upstreams (if any) keep
+	// serving and the watcher retries on the next interval.
+	if err := watcher.Refresh(); err != nil {
+		log.Printf("Initial discovery for route %s failed: %v", h.route.Name, err)
+	}
+	watcher.Start()
+	h.discovery = watcher
+
+	return nil
+}
+
+func (h *Handler) applyDiscoveredUpstreams(upstreams []config.Upstream) {
+	if err := h.loadBalancer.SetUpstreams(upstreams); err != nil {
+		log.Printf("Discovery for route %s returned invalid upstreams: %v", h.route.Name, err)
+		return
+	}
+	log.Printf("Discovery updated route %s to %d upstream(s)", h.route.Name, len(upstreams))
+
+	sockets := make(map[string]bool)
+	for _, upstream := range upstreams {
+		if u, err := url.Parse(upstream.URL); err == nil && unixsock.IsUnix(u) {
+			sockets[unixsock.Path(u)] = true
+		}
+	}
+
+	h.mu.Lock()
+	h.closeUnixTransports(sockets)
+	fn := h.onUpstreamsChange
+	h.mu.Unlock()
+
+	if fn != nil {
+		fn(h.UpstreamURLs())
+	}
+}
+
+// OnUpstreamsChange registers a callback for discovery-driven upstream set changes
+func (h *Handler) OnUpstreamsChange(fn func(upstreams []string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onUpstreamsChange = fn
+}
+
+// UpstreamURLs returns the upstreams currently used by the route
+func (h *Handler) UpstreamURLs() []string {
+	if h.loadBalancer != nil {
+		return h.loadBalancer.UpstreamURLs()
+	}
+
+	urls := make([]string, 0, len(h.route.Upstreams))
+	for _, upstream := range h.route.Upstreams {
+		urls = append(urls, upstream.URL)
+	}
+	return urls
+}
+
 func (h *Handler) Close() error {
-	if h.grpcProxy != nil {
-		return h.grpcProxy.Close()
+	if h.discovery != nil {
+		h.discovery.Stop()
 	}
 	if h.loadBalancer != nil {
 		h.loadBalancer.Stop()
 	}
+
+	h.mu.Lock()
+	h.closed = true
+	h.closeUnixTransports(nil)
+	h.mu.Unlock()
+
+	if h.grpcProxy != nil {
+		return h.grpcProxy.Close()
+	}
 	return nil
 }
 
+// unixTransport returns the transport for a socket upstream. It is kept for
+// the handler's lifetime so connections are pooled across requests, and a
+// reload that retires the handler closes them.
+func (h *Handler) unixTransport(socketPath string) *http.Transport {
+	h.mu.RLock()
+	transport := h.unixTransports[socketPath]
+	h.mu.RUnlock()
+	if transport != nil {
+		return transport
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		// A request that outlived a reload's drain; nothing would close a
+		// pooled connection
+		transport = unixsock.NewTransport(socketPath)
+		transport.DisableKeepAlives = true
+		return transport
+	}
+	if transport = h.unixTransports[socketPath]; transport == nil {
+		if h.unixTransports == nil {
+			h.unixTransports = make(map[string]*http.Transport)
+		}
+		transport = unixsock.NewTransport(socketPath)
+		h.unixTransports[socketPath] = transport
+	}
+	return transport
+}
+
+// closeUnixTransports closes and drops the transports of sockets not in
+// keep. Callers must hold h.mu.
+func (h *Handler) closeUnixTransports(keep map[string]bool) {
+	for socketPath, transport := range h.unixTransports {
+		if !keep[socketPath] {
+			transport.CloseIdleConnections()
+			delete(h.unixTransports, socketPath)
+		}
+	}
+}
+
+// OnUpstreamHealthChange forwards load balancer health transitions to fn.
+// Routes without a load balancer are never health checked, so fn is not called.
+func (h *Handler) OnUpstreamHealthChange(fn loadbalancer.HealthChangeFunc) {
+	if h.loadBalancer != nil {
+		h.loadBalancer.OnHealthChange(fn)
+	}
+}
+
+// UpstreamCircuitBreakers reports whether the route's circuit breaker is
+// applied per upstream inside the load balancer instead of route-wide
+func (h *Handler) UpstreamCircuitBreakers() bool {
+	return h.loadBalancer != nil && h.route.CircuitBreaker != nil && h.route.CircuitBreaker.Enabled
+}
+
+// CircuitBreakerStats summarizes the per-upstream breakers as a route-level
+// breaker that is open only when every upstream breaker is open
+func (h *Handler) CircuitBreakerStats() *resilience.Stats {
+	if !h.UpstreamCircuitBreakers() {
+		return nil
+	}
+	stats := resilience.AggregateStats(h.route.Name, h.loadBalancer.CircuitBreakerStats())
+	return &stats
+}
+
+// CircuitBreakers returns the per-upstream breakers, limited to one upstream
+// when upstream is set
+func (h *Handler) CircuitBreakers(upstream string) []*resilience.CircuitBreaker {
+	if !h.UpstreamCircuitBreakers() {
+		return nil
+	}
+	return h.loadBalancer.CircuitBreakers(upstream)
+}
+
+// maxPerUpstream returns the configured per-upstream concurrency cap, or 0
+func (h *Handler) maxPerUpstream() int {
+	if h.route.Concurrency == nil || !h.route.Concurrency.Enabled {
+		return 0
+	}
+	return h.route.Concurrency.MaxPerUpstream
+}
+
+// UpstreamLimitRejections returns how many requests were rejected because
+// every upstream was at its concurrency cap
+func (h *Handler) UpstreamLimitRejections() int64 {
+	return atomic.LoadInt64(&h.upstreamRejected)
+}
+
+func (h *Handler) rejectUpstreamLimit(w http.ResponseWriter) {
+	atomic.AddInt64(&h.upstreamRejected, 1)
+	metrics.RecordConcurrencyRejection(h.route.Name, resilience.RejectUpstreamLimit)
+	resilience.WriteOverloaded(w, h.route.Concurrency.RetryAfter)
+}
+
 func (h *Handler) LoadBalancerStats() map[string]interface{} {
 	if h.loadBalancer == nil {
 		return nil
@@ -90,6 +301,15 @@ func (h *Handler) LoadBalancerStats() map[string]interface{} {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if max := int32(h.maxPerUpstream()); max > 0 && h.loadBalancer == nil {
+		if atomic.AddInt32(&h.upstreamInFlight, 1) > max {
+			atomic.AddInt32(&h.upstreamInFlight, -1)
+			h.rejectUpstreamLimit(w)
+			return
+		}
+		defer atomic.AddInt32(&h.upstreamInFlight, -1)
+	}
+
 	// Handle WebSocket upgrade
 	if h.route.Protocol == "ws" || h.route.Protocol == "wss" {
 		if websocket.IsWebSocketUpgrade(r) {
@@ -116,10 +336,20 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) handleLoadBalanced(w http.ResponseWriter, r *http.Request) {
 	// Get client IP for IP hash strategy
-	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	clientIP := clientip.FromRequest(r)
 
 	// Get next upstream
 	upstreamURL, err := h.loadBalancer.GetNextUpstream(clientIP)
+	if errors.Is(err, loadbalancer.ErrCircuitOpen) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"service temporarily unavailable"}`))
+		return
+	}
+	if errors.Is(err, loadbalancer.ErrUpstreamsSaturated) {
+		h.rejectUpstreamLimit(w)
+		return
+	}
 	if err != nil {
 		log.Printf("Load balancer error: %v", err)
 		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
@@ -140,31 +370,34 @@ func (h *Handler) handleLoadBalanced(w http.ResponseWriter, r *http.Request) {
 		loadBalancer:   h.loadBalancer,
 	}
 
+	start := time.Now()
 	proxy.ServeHTTP(wrapped, r)
 
-	// Record result
-	if wrapped.statusCode >= 500 {
-		h.loadBalancer.RecordFailure(upstreamURL)
-	} else {
-		h.loadBalancer.RecordSuccess(upstreamURL)
+	// A client that went away says nothing about the upstream
+	if errors.Is(wrapped.err, context.Canceled) {
+		return
 	}
+	h.loadBalancer.RecordResult(upstreamURL, wrapped.statusCode, wrapped.err, time.Since(start))
 }
 
 func (h *Handler) createHTTPProxy(target *url.URL) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
+	scheme, host := target.Scheme, target.Host
+	if unixsock.IsUnix(target) {
+		scheme, host = "http", unixsock.Host
+		proxy.Transport = h.unixTransport(unixsock.Path(target))
+	}
+
 	proxy.Director = func(req *http.Request) {
-		req.URL.Scheme = target.Scheme
-		req.URL.Host = target.Host
-		req.Host = target.Host
-
-		// Strip path if configured
-		if h.route.StripPath {
-			prefix := strings.TrimSuffix(h.route.Path, "/*")
-			req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
-			if !strings.HasPrefix(req.URL.Path, "/") {
-				req.URL.Path = "/" + req.URL.Path
-			}
+		req.URL.Scheme = scheme
+		req.URL.Host = host
+		req.Host = host
+
+		// Strip or rewrite the path if configured
+		if path := h.rewriter.rewrite(req.URL.Path); path != req.URL.Path {
+			req.URL.Path = path
+			req.URL.RawPath = ""
 		}
 
 		// Add custom headers
@@ -172,14 +405,14 @@ func (h *Handler) createHTTPProxy(target *url.URL) *httputil.ReverseProxy {
 			req.Header.Set(k, v)
 		}
 
-		// Add X-Forwarded headers
-		if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-			req.Header.Set("X-Real-IP", clientIP)
-			if prior, ok := req.Header["X-Forwarded-For"]; ok {
-				clientIP = strings.Join(prior, ", ") + ", " + clientIP
-			}
-			req.Header.Set("X-Forwarded-For", clientIP)
+		// Add X-Forwarded headers. Forwarding headers are only passed on
+		// from trusted proxies; the reverse proxy appends the peer address
+		// to X-Forwarded-For after the director returns.
+		if !clientip.PeerTrusted(req) {
+			req.Header.Del("X-Forwarded-For")
+			req.Header.Del("Forwarded")
 		}
+		req.Header.Set("X-Real-IP", clientip.FromRequest(req))
 		req.Header.Set("X-Forwarded-Proto", "http")
 		if req.TLS != nil {
 			req.Header.Set("X-Forwarded-Proto", "https")
@@ -194,6 +427,11 @@ func (h *Handler) createHTTPProxy(target *url.URL) *httputil.ReverseProxy {
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
 		log.Printf("Proxy error for route %s: %v", h.route.Name, err)
+		// Breakers see the transport error, not only the 502 written here
+		if lbWriter, ok := w.(*loadBalancerResponseWriter); ok {
+			lbWriter.err = err
+		}
+		resilience.RecordError(r, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadGateway)
 		fmt.Fprintf(w, `{"error":"upstream unavailable","route":"%s"}`, h.route.Name)
@@ -205,6 +443,7 @@ func (h *Handler) createHTTPProxy(target *url.URL) *httputil.ReverseProxy {
 type loadBalancerResponseWriter struct {
 	http.ResponseWriter
 	statusCode   int
+	err          error // set when the upstream could not be reached
 	upstreamURL  *url.URL
 	loadBalancer *loadbalancer.LoadBalancer
 }
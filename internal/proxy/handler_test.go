@@ -1,11 +1,25 @@
 package proxy
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/JustVugg/gonk/internal/auth"
+	"github.com/JustVugg/gonk/internal/clientip"
 	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/resilience"
 )
 
 func TestHTTPProxyStripsRoutePrefixAndAddsHeaders(t *testing.T) {
@@ -53,6 +67,50 @@ func TestHTTPProxyStripsRoutePrefixAndAddsHeaders(t *testing.T) {
 	}
 }
 
+func TestHTTPProxyForwardsChainOnlyFromTrustedProxies(t *testing.T) {
+	var forwardedFor, realIP string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedFor, realIP = r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP")
+	}))
+	defer upstream.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "api",
+		Path:      "/api/*",
+		Protocol:  "http",
+		Upstreams: []config.Upstream{{URL: upstream.URL}},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8"}, "")
+	if err != nil {
+		t.Fatalf("NewResolver() returned error: %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr    string
+		wantForwarded string
+		wantRealIP    string
+	}{
+		{remoteAddr: "10.0.0.5:4000", wantForwarded: "203.0.113.9, 10.0.0.5", wantRealIP: "203.0.113.9"},
+		{remoteAddr: "198.51.100.7:4000", wantForwarded: "198.51.100.7", wantRealIP: "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway.local/api/items", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		resolver.Middleware(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+		if forwardedFor != tt.wantForwarded || realIP != tt.wantRealIP {
+			t.Fatalf("peer %s: X-Forwarded-For = %q, X-Real-IP = %q, want %q and %q",
+				tt.remoteAddr, forwardedFor, realIP, tt.wantForwarded, tt.wantRealIP)
+		}
+	}
+}
+
 func TestHTTPProxyLoadBalancesAcrossUpstreams(t *testing.T) {
 	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("first"))
@@ -92,3 +150,547 @@ func TestHTTPProxyLoadBalancesAcrossUpstreams(t *testing.T) {
 		t.Fatalf("body = %q, want second", rr.Body.String())
 	}
 }
+
+func TestHTTPProxyBreaksPerUpstream(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("healthy"))
+	}))
+	defer healthy.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:     "plc",
+		Path:     "/plc/*",
+		Protocol: "http",
+		Upstreams: []config.Upstream{
+			{URL: broken.URL, Weight: 100},
+			{URL: healthy.URL, Weight: 100},
+		},
+		LoadBalancing: &config.LoadBalancingConfig{Strategy: "round-robin"},
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			Enabled:         true,
+			MaxFailures:     1,
+			ResetTimeout:    time.Hour,
+			HalfOpenMaxReqs: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	if !handler.UpstreamCircuitBreakers() {
+		t.Fatal("load-balanced route should use per-upstream circuit breakers")
+	}
+
+	var bodies []string
+	for i := 0; i < 4; i++ {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://gateway.local/plc/coils", nil)
+		req.RemoteAddr = "203.0.113.10:5555"
+		handler.ServeHTTP(rr, req)
+		bodies = append(bodies, rr.Body.String())
+	}
+
+	// Round robin hits the broken upstream once, then only the healthy one
+	failures := 0
+	for _, body := range bodies {
+		if body != "healthy" {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Fatalf("responses = %q, want exactly one failure before the breaker opened", bodies)
+	}
+
+	stats := handler.CircuitBreakerStats()
+	if stats == nil || stats.State != "closed" {
+		t.Fatalf("route breaker stats = %+v, want closed while one upstream is available", stats)
+	}
+}
+
+func TestHTTPProxyBreaksOnUnreachableUpstream(t *testing.T) {
+	// Closed right away, so connections are refused
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:          "plc",
+		Path:          "/plc/*",
+		Protocol:      "http",
+		Upstreams:     []config.Upstream{{URL: dead.URL, Weight: 100}},
+		LoadBalancing: &config.LoadBalancingConfig{Strategy: "round-robin"},
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			Enabled:         true,
+			MaxFailures:     1,
+			ResetTimeout:    time.Hour,
+			HalfOpenMaxReqs: 1,
+			// 502 is what the gateway answers, not what the upstream did
+			FailureStatusCodes: []int{http.StatusServiceUnavailable},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://gateway.local/plc/coils", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadGateway)
+	}
+	if state := handler.CircuitBreakers(dead.URL)[0].State(); state != resilience.StateOpen {
+		t.Fatalf("breaker state = %s, want open after the connection failed", state)
+	}
+}
+
+func TestWebSocketProxyBreaksOnUnreachableUpstream(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:          "bridge",
+		Path:          "/bridge",
+		Protocol:      "ws",
+		Upstreams:     []config.Upstream{{URL: dead.URL, Weight: 100}},
+		LoadBalancing: &config.LoadBalancingConfig{Strategy: "round-robin"},
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			Enabled:         true,
+			MaxFailures:     1,
+			ResetTimeout:    time.Hour,
+			HalfOpenMaxReqs: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/bridge", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadGateway)
+	}
+	if state := handler.CircuitBreakers(dead.URL)[0].State(); state != resilience.StateOpen {
+		t.Fatalf("breaker state = %s, want open after the dial failed", state)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with the breaker open = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHTTPProxyDoesNotBreakOnClientCancel(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:          "plc",
+		Path:          "/plc/*",
+		Protocol:      "http",
+		Upstreams:     []config.Upstream{{URL: upstream.URL, Weight: 100}},
+		LoadBalancing: &config.LoadBalancingConfig{Strategy: "round-robin"},
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			Enabled:         true,
+			MaxFailures:     1,
+			ResetTimeout:    time.Hour,
+			HalfOpenMaxReqs: 1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/plc/coils", nil).WithContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	breaker := handler.CircuitBreakers(upstream.URL)[0]
+	if state := breaker.State(); state != resilience.StateClosed {
+		t.Fatalf("breaker state = %s, want closed after the client went away", state)
+	}
+	if failures := breaker.Stats().Failures; failures != 0 {
+		t.Fatalf("breaker failures = %d, want the cancelled request not counted", failures)
+	}
+}
+
+func TestHTTPProxyRoutesToDiscoveredUpstreams(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("discovered"))
+	}))
+	defer upstream.Close()
+
+	endpoints := filepath.Join(t.TempDir(), "endpoints.yaml")
+	if err := os.WriteFile(endpoints, []byte("- url: "+upstream.URL+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write endpoint file: %v", err)
+	}
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "historian",
+		Path:      "/historian/*",
+		Protocol:  "http",
+		Discovery: &config.DiscoveryConfig{Type: "file", File: endpoints, Interval: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	if got := handler.UpstreamURLs(); len(got) != 1 || got[0] != upstream.URL {
+		t.Fatalf("UpstreamURLs() = %v, want [%s]", got, upstream.URL)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.local/historian/tags", nil)
+	req.RemoteAddr = "203.0.113.10:5555"
+	handler.ServeHTTP(rr, req)
+
+	if rr.Body.String() != "discovered" {
+		t.Fatalf("body = %q, want discovered", rr.Body.String())
+	}
+}
+
+func TestHTTPProxyRoutesToUnixSocketUpstreams(t *testing.T) {
+	socketPath := startUnixServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "localhost" {
+			t.Errorf("upstream Host = %q, want localhost", r.Host)
+		}
+		w.Write([]byte("socket:" + r.URL.Path))
+	}))
+
+	routes := map[string]*config.Route{
+		"single": {
+			Name:      "historian",
+			Path:      "/historian/*",
+			Protocol:  "http",
+			StripPath: true,
+			Upstreams: []config.Upstream{{URL: "unix://" + socketPath}},
+		},
+		"load-balanced": {
+			Name:          "historian",
+			Path:          "/historian/*",
+			Protocol:      "http",
+			StripPath:     true,
+			Upstreams:     []config.Upstream{{URL: "unix://" + socketPath, Weight: 100}},
+			LoadBalancing: &config.LoadBalancingConfig{Strategy: "round-robin"},
+		},
+	}
+
+	for name, route := range routes {
+		t.Run(name, func(t *testing.T) {
+			handler, err := NewHandler(route)
+			if err != nil {
+				t.Fatalf("NewHandler() returned error: %v", err)
+			}
+			defer handler.Close()
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://gateway.local/historian/tags", nil)
+			req.RemoteAddr = "203.0.113.10:5555"
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
+			}
+			if rr.Body.String() != "socket:/tags" {
+				t.Fatalf("body = %q, want socket:/tags", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPProxyClosesUnixSocketConnectionsOnClose(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "upstream.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Listener = listener
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:          "historian",
+		Path:          "/historian/*",
+		Protocol:      "http",
+		Upstreams:     []config.Upstream{{URL: "unix://" + socketPath, Weight: 100}},
+		LoadBalancing: &config.LoadBalancingConfig{Strategy: "round-robin"},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://gateway.local/historian/tags", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+
+	// As when a reload retires the route
+	handler.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection to the socket was not closed with the handler")
+	}
+}
+
+func TestWebSocketProxyRoutesToUnixSocketUpstream(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	socketPath := startUnixServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(messageType, append([]byte("echo:"), message...))
+	}))
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "bridge",
+		Path:      "/bridge",
+		Protocol:  "ws",
+		Upstreams: []config.Upstream{{URL: "unix://" + socketPath}},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http")+"/bridge", nil)
+	if err != nil {
+		t.Fatalf("failed to dial gateway: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("coil-1")); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	if string(message) != "echo:coil-1" {
+		t.Fatalf("message = %q, want echo:coil-1", message)
+	}
+}
+
+func TestGRPCProxyRoutesToUnixSocketUpstream(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "grpc.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, grpchealth.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "plc",
+		Path:      "/grpc.health.v1.Health/*",
+		Protocol:  "grpc",
+		Upstreams: []config.Upstream{{URL: "unix://" + socketPath}},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://gateway.local/grpc.health.v1.Health/Check", nil)
+	req.ProtoMajor = 2
+	req.Header.Set("Content-Type", "application/grpc")
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Grpc-Status"); got != "" {
+		t.Fatalf("Grpc-Status = %q (%s), want success", got, rr.Header().Get("Grpc-Message"))
+	}
+	// HealthCheckResponse{status: SERVING}
+	if got := rr.Body.String(); got != "\x08\x01" {
+		t.Fatalf("body = %q, want serving health response", got)
+	}
+}
+
+func TestWebSocketProxyRewritesPath(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/lines/L3/readings/live" {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer upstream.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "sensors",
+		Path:      "/plant/{line}/sensors/*",
+		Protocol:  "ws",
+		Rewrite:   &config.RewriteConfig{Path: "/v2/lines/{line}/readings/*"},
+		Upstreams: []config.Upstream{{URL: "ws" + strings.TrimPrefix(upstream.URL, "http")}},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http")+"/plant/L3/sensors/live", nil)
+	if err != nil {
+		t.Fatalf("failed to dial through gateway: %v", err)
+	}
+	conn.Close()
+}
+
+func TestWebSocketProxyForwardsIdentityHeaders(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Gonk-Client-ID") != "hmi-2" || r.Header.Get("X-Client-ID") != "hmi-2" || r.Header.Get("X-Gonk-Identity") == "" {
+			http.Error(w, "missing identity headers", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer upstream.Close()
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "alarms",
+		Path:      "/alarms/*",
+		Protocol:  "ws",
+		Upstreams: []config.Upstream{{URL: "ws" + strings.TrimPrefix(upstream.URL, "http")}},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	propagator, err := auth.NewIdentityPropagator(&config.IdentityConfig{
+		Enabled:      true,
+		HeaderPrefix: "X-Gonk-",
+		Assertion: &config.IdentityAssertionConfig{
+			Enabled:   true,
+			Header:    "X-Gonk-Identity",
+			Algorithm: "HS256",
+			SecretKey: "assertion-secret",
+			TTL:       time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewIdentityPropagator() returned error: %v", err)
+	}
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = auth.StoreAuthContext(r, &auth.AuthContext{Authenticated: true, IdentityType: "device", ClientID: "hmi-2"})
+		propagator.Middleware(handler).ServeHTTP(w, r)
+	}))
+	defer gateway.Close()
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http")+"/alarms/live", nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("failed to dial through gateway: %v (status %d)", err, status)
+	}
+	conn.Close()
+}
+
+func TestGRPCProxyRewritesMethodPath(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "grpc.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, grpchealth.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	handler, err := NewHandler(&config.Route{
+		Name:      "legacy",
+		Path:      "/legacy.Health/*",
+		Protocol:  "grpc",
+		Rewrite:   &config.RewriteConfig{Regex: `^/legacy\.Health/(\w+)$`, Replacement: "/grpc.health.v1.Health/$1"},
+		Upstreams: []config.Upstream{{URL: "unix://" + socketPath}},
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() returned error: %v", err)
+	}
+	defer handler.Close()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://gateway.local/legacy.Health/Check", nil)
+	req.ProtoMajor = 2
+	req.Header.Set("Content-Type", "application/grpc")
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Grpc-Status"); got != "" {
+		t.Fatalf("Grpc-Status = %q (%s), want success", got, rr.Header().Get("Grpc-Message"))
+	}
+	if got := rr.Body.String(); got != "\x08\x01" {
+		t.Fatalf("body = %q, want serving health response", got)
+	}
+}
+
+func startUnixServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "upstream.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return socketPath
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// templateVar matches {name} placeholders in rewrite paths and replacements
+var templateVar = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// pathRewriter computes the path sent upstream. The HTTP, WebSocket and gRPC
+// proxies all go through it, so a route's strip_path and rewrite behave the
+// same for every protocol.
+type pathRewriter struct {
+	// prefix matches the route path up to its wildcard, with a named group
+	// per path variable
+	prefix      *regexp.Regexp
+	strip       bool
+	template    string
+	regex       *regexp.Regexp
+	replacement string
+}
+
+func newPathRewriter(route *config.Route) (*pathRewriter, error) {
+	prefix, err := compileRoutePrefix(strings.TrimSuffix(strings.TrimSuffix(route.Path, "*"), "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid route path %s: %w", route.Path, err)
+	}
+	p := &pathRewriter{prefix: prefix, strip: route.StripPath}
+
+	if rw := route.Rewrite; rw != nil {
+		p.template = rw.Path
+		p.replacement = rw.Replacement
+		if rw.Regex != "" {
+			if p.regex, err = regexp.Compile(rw.Regex); err != nil {
+				return nil, fmt.Errorf("invalid rewrite regex: %w", err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// compileRoutePrefix turns a gorilla/mux path template into an anchored regex.
+// {name} matches one segment and {name:pattern} matches pattern.
+func compileRoutePrefix(template string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	for len(template) > 0 {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			pattern.WriteString(regexp.QuoteMeta(template))
+			break
+		}
+		pattern.WriteString(regexp.QuoteMeta(template[:start]))
+
+		// Patterns may contain braces themselves, such as {id:[0-9]{4}}
+		depth, end := 0, -1
+		for i := start; i < len(template); i++ {
+			if template[i] == '{' {
+				depth++
+			} else if template[i] == '}' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("unbalanced braces")
+		}
+
+		name, varPattern, hasPattern := strings.Cut(template[start+1:end], ":")
+		if !hasPattern {
+			varPattern = "[^/]+"
+		}
+		fmt.Fprintf(&pattern, "(?P<%s>%s)", name, varPattern)
+		template = template[end+1:]
+	}
+
+	return regexp.Compile(pattern.String())
+}
+
+// rewrite returns the upstream path for a request path. Paths the rewrite
+// does not match are sent unchanged.
+func (p *pathRewriter) rewrite(path string) string {
+	match := p.prefix.FindStringSubmatchIndex(path)
+	vars := map[string]string{}
+	rest := path
+	if match != nil {
+		for i, name := range p.prefix.SubexpNames() {
+			if name != "" && match[2*i] >= 0 {
+				vars[name] = path[match[2*i]:match[2*i+1]]
+			}
+		}
+		rest = path[match[1]:]
+	}
+
+	switch {
+	case p.regex != nil:
+		if !p.regex.MatchString(path) {
+			return path
+		}
+		return ensureLeadingSlash(expandVars(p.regex.ReplaceAllString(path, p.replacement), vars))
+	case p.template != "":
+		if match == nil {
+			return path
+		}
+		rewritten := expandVars(p.template, vars)
+		if strings.HasSuffix(rewritten, "*") {
+			rewritten = strings.TrimSuffix(strings.TrimSuffix(rewritten, "*"), "/") + "/" + strings.TrimPrefix(rest, "/")
+		}
+		return ensureLeadingSlash(rewritten)
+	case p.strip && match != nil:
+		return ensureLeadingSlash(rest)
+	default:
+		return path
+	}
+}
+
+func expandVars(s string, vars map[string]string) string {
+	return templateVar.ReplaceAllStringFunc(s, func(placeholder string) string {
+		if value, ok := vars[placeholder[1:len(placeholder)-1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+func ensureLeadingSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
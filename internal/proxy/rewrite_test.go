@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func TestPathRewriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		route config.Route
+		path  string
+		want  string
+	}{
+		{
+			name:  "template with path variable and wildcard",
+			route: config.Route{Path: "/plant/{line}/sensors/*", Rewrite: &config.RewriteConfig{Path: "/v2/lines/{line}/readings/*"}},
+			path:  "/plant/L3/sensors/temp/7",
+			want:  "/v2/lines/L3/readings/temp/7",
+		},
+		{
+			name:  "regex with capture groups and path variable",
+			route: config.Route{Path: "/plant/{line:[A-Z][0-9]}/sensors/*", Rewrite: &config.RewriteConfig{Regex: `^/plant/[^/]+/sensors/(\w+)/(\d+)$`, Replacement: "/devices/${2}/{line}/$1"}},
+			path:  "/plant/L3/sensors/temp/7",
+			want:  "/devices/7/L3/temp",
+		},
+		{
+			name:  "regex leaves unmatched paths alone",
+			route: config.Route{Path: "/plant/*", Rewrite: &config.RewriteConfig{Regex: `^/plant/old/(.*)$`, Replacement: "/new/$1"}},
+			path:  "/plant/current/x",
+			want:  "/plant/current/x",
+		},
+		{
+			name:  "strip path with path variable",
+			route: config.Route{Path: "/plant/{line}/*", StripPath: true},
+			path:  "/plant/L3/coils/1",
+			want:  "/coils/1",
+		},
+		{
+			name:  "strip exact path",
+			route: config.Route{Path: "/api/users", StripPath: true},
+			path:  "/api/users",
+			want:  "/",
+		},
+		{
+			name:  "no rewrite",
+			route: config.Route{Path: "/api/*"},
+			path:  "/api/users",
+			want:  "/api/users",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewriter, err := newPathRewriter(&tt.route)
+			if err != nil {
+				t.Fatalf("newPathRewriter() returned error: %v", err)
+			}
+			if got := rewriter.rewrite(tt.path); got != tt.want {
+				t.Fatalf("rewrite(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,22 +1,39 @@
 package proxy
 
 import (
+    "errors"
     "fmt"
     "io"
     "log"
     "net/http"
     "net/url"
-    "strings"
-    
+    "time"
+
     "github.com/gorilla/websocket"
+
+    "github.com/JustVugg/gonk/internal/auth"
+    "github.com/JustVugg/gonk/internal/clientip"
+    "github.com/JustVugg/gonk/internal/loadbalancer"
+    "github.com/JustVugg/gonk/internal/resilience"
+    "github.com/JustVugg/gonk/internal/unixsock"
 )
 
 func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
     // Get upstream URL (from load balancer or single upstream)
     var upstreamURLStr string
     if h.loadBalancer != nil {
-        clientIP := r.RemoteAddr
+        clientIP := clientip.FromRequest(r)
         upstreamURL, err := h.loadBalancer.GetNextUpstream(clientIP)
+        if errors.Is(err, loadbalancer.ErrCircuitOpen) {
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.Write([]byte(`{"error":"service temporarily unavailable"}`))
+            return
+        }
+        if errors.Is(err, loadbalancer.ErrUpstreamsSaturated) {
+            h.rejectUpstreamLimit(w)
+            return
+        }
         if err != nil {
             log.Printf("WebSocket load balancer error: %v", err)
             http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
@@ -33,29 +50,36 @@ func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
     
     upstreamURL, _ := url.Parse(upstreamURLStr)
     
-    targetPath := r.URL.Path
-    if h.route.StripPath {
-        prefix := strings.TrimSuffix(h.route.Path, "/*")
-        targetPath = strings.TrimPrefix(targetPath, prefix)
-        if !strings.HasPrefix(targetPath, "/") {
-            targetPath = "/" + targetPath
-        }
-    }
+    targetPath := h.rewriter.rewrite(r.URL.Path)
     
-    wsURL := fmt.Sprintf("%s://%s%s", h.route.Protocol, upstreamURL.Host, targetPath)
+    dialer := websocket.DefaultDialer
+    host := upstreamURL.Host
+    if unixsock.IsUnix(upstreamURL) {
+        socketDialer := *websocket.DefaultDialer
+        socketDialer.NetDialContext = unixsock.Dialer(unixsock.Path(upstreamURL), socketDialer.HandshakeTimeout)
+        dialer = &socketDialer
+        host = unixsock.Host
+    }
+
+    wsURL := fmt.Sprintf("%s://%s%s", h.route.Protocol, host, targetPath)
     if r.URL.RawQuery != "" {
         wsURL += "?" + r.URL.RawQuery
     }
 
     // Connect to upstream
     upstreamHeader := http.Header{}
-    for k, v := range r.Header {
-        if k == "Upgrade" || k == "Connection" || 
-           strings.HasPrefix(k, "Sec-Websocket-") {
-            upstreamHeader[k] = v
-        }
+    // The dialer generates its own handshake headers and rejects copies of
+    // them, so only the requested subprotocols are forwarded
+    if protocols, ok := r.Header["Sec-Websocket-Protocol"]; ok {
+        upstreamHeader["Sec-Websocket-Protocol"] = protocols
     }
     
+    // Identity headers and the signed assertion, as the HTTP proxy passes
+    // them with the rest of the request headers
+    for name, values := range auth.PropagatedHeaders(r) {
+        upstreamHeader[name] = values
+    }
+
     // Add custom headers
     for k, v := range h.route.Headers {
         upstreamHeader.Set(k, v)
@@ -63,8 +87,21 @@ func (h *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
     
     log.Printf("Connecting to upstream WebSocket: %s", wsURL)
     
-    upstreamConn, _, err := websocket.DefaultDialer.Dial(wsURL, upstreamHeader)
+    start := time.Now()
+    upstreamConn, resp, err := dialer.Dial(wsURL, upstreamHeader)
+    if h.loadBalancer != nil {
+        // The handshake is the call the upstream breaker judges: a refused
+        // connection or a 5xx answer is a failure
+        statusCode, dialErr := http.StatusSwitchingProtocols, err
+        if resp != nil {
+            statusCode, dialErr = resp.StatusCode, nil
+        }
+        h.loadBalancer.RecordResult(upstreamURL, statusCode, dialErr, time.Since(start))
+    }
     if err != nil {
+        if resp == nil {
+            resilience.RecordError(r, err)
+        }
         log.Printf("WebSocket upstream dial error: %v", err)
         http.Error(w, "Failed to connect to upstream", http.StatusBadGateway)
         return
@@ -0,0 +1,249 @@
+// Package proxyproto reads PROXY protocol v1 and v2 headers, so connections
+// relayed by an L4 load balancer report the original client address
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// v2Signature starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Header is the longest v1 header, including CRLF
+const maxV1Header = 107
+
+// ErrMissingHeader is returned when a source that must send a header does not
+var ErrMissingHeader = errors.New("missing PROXY protocol header")
+
+// Listener expects a header on connections from the allowed sources
+type Listener struct {
+	net.Listener
+	timeout time.Duration
+	allowed []*net.IPNet
+}
+
+func NewListener(inner net.Listener, cfg *config.ProxyProtocolConfig) (*Listener, error) {
+	allowed, err := clientip.ParseNetworks(cfg.AllowedSources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol source: %w", err)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Listener{Listener: inner, timeout: timeout, allowed: allowed}, nil
+}
+
+// Accept does not read the header itself, so a slow load balancer cannot hold
+// up other connections. It is read on the connection's first use.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.expectsHeader(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+func (l *Listener) expectsHeader(addr net.Addr) bool {
+	if len(l.allowed) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && clientip.Contains(l.allowed, tcpAddr.IP)
+}
+
+// Conn reports the addresses from the PROXY protocol header. LOCAL
+// connections, such as load balancer health checks, keep their own.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline and SetReadDeadline remember the read deadline, which is
+// restored once the header has been read
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) readHeader() {
+	c.mu.Lock()
+	previous := c.readDeadline
+	c.mu.Unlock()
+
+	deadline := time.Now().Add(c.timeout)
+	if !previous.IsZero() && previous.Before(deadline) {
+		deadline = previous
+	}
+	c.Conn.SetReadDeadline(deadline)
+	defer c.Conn.SetReadDeadline(previous)
+
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		c.err = fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		return
+	}
+
+	switch first[0] {
+	case 'P':
+		c.remote, c.local, c.err = parseV1(c.reader)
+	case v2Signature[0]:
+		c.remote, c.local, c.err = parseV2(c.reader)
+	default:
+		c.err = ErrMissingHeader
+	}
+}
+
+// parseV1 reads "PROXY TCP4 src dst sport dport\r\n"
+func parseV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxV1Header {
+			return nil, nil, fmt.Errorf("PROXY protocol v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, ErrMissingHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed PROXY protocol v1 header")
+	}
+
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("malformed PROXY protocol v1 address %s:%s", host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+// parseV2 reads the binary header. TLVs are skipped.
+func parseV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if !bytes.Equal(header[:12], v2Signature) {
+		return nil, nil, ErrMissingHeader
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	switch header[12] & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol command %d", header[12]&0x0f)
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 0x1: // AF_INET
+		size = net.IPv4len
+	case 0x2: // AF_INET6
+		size = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("malformed PROXY protocol v2 header")
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size+2:])),
+	}
+	return src, dst, nil
+}
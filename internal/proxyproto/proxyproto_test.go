@@ -0,0 +1,123 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func TestListenerReadsHeaders(t *testing.T) {
+	v2 := append([]byte{}, v2Signature...)
+	v2 = append(v2, 0x21, 0x11) // version 2 PROXY, TCP over IPv4
+	v2 = binary.BigEndian.AppendUint16(v2, 12+3)
+	v2 = append(v2, 203, 0, 113, 9, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 51000)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+	v2 = append(v2, 0x04, 0x00, 0x00) // an empty NOOP TLV
+
+	local := append([]byte{}, v2Signature...)
+	local = append(local, 0x20, 0x00, 0x00, 0x00) // version 2 LOCAL
+
+	tests := []struct {
+		name       string
+		header     []byte
+		wantRemote string
+	}{
+		{name: "v1 IPv4", header: []byte("PROXY TCP4 203.0.113.9 10.0.0.1 51000 443\r\n"), wantRemote: "203.0.113.9:51000"},
+		{name: "v1 IPv6", header: []byte("PROXY TCP6 2001:db8::17 2001:db8::1 51000 443\r\n"), wantRemote: "[2001:db8::17]:51000"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n"), wantRemote: "127.0.0.1"},
+		{name: "v2 IPv4", header: v2, wantRemote: "203.0.113.9:51000"},
+		{name: "v2 local", header: local, wantRemote: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := acceptWith(t, &config.ProxyProtocolConfig{Enabled: true}, append(tt.header, "GET / HTTP/1.1\r\n"...))
+			defer conn.Close()
+
+			remote := conn.RemoteAddr().String()
+			if tt.wantRemote == "127.0.0.1" {
+				remote, _, _ = net.SplitHostPort(remote)
+			}
+			if remote != tt.wantRemote {
+				t.Fatalf("RemoteAddr() = %s, want %s", remote, tt.wantRemote)
+			}
+
+			data := make([]byte, len("GET / HTTP/1.1\r\n"))
+			if _, err := io.ReadFull(conn, data); err != nil || string(data) != "GET / HTTP/1.1\r\n" {
+				t.Fatalf("Read() = %q, %v; want the request after the header", data, err)
+			}
+		})
+	}
+}
+
+func TestListenerRejectsMissingHeader(t *testing.T) {
+	conn := acceptWith(t, &config.ProxyProtocolConfig{Enabled: true}, []byte("GET / HTTP/1.1\r\n"))
+	defer conn.Close()
+
+	if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, ErrMissingHeader) {
+		t.Fatalf("Read() error = %v, want %v", err, ErrMissingHeader)
+	}
+}
+
+func TestListenerServesOtherSourcesAsPlainConnections(t *testing.T) {
+	payload := []byte("PROXY TCP4 203.0.113.9 10.0.0.1 51000 443\r\n")
+	conn := acceptWith(t, &config.ProxyProtocolConfig{Enabled: true, AllowedSources: []string{"10.0.0.0/8"}}, payload)
+	defer conn.Close()
+
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Fatalf("RemoteAddr() = %s, want the peer address", conn.RemoteAddr())
+	}
+	data := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, data); err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("Read() = %q, %v; want the header passed through", data, err)
+	}
+}
+
+func TestListenerTimesOutWaitingForHeader(t *testing.T) {
+	conn := acceptWith(t, &config.ProxyProtocolConfig{Enabled: true, Timeout: 50 * time.Millisecond}, []byte("PROXY TCP4"))
+	defer conn.Close()
+
+	var netErr net.Error
+	if _, err := conn.Read(make([]byte, 16)); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Read() error = %v, want a timeout", err)
+	}
+}
+
+// acceptWith dials a PROXY protocol listener, writes payload and returns the
+// accepted connection
+func acceptWith(t *testing.T, cfg *config.ProxyProtocolConfig, payload []byte) net.Conn {
+	t.Helper()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { inner.Close() })
+
+	listener, err := NewListener(inner, cfg)
+	if err != nil {
+		t.Fatalf("NewListener() returned error: %v", err)
+	}
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Write(payload); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept() returned error: %v", err)
+	}
+	return conn
+}
@@ -0,0 +1,216 @@
+package resilience
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/metrics"
+)
+
+// RejectAdaptiveLimit is the rejection reason for the adaptive limiter
+const RejectAdaptiveLimit = "adaptive_limit"
+
+// limitAlgorithm computes the next concurrency limit from one call's latency.
+// inFlight is the number of calls in flight when the call started; dropped
+// reports a failed or timed out call.
+type limitAlgorithm interface {
+	update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64
+}
+
+// AdaptiveLimiter limits in-flight calls to a limit that follows observed
+// latency, in the style of Netflix concurrency-limits
+type AdaptiveLimiter struct {
+	name       string
+	algorithm  string
+	update     limitAlgorithm
+	minLimit   float64
+	maxLimit   float64
+	retryAfter time.Duration
+
+	limit    float64
+	inFlight int
+	rejected int64
+	lastRTT  time.Duration
+	mutex    sync.Mutex
+}
+
+type AdaptiveStats struct {
+	Name      string  `json:"name"`
+	Algorithm string  `json:"algorithm"`
+	Limit     int     `json:"limit"`
+	MinLimit  int     `json:"min_limit"`
+	MaxLimit  int     `json:"max_limit"`
+	InFlight  int     `json:"in_flight"`
+	Rejected  int64   `json:"rejected"`
+	LastRTTMs float64 `json:"last_rtt_ms"`
+}
+
+func NewAdaptiveLimiter(name string, cfg *config.ConcurrencyConfig) *AdaptiveLimiter {
+	adaptive := cfg.Adaptive
+
+	var algorithm limitAlgorithm
+	if adaptive.Algorithm == "gradient" {
+		algorithm = &gradientLimit{tolerance: adaptive.Tolerance, smoothing: adaptive.Smoothing}
+	} else {
+		algorithm = &aimdLimit{threshold: adaptive.LatencyThreshold, backoffRatio: adaptive.BackoffRatio}
+	}
+
+	l := &AdaptiveLimiter{
+		name:       name,
+		algorithm:  adaptive.Algorithm,
+		update:     algorithm,
+		minLimit:   float64(adaptive.MinLimit),
+		maxLimit:   float64(adaptive.MaxLimit),
+		retryAfter: cfg.RetryAfter,
+		limit:      float64(adaptive.InitialLimit),
+	}
+	metrics.UpdateConcurrencyLimit(name, l.limit)
+	return l
+}
+
+// Acquire admits a call when fewer calls than the current limit are in
+// flight. The returned function ends the call; passing a zero rtt releases
+// the slot without a latency sample.
+func (l *AdaptiveLimiter) Acquire() (func(rtt time.Duration, dropped bool), bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.inFlight >= int(l.limit) {
+		l.rejected++
+		metrics.RecordConcurrencyRejection(l.name, RejectAdaptiveLimit)
+		return nil, false
+	}
+
+	l.inFlight++
+	startedWith := l.inFlight
+
+	var once sync.Once
+	return func(rtt time.Duration, dropped bool) {
+		once.Do(func() { l.release(startedWith, rtt, dropped) })
+	}, true
+}
+
+func (l *AdaptiveLimiter) release(startedWith int, rtt time.Duration, dropped bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inFlight--
+	if rtt <= 0 {
+		return
+	}
+
+	l.lastRTT = rtt
+	limit := l.update.update(l.limit, rtt, startedWith, dropped)
+	limit = math.Max(l.minLimit, math.Min(l.maxLimit, limit))
+	if int(limit) != int(l.limit) {
+		metrics.UpdateConcurrencyLimit(l.name, math.Floor(limit))
+	}
+	l.limit = limit
+}
+
+func (l *AdaptiveLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done, ok := l.Acquire()
+		if !ok {
+			WriteOverloaded(w, l.retryAfter)
+			return
+		}
+
+		wrapped := &circuitBreakerResponseWriter{
+			ResponseWriter: w,
+			statusCode:     200,
+		}
+
+		start := time.Now()
+		next.ServeHTTP(wrapped, r)
+		rtt := time.Since(start)
+
+		// Upgraded connections live as long as the client wants, and abandoned
+		// requests end early, so neither says anything about upstream latency
+		ctxErr := r.Context().Err()
+		if wrapped.hijacked || ctxErr == context.Canceled {
+			done(0, false)
+			return
+		}
+
+		dropped := ctxErr == context.DeadlineExceeded || !wrapped.wroteHeader || wrapped.statusCode >= 500
+		done(rtt, dropped)
+	})
+}
+
+func (l *AdaptiveLimiter) Stats() AdaptiveStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return AdaptiveStats{
+		Name:      l.name,
+		Algorithm: l.algorithm,
+		Limit:     int(l.limit),
+		MinLimit:  int(l.minLimit),
+		MaxLimit:  int(l.maxLimit),
+		InFlight:  l.inFlight,
+		Rejected:  l.rejected,
+		LastRTTMs: float64(l.lastRTT) / float64(time.Millisecond),
+	}
+}
+
+// aimdLimit grows the limit by one per fast call while the route is using at
+// least half of it, and multiplies it by the backoff ratio on slow or failed calls
+type aimdLimit struct {
+	threshold    time.Duration
+	backoffRatio float64
+}
+
+func (a *aimdLimit) update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	if dropped || rtt > a.threshold {
+		return limit * a.backoffRatio
+	}
+	if float64(inFlight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// gradientLimit compares each call's latency with a long-term average. The
+// limit shrinks in proportion as latency rises beyond the tolerance and grows
+// by a small queue allowance while latency stays flat.
+type gradientLimit struct {
+	tolerance float64
+	smoothing float64
+	longRTT   float64 // exponential moving average, in nanoseconds
+}
+
+// gradientWarmup is the number of samples the long-term average roughly spans
+const gradientWarmup = 600
+
+func (g *gradientLimit) update(limit float64, rtt time.Duration, inFlight int, dropped bool) float64 {
+	shortRTT := float64(rtt)
+	if g.longRTT == 0 {
+		g.longRTT = shortRTT
+	} else {
+		factor := 2.0 / (gradientWarmup + 1)
+		g.longRTT = g.longRTT*(1-factor) + shortRTT*factor
+	}
+
+	// A sustained latency drop leaves the average far above current calls;
+	// decay it so the limit can recover
+	if g.longRTT/shortRTT > 2 {
+		g.longRTT *= 0.95
+	}
+
+	// The route is not using its limit, so latency says nothing about it
+	if float64(inFlight) < limit/2 && !dropped {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1.0, g.tolerance*g.longRTT/shortRTT))
+	if dropped {
+		gradient = 0.5
+	}
+	next := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + next*g.smoothing
+}
@@ -0,0 +1,110 @@
+package resilience
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func newTestAdaptiveLimiter(algorithm string, initial int) *AdaptiveLimiter {
+	return NewAdaptiveLimiter("api", &config.ConcurrencyConfig{
+		RetryAfter: time.Second,
+		Adaptive: &config.AdaptiveConcurrencyConfig{
+			Enabled:          true,
+			Algorithm:        algorithm,
+			InitialLimit:     initial,
+			MinLimit:         1,
+			MaxLimit:         100,
+			LatencyThreshold: 100 * time.Millisecond,
+			BackoffRatio:     0.5,
+			Tolerance:        1.5,
+			Smoothing:        1,
+		},
+	})
+}
+
+func TestAdaptiveLimiterAIMDBacksOffOnSlowCallsAndGrowsUnderLoad(t *testing.T) {
+	limiter := newTestAdaptiveLimiter("aimd", 10)
+
+	done, ok := limiter.Acquire()
+	if !ok {
+		t.Fatal("Acquire() rejected a call below the limit")
+	}
+	done(time.Second, false)
+	if limit := limiter.Stats().Limit; limit != 5 {
+		t.Fatalf("limit after slow call = %d, want 5", limit)
+	}
+
+	// Only calls made while the route uses at least half its limit grow it
+	releases := make([]func(time.Duration, bool), 0, 3)
+	for i := 0; i < 3; i++ {
+		done, ok := limiter.Acquire()
+		if !ok {
+			t.Fatalf("Acquire() #%d rejected a call below the limit", i)
+		}
+		releases = append(releases, done)
+	}
+	releases[0](10*time.Millisecond, false)
+	releases[2](10*time.Millisecond, false)
+	releases[1](10*time.Millisecond, false)
+	if limit := limiter.Stats().Limit; limit != 6 {
+		t.Fatalf("limit after fast calls under load = %d, want 6", limit)
+	}
+}
+
+func TestAdaptiveLimiterGradientShrinksWhenLatencyRises(t *testing.T) {
+	gradient := &gradientLimit{tolerance: 1.5, smoothing: 1}
+
+	limit := 16.0
+	for i := 0; i < 10; i++ {
+		limit = gradient.update(limit, 10*time.Millisecond, int(limit), false)
+	}
+	if limit <= 16 {
+		t.Fatalf("limit with steady latency = %.1f, want growth above 16", limit)
+	}
+
+	spiked := gradient.update(limit, 100*time.Millisecond, int(limit), false)
+	if spiked >= limit {
+		t.Fatalf("limit after latency spike = %.1f, want below %.1f", spiked, limit)
+	}
+}
+
+func TestAdaptiveLimiterMiddlewareRejectsOverLimit(t *testing.T) {
+	limiter := newTestAdaptiveLimiter("aimd", 1)
+
+	done, ok := limiter.Acquire()
+	if !ok {
+		t.Fatal("Acquire() rejected the first call")
+	}
+	defer done(0, false)
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request should not reach the handler over the limit")
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api", nil))
+
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("response = %d Retry-After %q, want 503 with Retry-After 1", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if stats := limiter.Stats(); stats.Rejected != 1 || stats.InFlight != 1 {
+		t.Fatalf("stats = %+v, want 1 rejected and 1 in flight", stats)
+	}
+}
+
+func TestAdaptiveLimiterClampsToConfiguredRange(t *testing.T) {
+	limiter := newTestAdaptiveLimiter("aimd", 1)
+
+	for i := 0; i < 5; i++ {
+		done, _ := limiter.Acquire()
+		done(0, false)
+		done, _ = limiter.Acquire()
+		done(time.Second, true)
+	}
+	if limit := limiter.Stats().Limit; limit != 1 {
+		t.Fatalf("limit = %d, want clamped to min_limit 1", limit)
+	}
+}
@@ -0,0 +1,139 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/metrics"
+)
+
+var (
+	// ErrBulkheadFull is returned when every slot and queue position is taken
+	ErrBulkheadFull = errors.New("too many concurrent requests")
+	// ErrQueueTimeout is returned when no slot frees up within the queue timeout
+	ErrQueueTimeout = errors.New("timed out waiting for a concurrency slot")
+)
+
+// Rejection reasons reported in gonk_concurrency_rejections_total
+const (
+	RejectQueueFull     = "queue_full"
+	RejectQueueTimeout  = "queue_timeout"
+	RejectUpstreamLimit = "upstream_limit"
+)
+
+// Bulkhead limits the calls in flight for a route. Callers beyond the limit
+// wait in a bounded queue for up to the queue timeout.
+type Bulkhead struct {
+	name         string
+	slots        chan struct{}
+	maxQueue     int32
+	queueTimeout time.Duration
+	retryAfter   time.Duration
+	queued       int32
+	rejected     int64
+}
+
+type BulkheadStats struct {
+	Name        string `json:"name"`
+	MaxInFlight int    `json:"max_in_flight"`
+	InFlight    int    `json:"in_flight"`
+	MaxQueue    int    `json:"max_queue"`
+	Queued      int    `json:"queued"`
+	Rejected    int64  `json:"rejected"`
+}
+
+func NewBulkhead(name string, cfg *config.ConcurrencyConfig) *Bulkhead {
+	return &Bulkhead{
+		name:         name,
+		slots:        make(chan struct{}, cfg.MaxInFlight),
+		maxQueue:     int32(cfg.MaxQueue),
+		queueTimeout: cfg.QueueTimeout,
+		retryAfter:   cfg.RetryAfter,
+	}
+}
+
+// Acquire takes a slot, waiting in the queue when all slots are busy. The
+// returned function releases the slot and must be called exactly once.
+func (b *Bulkhead) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	default:
+	}
+
+	if atomic.AddInt32(&b.queued, 1) > b.maxQueue {
+		atomic.AddInt32(&b.queued, -1)
+		b.reject(RejectQueueFull)
+		return nil, ErrBulkheadFull
+	}
+	defer atomic.AddInt32(&b.queued, -1)
+
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	case <-timer.C:
+		b.reject(RejectQueueTimeout)
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Bulkhead) release() {
+	<-b.slots
+}
+
+func (b *Bulkhead) reject(reason string) {
+	atomic.AddInt64(&b.rejected, 1)
+	metrics.RecordConcurrencyRejection(b.name, reason)
+}
+
+func (b *Bulkhead) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := b.Acquire(r.Context())
+		if err != nil {
+			// Nobody is left to read a response for abandoned requests
+			if r.Context().Err() == nil {
+				WriteOverloaded(w, b.retryAfter)
+			}
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		Name:        b.name,
+		MaxInFlight: cap(b.slots),
+		InFlight:    len(b.slots),
+		MaxQueue:    int(b.maxQueue),
+		Queued:      int(atomic.LoadInt32(&b.queued)),
+		Rejected:    atomic.LoadInt64(&b.rejected),
+	}
+}
+
+// WriteOverloaded rejects a request that exceeded a concurrency limit with
+// 503 and a Retry-After hint in whole seconds
+func WriteOverloaded(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(`{"error":"too many concurrent requests"}`))
+}
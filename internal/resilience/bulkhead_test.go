@@ -0,0 +1,71 @@
+package resilience
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func TestBulkheadRejectsWhenQueueIsFull(t *testing.T) {
+	bulkhead := NewBulkhead("api", &config.ConcurrencyConfig{MaxInFlight: 1, RetryAfter: 2 * time.Second})
+
+	release, err := bulkhead.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() returned error: %v", err)
+	}
+	defer release()
+
+	handler := bulkhead.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request should not reach the handler while the bulkhead is full")
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+	if stats := bulkhead.Stats(); stats.InFlight != 1 || stats.Rejected != 1 {
+		t.Fatalf("stats = %+v, want 1 in flight and 1 rejected", stats)
+	}
+}
+
+func TestBulkheadQueuedRequestGetsReleasedSlot(t *testing.T) {
+	bulkhead := NewBulkhead("api", &config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	release, err := bulkhead.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() returned error: %v", err)
+	}
+	time.AfterFunc(20*time.Millisecond, release)
+
+	queuedRelease, err := bulkhead.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("queued Acquire() returned error: %v", err)
+	}
+	queuedRelease()
+
+	if stats := bulkhead.Stats(); stats.InFlight != 0 || stats.Queued != 0 || stats.Rejected != 0 {
+		t.Fatalf("stats = %+v, want an idle bulkhead", stats)
+	}
+}
+
+func TestBulkheadQueueTimesOut(t *testing.T) {
+	bulkhead := NewBulkhead("api", &config.ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond})
+
+	release, err := bulkhead.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() returned error: %v", err)
+	}
+	defer release()
+
+	if _, err := bulkhead.Acquire(context.Background()); err != ErrQueueTimeout {
+		t.Fatalf("queued Acquire() error = %v, want ErrQueueTimeout", err)
+	}
+}
@@ -2,13 +2,18 @@ package resilience
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/metrics"
 )
 
 type State int
@@ -20,16 +25,35 @@ const (
 )
 
 type CircuitBreaker struct {
-	name            string
-	maxFailures     int
-	resetTimeout    time.Duration
-	halfOpenMaxReqs int
+	name                  string
+	maxFailures           int
+	resetTimeout          time.Duration
+	halfOpenMaxReqs       int
+	windowType            string
+	minimumRequests       int
+	failureRateThreshold  float64
+	slowCallDuration      time.Duration
+	slowCallRateThreshold float64
+	failureStatusCodes    map[int]bool
+	settings              *config.CircuitBreakerConfig // as created from, to tell a changed config
 
 	mutex           sync.RWMutex
 	state           State
 	failures        int
 	lastFailureTime time.Time
+	openedAt        time.Time
 	successCount    int
+	window          slidingWindow
+	override        *Override
+}
+
+// Override pins a breaker open or closed, for example during maintenance.
+// A nil Until keeps the override until it is reset or replaced.
+type Override struct {
+	State  string     `json:"state"`
+	Reason string     `json:"reason,omitempty"`
+	SetAt  time.Time  `json:"set_at"`
+	Until  *time.Time `json:"until,omitempty"`
 }
 
 type Stats struct {
@@ -41,31 +65,86 @@ type Stats struct {
 	HalfOpenMaxReqs int       `json:"half_open_max_reqs"`
 	ResetTimeout    string    `json:"reset_timeout"`
 	LastFailureTime time.Time `json:"last_failure_time,omitempty"`
+	WindowType      string    `json:"window_type"`
+	WindowCalls     int       `json:"window_calls"`
+	FailureRate     float64   `json:"failure_rate"`
+	SlowCallRate    float64   `json:"slow_call_rate"`
+	Override        *Override `json:"override,omitempty"`
 }
 
-func NewCircuitBreaker(name string, config *config.CircuitBreakerConfig) *CircuitBreaker {
-	if config == nil {
-		return &CircuitBreaker{
-			name:            name,
-			maxFailures:     5,
-			resetTimeout:    60 * time.Second,
-			halfOpenMaxReqs: 3,
-			state:           StateClosed,
-		}
+// StateChange describes a circuit breaker state transition
+type StateChange struct {
+	Name   string    `json:"name"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+
+	to State
+}
+
+var errNoResponse = errors.New("handler returned without a response")
+
+type callErrorKey struct{}
+
+// RecordError reports a transport error to the breaker middleware serving r,
+// so that the breaker sees why the call failed and not only the status the
+// handler wrote for it. It does nothing outside that middleware.
+func RecordError(r *http.Request, err error) {
+	if slot, ok := r.Context().Value(callErrorKey{}).(*error); ok {
+		*slot = err
 	}
+}
 
-	return &CircuitBreaker{
+func NewCircuitBreaker(name string, config *config.CircuitBreakerConfig) *CircuitBreaker {
+	cb := &CircuitBreaker{
 		name:            name,
-		maxFailures:     config.MaxFailures,
-		resetTimeout:    config.ResetTimeout,
-		halfOpenMaxReqs: config.HalfOpenMaxReqs,
+		settings:        config,
+		maxFailures:     5,
+		resetTimeout:    60 * time.Second,
+		halfOpenMaxReqs: 3,
+		windowType:      "count",
+		minimumRequests: 10,
 		state:           StateClosed,
 	}
+
+	windowSize, windowDuration := 100, 60*time.Second
+	if config != nil {
+		cb.maxFailures = config.MaxFailures
+		cb.resetTimeout = config.ResetTimeout
+		cb.halfOpenMaxReqs = config.HalfOpenMaxReqs
+		cb.failureRateThreshold = config.FailureRateThreshold
+		cb.slowCallDuration = config.SlowCallDuration
+		cb.slowCallRateThreshold = config.SlowCallRateThreshold
+
+		if config.WindowType != "" {
+			cb.windowType = config.WindowType
+		}
+		if config.WindowSize > 0 {
+			windowSize = config.WindowSize
+		}
+		if config.WindowDuration > 0 {
+			windowDuration = config.WindowDuration
+		}
+		if config.MinimumRequests > 0 {
+			cb.minimumRequests = config.MinimumRequests
+		}
+		if len(config.FailureStatusCodes) > 0 {
+			cb.failureStatusCodes = make(map[int]bool, len(config.FailureStatusCodes))
+			for _, code := range config.FailureStatusCodes {
+				cb.failureStatusCodes[code] = true
+			}
+		}
+	}
+
+	cb.window = newSlidingWindow(cb.windowType, windowSize, windowDuration)
+	metrics.UpdateCircuitBreakerState(name, float64(StateClosed))
+	return cb
 }
 
 func (cb *CircuitBreaker) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !cb.canExecute() {
+		if !cb.Allow() {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusServiceUnavailable)
 			w.Write([]byte(`{"error":"service temporarily unavailable"}`))
@@ -78,69 +157,286 @@ func (cb *CircuitBreaker) Middleware(next http.Handler) http.Handler {
 			statusCode:     200,
 		}
 
+		var err error
+		r = r.WithContext(context.WithValue(r.Context(), callErrorKey{}, &err))
+
+		start := time.Now()
 		next.ServeHTTP(wrapped, r)
+		duration := time.Since(start)
 
-		// Record result based on status code
-		var err error
-		if wrapped.statusCode >= 500 {
-			err = fmt.Errorf("upstream returned %d", wrapped.statusCode)
+		// Requests abandoned by the client say nothing about the upstream
+		ctxErr := r.Context().Err()
+		if ctxErr == context.Canceled {
+			return
 		}
-		cb.recordResult(err)
+
+		// err is already set when the handler reported one through RecordError
+		if err == nil {
+			if ctxErr == context.DeadlineExceeded {
+				err = ctxErr
+			} else if !wrapped.wroteHeader && !wrapped.hijacked {
+				err = errNoResponse
+			}
+		}
+		cb.Record(wrapped.statusCode, err, duration)
 	})
 }
 
-func (cb *CircuitBreaker) canExecute() bool {
+// Allow reports whether a call may proceed. An open breaker moves to
+// half-open once the reset timeout has elapsed.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+
+	change := cb.expireOverride(time.Now())
+	if cb.override != nil {
+		allowed := cb.state == StateClosed
+		cb.mutex.Unlock()
+		cb.emit(change)
+		return allowed
+	}
+
+	allowed := false
+	switch cb.state {
+	case StateClosed:
+		allowed = true
+	case StateOpen:
+		if time.Since(cb.openedAt) > cb.resetTimeout {
+			change = cb.transition(StateHalfOpen, "reset timeout elapsed")
+			allowed = true
+		}
+	case StateHalfOpen:
+		allowed = cb.successCount < cb.halfOpenMaxReqs
+	}
+
+	cb.mutex.Unlock()
+	cb.emit(change)
+	return allowed
+}
+
+// Admits reports whether Allow would let a call through, without moving an
+// open breaker to half-open. It is meant for filtering candidates; Allow is
+// then called only for the one that is used.
+func (cb *CircuitBreaker) Admits() bool {
 	cb.mutex.RLock()
 	defer cb.mutex.RUnlock()
 
+	now := time.Now()
+	if cb.override != nil {
+		if cb.override.Until == nil || now.Before(*cb.override.Until) {
+			return cb.state == StateClosed
+		}
+		// An expired override leaves an open breaker half-open
+		if cb.state == StateOpen {
+			return true
+		}
+	}
+
 	switch cb.state {
 	case StateClosed:
 		return true
 	case StateOpen:
-		if time.Since(cb.lastFailureTime) > cb.resetTimeout {
-			cb.mutex.RUnlock()
-			cb.mutex.Lock()
-			cb.state = StateHalfOpen
-			cb.successCount = 0
-			cb.mutex.Unlock()
-			cb.mutex.RLock()
-			return true
-		}
-		return false
-	case StateHalfOpen:
-		return cb.successCount < cb.halfOpenMaxReqs
+		return now.Sub(cb.openedAt) > cb.resetTimeout
 	default:
-		return false
+		return cb.successCount < cb.halfOpenMaxReqs
+	}
+}
+
+// IsFailure classifies a call outcome. Errors always count as failures;
+// status codes are checked against the configured list, or any 5xx.
+func (cb *CircuitBreaker) IsFailure(statusCode int, err error) bool {
+	if err != nil {
+		return true
+	}
+	if len(cb.failureStatusCodes) > 0 {
+		return cb.failureStatusCodes[statusCode]
 	}
+	return statusCode >= 500
 }
 
-func (cb *CircuitBreaker) recordResult(err error) {
+// Record records the outcome of a call admitted by Allow
+func (cb *CircuitBreaker) Record(statusCode int, err error, duration time.Duration) {
+	failed := cb.IsFailure(statusCode, err)
+	slow := cb.slowCallDuration > 0 && duration >= cb.slowCallDuration
+	now := time.Now()
+
 	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
 
-	if err != nil {
+	change := cb.expireOverride(now)
+	cb.window.record(now, failed, slow)
+	if failed {
 		cb.failures++
-		cb.lastFailureTime = time.Now()
+		cb.lastFailureTime = now
+	}
 
-		if cb.state == StateHalfOpen || cb.failures >= cb.maxFailures {
-			cb.state = StateOpen
-		}
-	} else {
-		if cb.state == StateHalfOpen {
+	// Overridden breakers keep counting but do not change state on their own
+	if cb.override != nil {
+		cb.mutex.Unlock()
+		cb.emit(change)
+		return
+	}
+
+	switch cb.state {
+	case StateHalfOpen:
+		if failed {
+			change = cb.transition(StateOpen, "failure while half-open")
+		} else {
 			cb.successCount++
 			if cb.successCount >= cb.halfOpenMaxReqs {
-				cb.state = StateClosed
-				cb.failures = 0
+				change = cb.transition(StateClosed, "half-open calls succeeded")
 			}
-		} else if cb.state == StateClosed {
+		}
+	case StateClosed:
+		if !failed {
 			cb.failures = 0
 		}
+		if reason := cb.tripReason(now); reason != "" {
+			change = cb.transition(StateOpen, reason)
+		}
 	}
+
+	cb.mutex.Unlock()
+	cb.emit(change)
+}
+
+// ForceState pins the breaker open or closed. A zero until keeps the override
+// until Reset or another ForceState; otherwise the breaker resumes normal
+// operation at until, probing through half-open if it was forced open.
+func (cb *CircuitBreaker) ForceState(state State, until time.Time, reason string) error {
+	if state != StateOpen && state != StateClosed {
+		return fmt.Errorf("circuit breaker can only be forced open or closed")
+	}
+
+	cb.mutex.Lock()
+	override := &Override{State: state.String(), Reason: reason, SetAt: time.Now()}
+	if !until.IsZero() {
+		override.Until = &until
+	}
+	cb.override = override
+
+	description := "forced " + state.String()
+	if reason != "" {
+		description += ": " + reason
+	}
+	change := cb.transition(state, description)
+	cb.mutex.Unlock()
+
+	cb.emit(change)
+	return nil
+}
+
+// Reset clears any override and all counters, and closes the breaker
+func (cb *CircuitBreaker) Reset() {
+	cb.mutex.Lock()
+	cb.override = nil
+	cb.failures = 0
+	cb.successCount = 0
+	cb.window.reset()
+	change := cb.transition(StateClosed, "reset")
+	cb.mutex.Unlock()
+
+	cb.emit(change)
+}
+
+// expireOverride drops an override whose expiry has passed. Callers must
+// hold cb.mutex and pass the result to emit after unlocking.
+func (cb *CircuitBreaker) expireOverride(now time.Time) *StateChange {
+	if cb.override == nil || cb.override.Until == nil || now.Before(*cb.override.Until) {
+		return nil
+	}
+
+	cb.override = nil
+	if cb.state == StateOpen {
+		return cb.transition(StateHalfOpen, "override expired")
+	}
+	return nil
+}
+
+// tripReason returns why a closed breaker should open, or "" if it should not.
+// Callers must hold cb.mutex.
+func (cb *CircuitBreaker) tripReason(now time.Time) string {
+	if cb.maxFailures > 0 && cb.failures >= cb.maxFailures {
+		return fmt.Sprintf("%d consecutive failures", cb.failures)
+	}
+
+	totals := cb.window.totals(now)
+	if totals.calls < cb.minimumRequests {
+		return ""
+	}
+
+	if cb.failureRateThreshold > 0 {
+		if rate := percent(totals.failures, totals.calls); rate >= cb.failureRateThreshold {
+			return fmt.Sprintf("failure rate %.1f%% over %d calls", rate, totals.calls)
+		}
+	}
+	if cb.slowCallRateThreshold > 0 {
+		if rate := percent(totals.slow, totals.calls); rate >= cb.slowCallRateThreshold {
+			return fmt.Sprintf("slow call rate %.1f%% over %d calls", rate, totals.calls)
+		}
+	}
+	return ""
+}
+
+// transition changes state and resets the counters that belong to the new
+// state. Callers must hold cb.mutex and pass the result to emit after unlocking.
+func (cb *CircuitBreaker) transition(to State, reason string) *StateChange {
+	from := cb.state
+	if from == to {
+		return nil
+	}
+
+	now := time.Now()
+	cb.state = to
+	switch to {
+	case StateOpen:
+		cb.openedAt = now
+	case StateHalfOpen:
+		cb.successCount = 0
+	case StateClosed:
+		cb.failures = 0
+		cb.window.reset()
+	}
+
+	return &StateChange{
+		Name:   cb.name,
+		From:   from.String(),
+		To:     to.String(),
+		Reason: reason,
+		At:     now,
+		to:     to,
+	}
+}
+
+func (cb *CircuitBreaker) emit(change *StateChange) {
+	if change == nil {
+		return
+	}
+
+	log.Printf("circuit_breaker name=%s from=%s to=%s reason=%q", change.Name, change.From, change.To, change.Reason)
+	metrics.RecordCircuitBreakerTransition(change.Name, change.From, change.To)
+	metrics.UpdateCircuitBreakerState(change.Name, float64(change.to))
 }
 
 func (cb *CircuitBreaker) Stats() Stats {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
+	now := time.Now()
+
+	cb.mutex.Lock()
+	change := cb.expireOverride(now)
+	stats := cb.statsLocked(now)
+	cb.mutex.Unlock()
+
+	cb.emit(change)
+	return stats
+}
+
+// statsLocked builds the stats snapshot. Callers must hold cb.mutex.
+func (cb *CircuitBreaker) statsLocked(now time.Time) Stats {
+	totals := cb.window.totals(now)
+
+	var override *Override
+	if cb.override != nil {
+		copy := *cb.override
+		override = &copy
+	}
 
 	return Stats{
 		Name:            cb.name,
@@ -151,9 +447,78 @@ func (cb *CircuitBreaker) Stats() Stats {
 		HalfOpenMaxReqs: cb.halfOpenMaxReqs,
 		ResetTimeout:    cb.resetTimeout.String(),
 		LastFailureTime: cb.lastFailureTime,
+		WindowType:      cb.windowType,
+		WindowCalls:     totals.calls,
+		FailureRate:     percent(totals.failures, totals.calls),
+		SlowCallRate:    percent(totals.slow, totals.calls),
+		Override:        override,
 	}
 }
 
+// State returns the current breaker state
+func (cb *CircuitBreaker) State() State {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	return cb.state
+}
+
+// AggregateStats summarizes a group of breakers, such as the per-upstream
+// breakers of one route. The group is open only when every breaker is open,
+// half-open when none is closed, and closed otherwise.
+func AggregateStats(name string, members []Stats) Stats {
+	aggregate := Stats{Name: name, State: StateClosed.String()}
+	if len(members) == 0 {
+		return aggregate
+	}
+
+	open, halfOpen := 0, 0
+	var failedCalls, slowCalls float64
+	for _, member := range members {
+		switch member.State {
+		case StateOpen.String():
+			open++
+		case StateHalfOpen.String():
+			halfOpen++
+		}
+
+		aggregate.Failures += member.Failures
+		aggregate.SuccessCount += member.SuccessCount
+		aggregate.WindowCalls += member.WindowCalls
+		failedCalls += member.FailureRate * float64(member.WindowCalls) / 100
+		slowCalls += member.SlowCallRate * float64(member.WindowCalls) / 100
+		if member.LastFailureTime.After(aggregate.LastFailureTime) {
+			aggregate.LastFailureTime = member.LastFailureTime
+		}
+	}
+
+	switch {
+	case open == len(members):
+		aggregate.State = StateOpen.String()
+	case open+halfOpen == len(members):
+		aggregate.State = StateHalfOpen.String()
+	}
+
+	first := members[0]
+	aggregate.MaxFailures = first.MaxFailures
+	aggregate.HalfOpenMaxReqs = first.HalfOpenMaxReqs
+	aggregate.ResetTimeout = first.ResetTimeout
+	aggregate.WindowType = first.WindowType
+	if aggregate.WindowCalls > 0 {
+		aggregate.FailureRate = failedCalls * 100 / float64(aggregate.WindowCalls)
+		aggregate.SlowCallRate = slowCalls * 100 / float64(aggregate.WindowCalls)
+	}
+
+	return aggregate
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
 func (s State) String() string {
 	switch s {
 	case StateClosed:
@@ -169,14 +534,24 @@ func (s State) String() string {
 
 type circuitBreakerResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
+	hijacked    bool
 }
 
 func (w *circuitBreakerResponseWriter) WriteHeader(code int) {
-	w.statusCode = code
+	if !w.wroteHeader {
+		w.statusCode = code
+		w.wroteHeader = true
+	}
 	w.ResponseWriter.WriteHeader(code)
 }
 
+func (w *circuitBreakerResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
 func (w *circuitBreakerResponseWriter) Unwrap() http.ResponseWriter {
 	return w.ResponseWriter
 }
@@ -192,6 +567,7 @@ func (w *circuitBreakerResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, er
 	if !ok {
 		return nil, nil, http.ErrNotSupported
 	}
+	w.hijacked = true
 	return hijacker.Hijack()
 }
 
@@ -207,9 +583,12 @@ func NewCircuitBreakerManager() *CircuitBreakerManager {
 	}
 }
 
+// GetOrCreate returns the breaker registered under name, creating it if
+// there is none. A breaker created from a different config is replaced, so
+// a reload that changes the thresholds takes effect.
 func (m *CircuitBreakerManager) GetOrCreate(name string, config *config.CircuitBreakerConfig) *CircuitBreaker {
 	m.mutex.RLock()
-	if cb, exists := m.breakers[name]; exists {
+	if cb, exists := m.breakers[name]; exists && reflect.DeepEqual(cb.settings, config) {
 		m.mutex.RUnlock()
 		return cb
 	}
@@ -218,7 +597,7 @@ func (m *CircuitBreakerManager) GetOrCreate(name string, config *config.CircuitB
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if cb, exists := m.breakers[name]; exists {
+	if cb, exists := m.breakers[name]; exists && reflect.DeepEqual(cb.settings, config) {
 		return cb
 	}
 
@@ -227,6 +606,14 @@ func (m *CircuitBreakerManager) GetOrCreate(name string, config *config.CircuitB
 	return cb
 }
 
+// Get returns the breaker registered under name, or nil
+func (m *CircuitBreakerManager) Get(name string) *CircuitBreaker {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.breakers[name]
+}
+
 func (m *CircuitBreakerManager) Stats() map[string]Stats {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
@@ -1,8 +1,12 @@
 package resilience
 
 import (
+	"bytes"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -66,6 +70,29 @@ func TestCircuitBreakerClosesAfterHalfOpenSuccess(t *testing.T) {
 	}
 }
 
+func TestCircuitBreakerAdmitsWithoutChangingState(t *testing.T) {
+	cb := NewCircuitBreaker("api", &config.CircuitBreakerConfig{
+		MaxFailures:     1,
+		ResetTimeout:    time.Millisecond,
+		HalfOpenMaxReqs: 1,
+	})
+	cb.Record(http.StatusBadGateway, nil, time.Millisecond)
+	if cb.Admits() {
+		t.Fatal("Admits() = true right after the breaker opened")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if !cb.Admits() {
+		t.Fatal("Admits() = false after the reset timeout")
+	}
+	if state := cb.State(); state != StateOpen {
+		t.Fatalf("state after Admits() = %v, want StateOpen", state)
+	}
+	if !cb.Allow() || cb.State() != StateHalfOpen {
+		t.Fatalf("Allow() should move the breaker to half-open, state = %v", cb.State())
+	}
+}
+
 func TestCircuitBreakerManagerReusesExistingBreaker(t *testing.T) {
 	manager := NewCircuitBreakerManager()
 	cfg := &config.CircuitBreakerConfig{MaxFailures: 1, ResetTimeout: time.Second, HalfOpenMaxReqs: 1}
@@ -77,3 +104,201 @@ func TestCircuitBreakerManagerReusesExistingBreaker(t *testing.T) {
 		t.Fatal("manager should reuse the existing circuit breaker for the same name")
 	}
 }
+
+func TestCircuitBreakerOpensOnFailureRateAfterMinimumRequests(t *testing.T) {
+	cb := NewCircuitBreaker("api", &config.CircuitBreakerConfig{
+		ResetTimeout:         time.Hour,
+		HalfOpenMaxReqs:      1,
+		WindowType:           "count",
+		WindowSize:           10,
+		MinimumRequests:      4,
+		FailureRateThreshold: 50,
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// Alternating results never reach consecutive failures, only the rate
+	outcomes := []int{http.StatusOK, http.StatusBadGateway, http.StatusOK}
+	for _, status := range outcomes {
+		cb.Record(status, nil, time.Millisecond)
+	}
+	if cb.state != StateClosed {
+		t.Fatalf("state = %v before minimum requests, want StateClosed", cb.state)
+	}
+
+	cb.Record(http.StatusBadGateway, nil, time.Millisecond)
+	if cb.state != StateOpen {
+		t.Fatalf("state = %v at 50%% failure rate, want StateOpen", cb.state)
+	}
+	if cb.Allow() {
+		t.Fatal("open breaker should reject calls")
+	}
+	if strings.Count(logs.String(), "circuit_breaker name=") != 1 || !strings.Contains(logs.String(), "circuit_breaker name=api from=closed to=open") {
+		t.Fatalf("state changes logged = %q, want one closed -> open", logs.String())
+	}
+}
+
+func TestCircuitBreakerOpensOnSlowCallRate(t *testing.T) {
+	cb := NewCircuitBreaker("api", &config.CircuitBreakerConfig{
+		ResetTimeout:          time.Hour,
+		HalfOpenMaxReqs:       1,
+		MinimumRequests:       2,
+		SlowCallDuration:      100 * time.Millisecond,
+		SlowCallRateThreshold: 100,
+	})
+
+	cb.Record(http.StatusOK, nil, 200*time.Millisecond)
+	cb.Record(http.StatusOK, nil, 300*time.Millisecond)
+
+	if cb.state != StateOpen {
+		t.Fatalf("state = %v, want StateOpen", cb.state)
+	}
+	if stats := cb.Stats(); stats.SlowCallRate != 100 || stats.WindowCalls != 2 {
+		t.Fatalf("stats = %+v, want 2 calls at 100%% slow call rate", stats)
+	}
+}
+
+func TestCircuitBreakerUsesConfiguredFailureStatusCodes(t *testing.T) {
+	cb := NewCircuitBreaker("api", &config.CircuitBreakerConfig{
+		MaxFailures:        10,
+		ResetTimeout:       time.Hour,
+		HalfOpenMaxReqs:    1,
+		FailureStatusCodes: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	})
+
+	if !cb.IsFailure(http.StatusTooManyRequests, nil) {
+		t.Fatal("429 should be a failure when listed")
+	}
+	if cb.IsFailure(http.StatusInternalServerError, nil) {
+		t.Fatal("500 should not be a failure when not listed")
+	}
+	if !cb.IsFailure(0, errNoResponse) {
+		t.Fatal("errors should always be failures")
+	}
+}
+
+func TestCircuitBreakerMiddlewareCountsMissingResponseAsFailure(t *testing.T) {
+	cb := NewCircuitBreaker("api", &config.CircuitBreakerConfig{
+		MaxFailures:     1,
+		ResetTimeout:    time.Hour,
+		HalfOpenMaxReqs: 1,
+	})
+
+	handler := cb.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if cb.state != StateOpen {
+		t.Fatalf("state = %v, want StateOpen", cb.state)
+	}
+}
+
+func TestTimeWindowDropsExpiredBuckets(t *testing.T) {
+	window := newSlidingWindow("time", 0, 10*time.Second)
+	start := time.Unix(1000, 0)
+
+	window.record(start, true, false)
+	window.record(start.Add(5*time.Second), false, true)
+
+	if got := window.totals(start.Add(9 * time.Second)); got.calls != 2 || got.failures != 1 || got.slow != 1 {
+		t.Fatalf("totals within window = %+v, want 2 calls, 1 failure, 1 slow", got)
+	}
+	if got := window.totals(start.Add(12 * time.Second)); got.calls != 1 || got.failures != 0 {
+		t.Fatalf("totals after expiry = %+v, want only the newer call", got)
+	}
+}
+
+func TestCountWindowEvictsOldestOutcome(t *testing.T) {
+	window := newSlidingWindow("count", 2, 0)
+	now := time.Now()
+
+	window.record(now, true, false)
+	window.record(now, false, false)
+	window.record(now, false, false)
+
+	if got := window.totals(now); got.calls != 2 || got.failures != 0 {
+		t.Fatalf("totals = %+v, want 2 calls without the evicted failure", got)
+	}
+}
+
+func TestAggregateStatsOpenOnlyWhenEveryBreakerIsOpen(t *testing.T) {
+	members := []Stats{
+		{Name: "api|a", State: "open", Failures: 3, WindowCalls: 10, FailureRate: 50},
+		{Name: "api|b", State: "closed", WindowCalls: 10},
+	}
+
+	aggregate := AggregateStats("api", members)
+	if aggregate.State != "closed" {
+		t.Fatalf("state = %s with one closed upstream, want closed", aggregate.State)
+	}
+	if aggregate.Failures != 3 || aggregate.WindowCalls != 20 || aggregate.FailureRate != 25 {
+		t.Fatalf("aggregate = %+v, want 3 failures over 20 calls at 25%%", aggregate)
+	}
+
+	members[1].State = "half_open"
+	if got := AggregateStats("api", members).State; got != "half_open" {
+		t.Fatalf("state = %s with no closed upstream, want half_open", got)
+	}
+
+	members[1].State = "open"
+	if got := AggregateStats("api", members).State; got != "open" {
+		t.Fatalf("state = %s with every upstream open, want open", got)
+	}
+}
+
+func TestCircuitBreakerForcedOpenExpiresIntoHalfOpen(t *testing.T) {
+	cb := NewCircuitBreaker("plc", &config.CircuitBreakerConfig{
+		MaxFailures:     5,
+		ResetTimeout:    time.Hour,
+		HalfOpenMaxReqs: 1,
+	})
+
+	if err := cb.ForceState(StateOpen, time.Now().Add(time.Hour), "maintenance"); err != nil {
+		t.Fatalf("ForceState() returned error: %v", err)
+	}
+	if cb.Allow() {
+		t.Fatal("forced open breaker should reject calls")
+	}
+	if override := cb.Stats().Override; override == nil || override.State != "open" || override.Reason != "maintenance" {
+		t.Fatalf("override = %+v, want open for maintenance", override)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	cb.override.Until = &expired
+	if !cb.Allow() {
+		t.Fatal("breaker should admit a probe once the override expires")
+	}
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("state = %v after override expiry, want StateHalfOpen", cb.State())
+	}
+}
+
+func TestCircuitBreakerForcedClosedIgnoresFailuresUntilReset(t *testing.T) {
+	cb := NewCircuitBreaker("plc", &config.CircuitBreakerConfig{
+		MaxFailures:     1,
+		ResetTimeout:    time.Hour,
+		HalfOpenMaxReqs: 1,
+	})
+	cb.Record(http.StatusBadGateway, nil, time.Millisecond)
+	if cb.State() != StateOpen {
+		t.Fatalf("state = %v, want StateOpen", cb.State())
+	}
+
+	if err := cb.ForceState(StateClosed, time.Time{}, ""); err != nil {
+		t.Fatalf("ForceState() returned error: %v", err)
+	}
+	cb.Record(http.StatusBadGateway, nil, time.Millisecond)
+	if !cb.Allow() || cb.State() != StateClosed {
+		t.Fatalf("state = %v, want forced closed breaker to keep admitting calls", cb.State())
+	}
+
+	cb.Reset()
+	if stats := cb.Stats(); stats.Override != nil || stats.Failures != 0 || stats.WindowCalls != 0 {
+		t.Fatalf("stats after reset = %+v, want no override and cleared counters", stats)
+	}
+
+	if err := cb.ForceState(StateHalfOpen, time.Time{}, ""); err == nil {
+		t.Fatal("ForceState() should reject half-open")
+	}
+}
@@ -0,0 +1,127 @@
+package resilience
+
+import "time"
+
+// slidingWindow aggregates call outcomes for the failure and slow-call rates
+type slidingWindow interface {
+	record(now time.Time, failed, slow bool)
+	totals(now time.Time) windowTotals
+	reset()
+}
+
+type windowTotals struct {
+	calls    int
+	failures int
+	slow     int
+}
+
+func newSlidingWindow(windowType string, size int, duration time.Duration) slidingWindow {
+	if windowType == "time" {
+		seconds := int(duration / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		return &timeWindow{buckets: make([]timeBucket, seconds)}
+	}
+
+	if size < 1 {
+		size = 1
+	}
+	return &countWindow{outcomes: make([]outcome, size)}
+}
+
+type outcome struct {
+	failed bool
+	slow   bool
+}
+
+// countWindow keeps the outcomes of the last N calls in a ring buffer
+type countWindow struct {
+	outcomes []outcome
+	next     int
+	filled   int
+	current  windowTotals
+}
+
+func (w *countWindow) record(now time.Time, failed, slow bool) {
+	if w.filled == len(w.outcomes) {
+		evicted := w.outcomes[w.next]
+		w.current.calls--
+		if evicted.failed {
+			w.current.failures--
+		}
+		if evicted.slow {
+			w.current.slow--
+		}
+	} else {
+		w.filled++
+	}
+
+	w.outcomes[w.next] = outcome{failed: failed, slow: slow}
+	w.next = (w.next + 1) % len(w.outcomes)
+
+	w.current.calls++
+	if failed {
+		w.current.failures++
+	}
+	if slow {
+		w.current.slow++
+	}
+}
+
+func (w *countWindow) totals(now time.Time) windowTotals {
+	return w.current
+}
+
+func (w *countWindow) reset() {
+	w.next = 0
+	w.filled = 0
+	w.current = windowTotals{}
+}
+
+// timeWindow keeps one bucket per second for the configured duration
+type timeWindow struct {
+	buckets []timeBucket
+}
+
+type timeBucket struct {
+	second int64
+	windowTotals
+}
+
+func (w *timeWindow) record(now time.Time, failed, slow bool) {
+	second := now.Unix()
+	bucket := &w.buckets[second%int64(len(w.buckets))]
+	if bucket.second != second {
+		*bucket = timeBucket{second: second}
+	}
+
+	bucket.calls++
+	if failed {
+		bucket.failures++
+	}
+	if slow {
+		bucket.slow++
+	}
+}
+
+func (w *timeWindow) totals(now time.Time) windowTotals {
+	oldest := now.Unix() - int64(len(w.buckets)) + 1
+
+	var total windowTotals
+	for _, bucket := range w.buckets {
+		if bucket.second < oldest {
+			continue
+		}
+		total.calls += bucket.calls
+		total.failures += bucket.failures
+		total.slow += bucket.slow
+	}
+	return total
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = timeBucket{}
+	}
+}
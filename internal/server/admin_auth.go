@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/JustVugg/gonk/internal/auth"
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+type adminCredentialKey struct{}
+
+// adminIdentity is the credential an admin request authenticated with
+type adminIdentity struct {
+	name        string
+	permissions []string
+}
+
+func (id *adminIdentity) allows(permission string) bool {
+	for _, granted := range id.permissions {
+		if granted == config.AdminPermissionAll || granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// fullAdminAccess is what the shared token, and cert_roles without
+// credentials, have always granted
+var fullAdminAccess = []string{config.AdminPermissionAll}
+
+// authenticateAdmin returns the credential the request presents, or nil.
+// Tokens are checked first, then client certificates, then JWT roles; among
+// credentials of one kind, the first configured match wins.
+func (s *Server) authenticateAdmin(r *http.Request, current *config.Config) *adminIdentity {
+	cfg := current.Admin
+
+	if token := r.Header.Get(cfg.Header); token != "" {
+		if cfg.Token != "" && constantTimeEqual(token, cfg.Token) {
+			return &adminIdentity{name: "token", permissions: fullAdminAccess}
+		}
+
+		sum := sha256.Sum256([]byte(token))
+		digest := hex.EncodeToString(sum[:])
+		for _, credential := range cfg.Credentials {
+			if credential.TokenSHA256 != "" && subtle.ConstantTimeCompare([]byte(digest), []byte(strings.ToLower(credential.TokenSHA256))) == 1 {
+				return &adminIdentity{name: credential.Name, permissions: credential.Permissions}
+			}
+		}
+	}
+
+	var roles []string
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		for _, credential := range cfg.Credentials {
+			if credential.CertCN != "" && auth.CertRole(cert, map[string]string{credential.CertCN: credential.Name}) != "" {
+				return &adminIdentity{name: credential.Name, permissions: credential.Permissions}
+			}
+		}
+
+		if role := auth.CertRole(cert, cfg.CertRoles); role != "" {
+			if len(cfg.Credentials) == 0 {
+				return &adminIdentity{name: "cert:" + cert.Subject.CommonName, permissions: fullAdminAccess}
+			}
+			roles = append(roles, role)
+		}
+	}
+
+	if jwtCfg := current.Auth.JWT; jwtCfg != nil && jwtCfg.Enabled && hasRoleCredentials(cfg.Credentials) {
+		if authCtx, err := auth.ValidateJWT(r, jwtCfg); err == nil {
+			roles = append(roles, authCtx.Roles...)
+		}
+	}
+
+	for _, credential := range cfg.Credentials {
+		if credential.Role == "" {
+			continue
+		}
+		for _, role := range roles {
+			if role == credential.Role {
+				return &adminIdentity{name: credential.Name, permissions: credential.Permissions}
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasRoleCredentials(credentials []config.AdminCredential) bool {
+	for _, credential := range credentials {
+		if credential.Role != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func withAdminCredential(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminCredentialKey{}, name))
+}
+
+// adminCredentialName names the caller in audit records
+func adminCredentialName(r *http.Request) string {
+	if name, ok := r.Context().Value(adminCredentialKey{}).(string); ok {
+		return name
+	}
+	return "anonymous"
+}
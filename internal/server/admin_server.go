@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/middleware"
+)
+
+// adminSocketMode lets the gateway's user and group reach the admin socket
+const adminSocketMode fs.FileMode = 0660
+
+type unixSocketKey struct{}
+
+// separateAdmin reports whether the admin API runs on its own server
+func (s *Server) separateAdmin() bool {
+	return s.config.Admin.Server != nil && s.config.Admin.Server.Enabled
+}
+
+// newAdminRouter gives the admin server a router of its own, so no data
+// plane route can shadow an admin endpoint
+func newAdminRouter() *mux.Router {
+	router := newRouter()
+	router.Use(middleware.RequestID)
+	router.Use(middleware.Recovery)
+	router.Use(middleware.Logging)
+	return router
+}
+
+func (s *Server) setupAdminServer() {
+	if !s.separateAdmin() {
+		return
+	}
+	cfg := s.config.Admin.Server
+
+	s.adminServer = &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      s.serveAdminAPI(),
+		ReadTimeout:  s.config.Server.ReadTimeout,
+		WriteTimeout: s.config.Server.WriteTimeout,
+		IdleTimeout:  s.config.Server.IdleTimeout,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				conn = tlsConn.NetConn()
+			}
+			if _, ok := conn.(*net.UnixConn); ok {
+				return context.WithValue(ctx, unixSocketKey{}, true)
+			}
+			return ctx
+		},
+	}
+
+	if cfg.TLS != nil && cfg.TLS.Enabled {
+		tlsConfig, err := s.configureTLS(cfg.TLS)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS for the admin server: %v", err)
+		}
+		s.adminServer.TLSConfig = tlsConfig
+	}
+}
+
+// overUnixSocket reports whether the request came through the admin socket,
+// whose file permissions stand in for the CIDR allowlist
+func overUnixSocket(r *http.Request) bool {
+	unix, _ := r.Context().Value(unixSocketKey{}).(bool)
+	return unix
+}
+
+// bindAdmin opens the admin server's socket, or takes the one passed on by
+// an upgrade
+func (s *Server) bindAdmin() (net.Listener, error) {
+	listen := s.config.Admin.Server.Listen
+
+	ln, err := s.bind(adminSocketKey, listen, func() (net.Listener, error) {
+		path, ok := unixSocketPath(listen)
+		if !ok {
+			return net.Listen("tcp", listen)
+		}
+
+		// A socket left behind by a crashed process would block the bind
+		if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		unixListener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, adminSocketMode); err != nil {
+			unixListener.Close()
+			return nil, err
+		}
+		return unixListener, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("admin server: %w", err)
+	}
+	return ln, nil
+}
+
+func (s *Server) serveAdmin(listener net.Listener) error {
+	cfg := s.config.Admin.Server
+
+	log.Printf("🛠️  Admin API listening on %s", cfg.Listen)
+
+	if cfg.TLS != nil && cfg.TLS.Enabled {
+		return s.adminServer.ServeTLS(listener, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	}
+	return s.adminServer.Serve(listener)
+}
+
+// unixSocketPath accepts unix:///abs/path and unix:rel/path
+func unixSocketPath(listen string) (string, bool) {
+	path, ok := strings.CutPrefix(listen, "unix:")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(path, "//"), true
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/resilience"
+)
+
+type circuitBreakerOverrideRequest struct {
+	Upstream string `json:"upstream,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type routeCircuitBreakerStatus struct {
+	Route          string             `json:"route"`
+	Scope          string             `json:"scope"` // "route" or "upstream"
+	CircuitBreaker *resilience.Stats  `json:"circuit_breaker"`
+	Upstreams      []resilience.Stats `json:"upstreams,omitempty"`
+}
+
+func (s *Server) circuitBreakersHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	breakers := make([]routeCircuitBreakerStatus, 0, len(s.config.Routes))
+	for _, route := range s.config.Routes {
+		if status := s.routeCircuitBreakerStatus(route.Name); status != nil {
+			breakers = append(breakers, *status)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"circuit_breakers": breakers,
+	})
+}
+
+func (s *Server) routeCircuitBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := mux.Vars(r)["route"]
+	status := s.routeCircuitBreakerStatus(name)
+	if status == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("route %s has no circuit breaker", name))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// circuitBreakerOverrideHandler forces a route's breakers open or closed, or
+// resets them. Load-balanced routes apply the action to every upstream breaker
+// unless the request names one upstream.
+func (s *Server) circuitBreakerOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vars := mux.Vars(r)
+	name, action := vars["route"], vars["action"]
+
+	var req circuitBreakerOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var until time.Time
+	if req.Duration != "" {
+		if action == "reset" {
+			writeJSONError(w, http.StatusBadRequest, "duration is not supported for reset")
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "duration must be a positive duration such as 30m")
+			return
+		}
+		until = time.Now().Add(duration)
+	}
+
+	breakers, status, err := s.routeCircuitBreakers(name, req.Upstream)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	for _, breaker := range breakers {
+		switch action {
+		case "open":
+			err = breaker.ForceState(resilience.StateOpen, until, req.Reason)
+		case "close":
+			err = breaker.ForceState(resilience.StateClosed, until, req.Reason)
+		case "reset":
+			breaker.Reset()
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	upstream := req.Upstream
+	if upstream == "" {
+		upstream = "all"
+	}
+	expires := "never"
+	if !until.IsZero() {
+		expires = until.UTC().Format(time.RFC3339)
+	}
+	clientIP := clientip.FromRequest(r)
+	log.Printf(
+		"audit action=circuit_breaker_%s route=%s upstream=%s until=%s reason=%q credential=%s client_ip=%s",
+		action,
+		name,
+		upstream,
+		expires,
+		req.Reason,
+		adminCredentialName(r),
+		clientIP,
+	)
+
+	writeJSON(w, http.StatusOK, s.routeCircuitBreakerStatus(name))
+}
+
+// routeCircuitBreakers returns the breakers an override applies to, with the
+// HTTP status to use when the route or upstream cannot be resolved.
+// Callers must hold s.mu.
+func (s *Server) routeCircuitBreakers(name, upstream string) ([]*resilience.CircuitBreaker, int, error) {
+	if !s.circuitBreakerEnabled(name) {
+		return nil, http.StatusNotFound, fmt.Errorf("route %s has no circuit breaker", name)
+	}
+
+	proxyHandler := s.proxyHandler(name)
+	if proxyHandler != nil && proxyHandler.UpstreamCircuitBreakers() {
+		breakers := proxyHandler.CircuitBreakers(upstream)
+		if len(breakers) == 0 {
+			return nil, http.StatusNotFound, fmt.Errorf("route %s has no upstream %s", name, upstream)
+		}
+		return breakers, http.StatusOK, nil
+	}
+
+	breaker := s.cbManager.Get(name)
+	if breaker == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("route %s has no circuit breaker", name)
+	}
+	if upstream != "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("route %s has a single route-wide circuit breaker", name)
+	}
+	return []*resilience.CircuitBreaker{breaker}, http.StatusOK, nil
+}
+
+// routeCircuitBreakerStatus returns nil when the route has no circuit breaker.
+// Callers must hold s.mu.
+func (s *Server) routeCircuitBreakerStatus(name string) *routeCircuitBreakerStatus {
+	if !s.circuitBreakerEnabled(name) {
+		return nil
+	}
+
+	if proxyHandler := s.proxyHandler(name); proxyHandler != nil && proxyHandler.UpstreamCircuitBreakers() {
+		upstreams := make([]resilience.Stats, 0)
+		for _, breaker := range proxyHandler.CircuitBreakers("") {
+			upstreams = append(upstreams, breaker.Stats())
+		}
+		aggregate := resilience.AggregateStats(name, upstreams)
+		return &routeCircuitBreakerStatus{
+			Route:          name,
+			Scope:          "upstream",
+			CircuitBreaker: &aggregate,
+			Upstreams:      upstreams,
+		}
+	}
+
+	stats := s.cbManager.RouteStats(name)
+	if stats == nil {
+		return nil
+	}
+	return &routeCircuitBreakerStatus{
+		Route:          name,
+		Scope:          "route",
+		CircuitBreaker: stats,
+	}
+}
+
+// circuitBreakerEnabled reports whether the current config enables a circuit
+// breaker for the route. Breakers of removed routes may outlive a reload in
+// the manager, so the config is the source of truth. Callers must hold s.mu.
+func (s *Server) circuitBreakerEnabled(name string) bool {
+	for _, route := range s.config.Routes {
+		if route.Name == name {
+			return route.CircuitBreaker != nil && route.CircuitBreaker.Enabled
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func (s *Server) registerConfigHistoryEndpoints(admin *mux.Router) {
+	admin.Handle("/_gonk/config/history", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.configHistoryHandler))).Methods("GET").Name("gonk-config-history")
+	// Entries hold the whole config file, secrets included
+	admin.Handle("/_gonk/config/history/{version:[0-9]+}", s.adminMiddleware(config.AdminPermissionReload, http.HandlerFunc(s.configHistoryEntryHandler))).Methods("GET").Name("gonk-config-history-entry")
+	admin.Handle("/_gonk/config/history/{version:[0-9]+}/rollback", s.adminMiddleware(config.AdminPermissionReload, http.HandlerFunc(s.configRollbackHandler))).Methods("POST").Name("gonk-config-rollback")
+}
+
+// newHistory opens the history of the config file at configPath, if cfg
+// enables it
+func newHistory(cfg *config.Config, configPath string) *config.History {
+	settings := cfg.Server.History
+	if settings == nil || !settings.Enabled || configPath == "" {
+		return nil
+	}
+	return config.NewHistory(config.HistoryDir(configPath, settings), settings.MaxEntries)
+}
+
+// recordConfig adds cfg to history and returns its version. Without a
+// history, versions count up from current, the running version. Recording
+// writes and syncs a file, so callers must not hold s.mu, which request
+// handlers wait on.
+func recordConfig(history *config.History, cfg *config.Config, source string, current uint64) uint64 {
+	if history == nil || cfg.Source() == nil {
+		return current + 1
+	}
+
+	entry, err := history.Record(source, cfg.Source())
+	if err != nil {
+		log.Printf("⚠️  Failed to record config history: %v", err)
+		return current + 1
+	}
+	// Versions must not go back, or an old If-Match could match again
+	if entry.Version <= current {
+		return current + 1
+	}
+	return entry.Version
+}
+
+func (s *Server) configHistory() *config.History {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.history
+}
+
+func (s *Server) configHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	history := s.configHistory()
+	if history == nil {
+		writeJSONError(w, http.StatusNotFound, "config history is not enabled")
+		return
+	}
+
+	entries, err := history.Entries()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeConfigJSON(w, http.StatusOK, "history", entries)
+}
+
+func (s *Server) configHistoryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	history := s.configHistory()
+	if history == nil {
+		writeJSONError(w, http.StatusNotFound, "config history is not enabled")
+		return
+	}
+
+	version, _ := strconv.ParseUint(mux.Vars(r)["version"], 10, 64)
+	entry, err := history.Entry(version)
+	if errors.Is(err, config.ErrNoHistory) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// configRollbackHandler writes an earlier config back to the config file and
+// reloads it. The rollback is itself recorded as a new version. If-Match is
+// optional here; when sent, it must name the running version.
+func (s *Server) configRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+
+	history := s.configHistory()
+	if history == nil {
+		writeJSONError(w, http.StatusNotFound, "config history is not enabled")
+		return
+	}
+
+	current := s.ConfigVersion()
+	if r.Header.Get("If-Match") != "" {
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if version != current {
+			w.Header().Set("ETag", versionETag(current))
+			writeJSONError(w, http.StatusPreconditionFailed, fmt.Sprintf("config version is %d, not %d", current, version))
+			return
+		}
+	}
+
+	target, _ := strconv.ParseUint(mux.Vars(r)["version"], 10, 64)
+	entry, err := history.Entry(target)
+	if errors.Is(err, config.ErrNoHistory) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status, err := s.applyConfigFile([]byte(entry.Content), ReloadSourceRollback)
+	var notSetUp *routesNotSetUpError
+	if err != nil && !errors.As(err, &notSetUp) {
+		writeJSONError(w, status, fmt.Sprintf("config version %d no longer applies: %v", target, err))
+		return
+	}
+
+	log.Printf(
+		"audit action=config_rollback to_version=%d config_version=%d credential=%s client_ip=%s",
+		target,
+		s.ConfigVersion(),
+		adminCredentialName(r),
+		clientip.FromRequest(r),
+	)
+	if notSetUp != nil {
+		s.writeRoutesNotSetUp(w, notSetUp)
+		return
+	}
+	s.writeConfigJSON(w, http.StatusOK, "rolled_back_to", target)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/proxyproto"
+)
+
+// listener is one configured address and the http.Server behind it. All
+// listeners share the router; routes bound to listeners match only there.
+// The server's handler is fixed; it serves with whatever table is current.
+type listener struct {
+	cfg    config.ListenerConfig
+	server *http.Server
+}
+
+type listenerKey struct{}
+
+func (s *Server) setupListeners() {
+	for _, cfg := range s.config.Server.EffectiveListeners() {
+		// h2c sits outside the table, so each stream of a long-lived
+		// HTTP/2 connection is served by the table current at the time
+		handler := s.serveListener(cfg.Name)
+		if cfg.HTTP2 {
+			handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+
+		l := &listener{cfg: cfg}
+		l.server = &http.Server{
+			Addr:         cfg.Listen,
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
+
+		// Configure TLS if enabled
+		if cfg.TLS != nil && cfg.TLS.Enabled {
+			tlsConfig, err := s.configureTLS(cfg.TLS)
+			if err != nil {
+				log.Fatalf("Failed to configure TLS for listener %s: %v", cfg.Name, err)
+			}
+			l.server.TLSConfig = tlsConfig
+		}
+
+		s.listeners = append(s.listeners, l)
+	}
+}
+
+// onListeners matches requests that arrived on one of the named listeners
+func onListeners(names []string) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		current, _ := r.Context().Value(listenerKey{}).(string)
+		for _, name := range names {
+			if name == current {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// serve serves the listener's TCP socket until shutdown. PROXY protocol
+// headers are read below TLS, so client certificates are verified on the
+// relayed connection.
+func (l *listener) serve(netListener net.Listener) error {
+	if pp := l.cfg.ProxyProtocol; pp != nil && pp.Enabled {
+		ppListener, err := proxyproto.NewListener(netListener, pp)
+		if err != nil {
+			netListener.Close()
+			return err
+		}
+		log.Printf("📡 PROXY protocol enabled on listener %s", l.cfg.Name)
+		netListener = ppListener
+	}
+
+	if tls := l.cfg.TLS; tls != nil && tls.Enabled {
+		return l.server.ServeTLS(netListener, tls.CertFile, tls.KeyFile)
+	}
+	return l.server.Serve(netListener)
+}
+
+func listenerConfigs(listeners []*listener) []config.ListenerConfig {
+	configs := make([]config.ListenerConfig, len(listeners))
+	for i, l := range listeners {
+		configs[i] = l.cfg
+	}
+	return configs
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/middleware"
+)
+
+// defaultTopConsumers is the number of clients listed per route unless the
+// request asks for another number with ?top=
+const defaultTopConsumers = 20
+
+type rateLimitResetRequest struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type routeRateLimitStatus struct {
+	Route     string                         `json:"route"`
+	Scope     string                         `json:"scope"` // "route" or "global"
+	RateLimit *config.RateLimitConfig        `json:"rate_limit"`
+	Tracked   int                            `json:"tracked_consumers"`
+	Consumers []middleware.RateLimitConsumer `json:"consumers"`
+}
+
+func (s *Server) rateLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	top, ok := topConsumers(w, r)
+	if !ok {
+		return
+	}
+
+	// The limiters are read under s.mu and queried after, as the store may
+	// be a network round trip away
+	type scopedLimiter struct {
+		limiter *middleware.RateLimiter
+		scope   string
+	}
+	s.mu.RLock()
+	names := make([]string, 0, len(s.routes))
+	limiters := make(map[string]scopedLimiter, len(s.routes))
+	for name := range s.routes {
+		if limiter, scope := s.rateLimiter(name); limiter != nil {
+			names = append(names, name)
+			limiters[name] = scopedLimiter{limiter, scope}
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	limits := make([]routeRateLimitStatus, 0, len(names))
+	for _, name := range names {
+		limits = append(limits, rateLimitStatus(r, limiters[name].limiter, limiters[name].scope, top))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rate_limits": limits,
+	})
+}
+
+// routeRateLimitHandler lists a route's top consumers, or only the client
+// given with ?key=
+func (s *Server) routeRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	top, ok := topConsumers(w, r)
+	if !ok {
+		return
+	}
+
+	name := mux.Vars(r)["route"]
+	s.mu.RLock()
+	limiter, scope := s.rateLimiter(name)
+	s.mu.RUnlock()
+	if limiter == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("route %s has no rate limit", name))
+		return
+	}
+
+	status := rateLimitStatus(r, limiter, scope, top)
+	if key := r.URL.Query().Get("key"); key != "" {
+		status.Consumers = []middleware.RateLimitConsumer{limiter.Consumer(r.Context(), key)}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// rateLimitResetHandler clears one client's buckets on a route. Routes that
+// share the global limit share its buckets, so the reset applies to all of them.
+func (s *Server) rateLimitResetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["route"]
+	s.mu.RLock()
+	limiter, _ := s.rateLimiter(name)
+	s.mu.RUnlock()
+	if limiter == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("route %s has no rate limit", name))
+		return
+	}
+
+	var req rateLimitResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, "request body must name the client key to reset")
+		return
+	}
+
+	if err := limiter.Reset(r.Context(), req.Key); err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to reset rate limit: %v", err))
+		return
+	}
+
+	clientIP := clientip.FromRequest(r)
+	log.Printf(
+		"audit action=rate_limit_reset route=%s key=%q reason=%q credential=%s client_ip=%s",
+		name,
+		req.Key,
+		req.Reason,
+		adminCredentialName(r),
+		clientIP,
+	)
+
+	writeJSON(w, http.StatusOK, limiter.Consumer(r.Context(), req.Key))
+}
+
+// rateLimitStatus reports a route's limits and top consumers
+func rateLimitStatus(r *http.Request, limiter *middleware.RateLimiter, scope string, top int) routeRateLimitStatus {
+	return routeRateLimitStatus{
+		Route:     limiter.Name(),
+		Scope:     scope,
+		RateLimit: limiter.Config(),
+		Tracked:   limiter.TrackedConsumers(),
+		Consumers: limiter.TopConsumers(r.Context(), top),
+	}
+}
+
+func topConsumers(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("top")
+	if value == "" {
+		return defaultTopConsumers, true
+	}
+
+	top, err := strconv.Atoi(value)
+	if err != nil || top < 1 {
+		writeJSONError(w, http.StatusBadRequest, "top must be a positive integer")
+		return 0, false
+	}
+	return top, true
+}
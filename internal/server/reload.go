@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// Outcomes of a reload, as reported on /_gonk/status
+const (
+	ReloadStatusOK        = "ok"
+	ReloadStatusUnchanged = "unchanged"
+	ReloadStatusFailed    = "failed"
+)
+
+// reloadStatus describes the last reload attempt
+type reloadStatus struct {
+	Status        string    `json:"status"`
+	Source        string    `json:"source"`
+	Time          time.Time `json:"time"`
+	Error         string    `json:"error,omitempty"`
+	ConfigVersion uint64    `json:"config_version"`
+	routeChanges
+	// FailedRoutes are in the config but could not be set up, and serve 404
+	FailedRoutes []string `json:"failed_routes,omitempty"`
+}
+
+// routeChanges names the routes a new config adds, changes or removes
+type routeChanges struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+func diffRoutes(from, to *config.Config) routeChanges {
+	changes := routeChanges{Added: []string{}, Changed: []string{}, Removed: []string{}}
+
+	previous := make(map[string]config.Route, len(from.Routes))
+	for _, route := range from.Routes {
+		previous[route.Name] = route
+	}
+	for _, route := range to.Routes {
+		old, ok := previous[route.Name]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, route.Name)
+		case !reflect.DeepEqual(old, route):
+			changes.Changed = append(changes.Changed, route.Name)
+		}
+		delete(previous, route.Name)
+	}
+	for _, route := range from.Routes {
+		if _, removed := previous[route.Name]; removed {
+			changes.Removed = append(changes.Removed, route.Name)
+		}
+	}
+	return changes
+}
+
+func (s *Server) registerReloadEndpoints(admin *mux.Router) {
+	admin.Handle("/_gonk/reload", s.adminMiddleware(config.AdminPermissionReload, http.HandlerFunc(s.reloadHandler))).Methods("POST").Name("gonk-reload")
+}
+
+// ReloadFile loads the config file again and applies it. A file that does
+// not load leaves the running config in place, and is reported as the last
+// reload.
+func (s *Server) ReloadFile(source string) error {
+	_, err := s.reloadFile(source)
+	return err
+}
+
+func (s *Server) reloadFile(source string) (*reloadStatus, error) {
+	s.mu.RLock()
+	path := s.configPath
+	s.mu.RUnlock()
+	if path == "" {
+		return nil, fmt.Errorf("the config was not loaded from a file")
+	}
+
+	newConfig, err := config.Load(path)
+	if err != nil {
+		return s.reloadFailed(source, err), err
+	}
+	return s.reload(newConfig, source), nil
+}
+
+// ReloadFailed records a reload whose config did not load, such as a file
+// the watcher could not parse
+func (s *Server) ReloadFailed(source string, err error) {
+	s.reloadFailed(source, err)
+}
+
+func (s *Server) reloadFailed(source string, err error) *reloadStatus {
+	log.Printf("❌ Reload failed, keeping the running configuration: %v", err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastReload = &reloadStatus{
+		Status:        ReloadStatusFailed,
+		Source:        source,
+		Time:          time.Now().UTC(),
+		Error:         err.Error(),
+		ConfigVersion: s.configVersion,
+	}
+	return s.lastReload
+}
+
+// reloadHandler reloads the config file. With ?dry_run=true it only loads
+// and validates the file, and reports the route changes it would make.
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	s.mu.RLock()
+	path := s.configPath
+	s.mu.RUnlock()
+	if path == "" {
+		writeJSONError(w, http.StatusNotImplemented, "the config was not loaded from a file")
+		return
+	}
+
+	if dryRun {
+		newConfig, err := config.Load(path)
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		changes := diffRoutes(s.currentConfig(), newConfig)
+		log.Printf(
+			"audit action=config_reload dry_run=true credential=%s client_ip=%s",
+			adminCredentialName(r),
+			clientip.FromRequest(r),
+		)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"dry_run":        true,
+			"config_version": s.ConfigVersion(),
+			"added":          changes.Added,
+			"changed":        changes.Changed,
+			"removed":        changes.Removed,
+		})
+		return
+	}
+
+	status, err := s.reloadFile(ReloadSourceAdminAPI)
+	log.Printf(
+		"audit action=config_reload dry_run=false status=%s config_version=%d credential=%s client_ip=%s",
+		status.Status,
+		status.ConfigVersion,
+		adminCredentialName(r),
+		clientip.FromRequest(r),
+	)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
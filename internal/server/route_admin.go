@@ -0,0 +1,475 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// maxRouteBody bounds route and upstream documents sent to the admin API
+const maxRouteBody = 1 << 20
+
+// routeEdit changes the routes of the config file. It returns the document
+// to report, or the HTTP status and error to fail with.
+type routeEdit func(file *config.RoutesFile) (*yaml.Node, int, error)
+
+func (s *Server) registerRouteAdminEndpoints(admin *mux.Router) {
+	// Reads need routes:write too: routes as written hold their API keys,
+	// JWT secrets and identity signing keys
+	write := func(handler http.HandlerFunc) http.Handler {
+		return s.adminMiddleware(config.AdminPermissionRoutesWrite, handler)
+	}
+
+	admin.Handle("/_gonk/config/routes", write(s.configRoutesHandler)).Methods("GET").Name("gonk-config-routes")
+	admin.Handle("/_gonk/config/routes", write(s.createRouteHandler)).Methods("POST").Name("gonk-config-route-create")
+	admin.Handle("/_gonk/config/routes/{route}", write(s.configRouteHandler)).Methods("GET").Name("gonk-config-route")
+	admin.Handle("/_gonk/config/routes/{route}", write(s.updateRouteHandler)).Methods("PUT").Name("gonk-config-route-update")
+	admin.Handle("/_gonk/config/routes/{route}", write(s.deleteRouteHandler)).Methods("DELETE").Name("gonk-config-route-delete")
+	admin.Handle("/_gonk/config/routes/{route}/upstreams", write(s.configUpstreamsHandler)).Methods("GET").Name("gonk-config-upstreams")
+	admin.Handle("/_gonk/config/routes/{route}/upstreams", write(s.createUpstreamHandler)).Methods("POST").Name("gonk-config-upstream-create")
+	admin.Handle("/_gonk/config/routes/{route}/upstreams", write(s.updateUpstreamHandler)).Methods("PUT").Name("gonk-config-upstream-update")
+	admin.Handle("/_gonk/config/routes/{route}/upstreams", write(s.deleteUpstreamHandler)).Methods("DELETE").Name("gonk-config-upstream-delete")
+}
+
+// configRoutesHandler lists routes as the config file has them, with ${VAR}
+// references unexpanded and without defaults
+func (s *Server) configRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+
+	file, status, err := s.openRoutesFile()
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	routes := make([]interface{}, 0, len(file.Routes()))
+	for _, route := range file.Routes() {
+		value, err := nodeValue(route)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		routes = append(routes, value)
+	}
+
+	s.writeConfigJSON(w, http.StatusOK, "routes", routes)
+}
+
+func (s *Server) configRouteHandler(w http.ResponseWriter, r *http.Request) {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+
+	file, status, err := s.openRoutesFile()
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	name := mux.Vars(r)["route"]
+	route := file.Route(name)
+	if route == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("route %s not found", name))
+		return
+	}
+
+	value, err := nodeValue(route)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeConfigJSON(w, http.StatusOK, "route", value)
+}
+
+func (s *Server) configUpstreamsHandler(w http.ResponseWriter, r *http.Request) {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+
+	file, status, err := s.openRoutesFile()
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	name := mux.Vars(r)["route"]
+	route := file.Route(name)
+	if route == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("route %s not found", name))
+		return
+	}
+
+	value, err := nodeValue(config.RouteNodeUpstreams(route))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeConfigJSON(w, http.StatusOK, "upstreams", value)
+}
+
+func (s *Server) createRouteHandler(w http.ResponseWriter, r *http.Request) {
+	route, err := decodeNode(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := config.RouteNodeName(route)
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "route name is required")
+		return
+	}
+
+	s.editRoutes(w, r, http.StatusCreated, "route_create", "route="+name, func(file *config.RoutesFile) (*yaml.Node, int, error) {
+		if file.Route(name) != nil {
+			return nil, http.StatusConflict, fmt.Errorf("route %s already exists", name)
+		}
+		file.PutRoute(route)
+		return route, http.StatusOK, nil
+	})
+}
+
+// updateRouteHandler replaces a route. Renaming is not supported, since
+// route state such as circuit breakers and caches is kept by name.
+func (s *Server) updateRouteHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["route"]
+	route, err := decodeNode(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if bodyName := config.RouteNodeName(route); bodyName != "" && bodyName != name {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("route %s cannot be renamed to %s; delete it and create the new route", name, bodyName))
+		return
+	}
+	config.SetRouteNodeName(route, name)
+
+	s.editRoutes(w, r, http.StatusOK, "route_update", "route="+name, func(file *config.RoutesFile) (*yaml.Node, int, error) {
+		if file.Route(name) == nil {
+			return nil, http.StatusNotFound, fmt.Errorf("route %s not found", name)
+		}
+		file.PutRoute(route)
+		return route, http.StatusOK, nil
+	})
+}
+
+func (s *Server) deleteRouteHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["route"]
+
+	s.editRoutes(w, r, http.StatusOK, "route_delete", "route="+name, func(file *config.RoutesFile) (*yaml.Node, int, error) {
+		if !file.DeleteRoute(name) {
+			return nil, http.StatusNotFound, fmt.Errorf("route %s not found", name)
+		}
+		return nil, http.StatusOK, nil
+	})
+}
+
+func (s *Server) createUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["route"]
+	upstream, err := decodeNode(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	url := config.UpstreamNodeURL(upstream)
+	if url == "" {
+		writeJSONError(w, http.StatusBadRequest, "upstream url is required")
+		return
+	}
+
+	s.editRoutes(w, r, http.StatusCreated, "upstream_create", fmt.Sprintf("route=%s upstream=%s", name, url), func(file *config.RoutesFile) (*yaml.Node, int, error) {
+		upstreams, status, err := routeUpstreams(file, name)
+		if err != nil {
+			return nil, status, err
+		}
+		if upstreamIndex(upstreams, url) >= 0 {
+			return nil, http.StatusConflict, fmt.Errorf("route %s already has upstream %s", name, url)
+		}
+		upstreams.Content = append(upstreams.Content, upstream)
+		return upstream, http.StatusOK, nil
+	})
+}
+
+// updateUpstreamHandler replaces the upstream named by the url query
+// parameter
+func (s *Server) updateUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["route"]
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		writeJSONError(w, http.StatusBadRequest, "the url query parameter is required")
+		return
+	}
+	upstream, err := decodeNode(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if config.UpstreamNodeURL(upstream) == "" {
+		writeJSONError(w, http.StatusBadRequest, "upstream url is required")
+		return
+	}
+
+	s.editRoutes(w, r, http.StatusOK, "upstream_update", fmt.Sprintf("route=%s upstream=%s", name, url), func(file *config.RoutesFile) (*yaml.Node, int, error) {
+		upstreams, status, err := routeUpstreams(file, name)
+		if err != nil {
+			return nil, status, err
+		}
+		i := upstreamIndex(upstreams, url)
+		if i < 0 {
+			return nil, http.StatusNotFound, fmt.Errorf("route %s has no upstream %s", name, url)
+		}
+		if other := config.UpstreamNodeURL(upstream); other != url && upstreamIndex(upstreams, other) >= 0 {
+			return nil, http.StatusConflict, fmt.Errorf("route %s already has upstream %s", name, other)
+		}
+		upstreams.Content[i] = upstream
+		return upstream, http.StatusOK, nil
+	})
+}
+
+func (s *Server) deleteUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["route"]
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		writeJSONError(w, http.StatusBadRequest, "the url query parameter is required")
+		return
+	}
+
+	s.editRoutes(w, r, http.StatusOK, "upstream_delete", fmt.Sprintf("route=%s upstream=%s", name, url), func(file *config.RoutesFile) (*yaml.Node, int, error) {
+		upstreams, status, err := routeUpstreams(file, name)
+		if err != nil {
+			return nil, status, err
+		}
+		i := upstreamIndex(upstreams, url)
+		if i < 0 {
+			return nil, http.StatusNotFound, fmt.Errorf("route %s has no upstream %s", name, url)
+		}
+		upstreams.Content = append(upstreams.Content[:i], upstreams.Content[i+1:]...)
+		return nil, http.StatusOK, nil
+	})
+}
+
+// editRoutes applies an edit if the caller saw the current config version.
+// The edited file is validated like any config load, written atomically and
+// then reloaded, so the running config and the file stay in step.
+func (s *Server) editRoutes(w http.ResponseWriter, r *http.Request, successStatus int, action, subject string, edit routeEdit) {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+
+	current := s.ConfigVersion()
+	w.Header().Set("ETag", versionETag(current))
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeJSONError(w, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+	if version != current {
+		writeJSONError(w, http.StatusPreconditionFailed, fmt.Sprintf("config version is %d, not %d", current, version))
+		return
+	}
+
+	file, status, err := s.openRoutesFile()
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	result, status, err := edit(file)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	data, err := file.Marshal()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status, err = s.applyConfigFile(data, ReloadSourceAdminAPI)
+	var notSetUp *routesNotSetUpError
+	if err != nil && !errors.As(err, &notSetUp) {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	log.Printf(
+		"audit action=%s %s config_version=%d credential=%s client_ip=%s",
+		action,
+		subject,
+		s.ConfigVersion(),
+		adminCredentialName(r),
+		clientip.FromRequest(r),
+	)
+	if notSetUp != nil {
+		s.writeRoutesNotSetUp(w, notSetUp)
+		return
+	}
+
+	var value interface{}
+	if result != nil {
+		if value, err = nodeValue(result); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	key := "route"
+	if strings.HasPrefix(action, "upstream_") {
+		key = "upstream"
+	}
+	s.writeConfigJSON(w, successStatus, key, value)
+}
+
+// routesNotSetUpError names routes a config change added or changed that
+// could not be set up, such as a route whose service discovery failed. The
+// change itself is written and applied; the routes serve 404 until fixed.
+type routesNotSetUpError struct {
+	routes []string
+}
+
+func (e *routesNotSetUpError) Error() string {
+	return "routes not set up: " + strings.Join(e.routes, ", ")
+}
+
+// applyConfigFile validates data as the config file, writes it and reloads.
+// Callers must hold s.editMu.
+func (s *Server) applyConfigFile(data []byte, source string) (int, error) {
+	newConfig, err := config.Parse(s.configPath, data)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := config.WriteFileAtomic(s.configPath, data); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	status := s.reload(newConfig, source)
+	// Routes that already failed before are not this change's doing
+	edited := make(map[string]bool, len(status.Added)+len(status.Changed))
+	for _, name := range append(append([]string(nil), status.Added...), status.Changed...) {
+		edited[name] = true
+	}
+	var failed []string
+	for _, name := range status.FailedRoutes {
+		if edited[name] {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return http.StatusUnprocessableEntity, &routesNotSetUpError{routes: failed}
+	}
+	return http.StatusOK, nil
+}
+
+// writeRoutesNotSetUp reports a change that was applied with routes that
+// could not be set up, along with the version a fix must send in If-Match
+func (s *Server) writeRoutesNotSetUp(w http.ResponseWriter, err *routesNotSetUpError) {
+	version := s.ConfigVersion()
+	w.Header().Set("ETag", versionETag(version))
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"version":       version,
+		"error":         err.Error(),
+		"failed_routes": err.routes,
+	})
+}
+
+// openRoutesFile reads the config file. Callers must hold s.editMu.
+func (s *Server) openRoutesFile() (*config.RoutesFile, int, error) {
+	if s.configPath == "" {
+		return nil, http.StatusNotImplemented, errors.New("the gateway was not started from a config file")
+	}
+	file, err := config.OpenRoutesFile(s.configPath)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return file, http.StatusOK, nil
+}
+
+// writeConfigJSON writes value under key, along with the config version a
+// following edit must send in If-Match
+func (s *Server) writeConfigJSON(w http.ResponseWriter, status int, key string, value interface{}) {
+	version := s.ConfigVersion()
+	w.Header().Set("ETag", versionETag(version))
+
+	response := map[string]interface{}{"version": version}
+	if value != nil {
+		response[key] = value
+	}
+	writeJSON(w, status, response)
+}
+
+func routeUpstreams(file *config.RoutesFile, name string) (*yaml.Node, int, error) {
+	route := file.Route(name)
+	if route == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("route %s not found", name)
+	}
+	return config.RouteNodeUpstreams(route), http.StatusOK, nil
+}
+
+func upstreamIndex(upstreams *yaml.Node, url string) int {
+	for i, upstream := range upstreams.Content {
+		if config.UpstreamNodeURL(upstream) == url {
+			return i
+		}
+	}
+	return -1
+}
+
+func versionETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// ifMatchVersion reads the config version from If-Match, quoted or not
+func ifMatchVersion(r *http.Request) (uint64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" {
+		return 0, errors.New("If-Match with the config version is required")
+	}
+	version, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(value, "W/"), `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("If-Match must be a config version, got %s", value)
+	}
+	return version, nil
+}
+
+// decodeNode reads a JSON or YAML object from the request body. Styles are
+// reset, so JSON input is written to a YAML file in block style.
+func decodeNode(r *http.Request) (*yaml.Node, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRouteBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("request body must be an object")
+	}
+
+	resetStyle(doc.Content[0])
+	return doc.Content[0], nil
+}
+
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// nodeValue converts a YAML node into a value encoding/json can write
+func nodeValue(node *yaml.Node) (interface{}, error) {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+
+	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/metrics"
+	"github.com/JustVugg/gonk/internal/middleware"
+	"github.com/JustVugg/gonk/internal/proxy"
+	"github.com/JustVugg/gonk/internal/resilience"
+)
+
+const drainPollInterval = 50 * time.Millisecond
+
+// routeTable is everything built from one config that serves requests.
+// Reload builds a new table next to the running one and swaps it in, so a
+// request is served either by the old table or by the new one, never by a
+// half-built router.
+type routeTable struct {
+	config      *config.Config
+	router      *mux.Router
+	adminRouter *mux.Router
+	routes      map[string]*routeState
+	rateStore   middleware.RateLimitStore
+	listeners   map[string]http.Handler // by listener name
+	admin       http.Handler
+	inFlight    atomic.Int64
+}
+
+// routeState is the part of a route that is carried over by a reload when
+// the route itself does not change: the proxy with its load balancer, health
+// checks and connection counts, and the route's limiters.
+type routeState struct {
+	route       config.Route
+	proxy       *proxy.Handler
+	bulkhead    *resilience.Bulkhead
+	limiter     *resilience.AdaptiveLimiter
+	rateLimit   *config.RateLimitConfig // effective: the route's, or the global one
+	rateScope   string                  // "route" or "global", as for rateLimit
+	rateLimiter *middleware.RateLimiter
+}
+
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	router.SkipClean(true)
+	return router
+}
+
+// buildTable builds the router and route chains for cfg. Routes unchanged
+// since previous keep their state.
+func (s *Server) buildTable(cfg *config.Config, rateStore middleware.RateLimitStore, previous *routeTable) *routeTable {
+	t := &routeTable{
+		config:    cfg,
+		router:    newRouter(),
+		routes:    make(map[string]*routeState),
+		rateStore: rateStore,
+		listeners: make(map[string]http.Handler),
+	}
+	// The admin server is started or stopped only by a restart
+	if s.adminServer != nil {
+		t.adminRouter = newAdminRouter()
+	}
+
+	t.setupMiddleware()
+	// Internal endpoints first, so a catch-all route cannot shadow them
+	s.setupInternalEndpoints(t)
+	for _, route := range cfg.Routes {
+		s.addRoute(t, route, previous)
+	}
+
+	for _, l := range s.listeners {
+		t.listeners[l.cfg.Name] = t.listenerHandler(l.cfg)
+	}
+	if t.adminRouter != nil {
+		t.admin = resolveClientIP(cfg, t.adminRouter)
+	}
+	return t
+}
+
+func (t *routeTable) setupMiddleware() {
+	t.router.Use(middleware.RequestID)
+	t.router.Use(middleware.Recovery)
+	t.router.Use(middleware.Logging)
+
+	if t.config.Metrics.Enabled {
+		t.router.Use(metrics.Middleware)
+	}
+}
+
+// listenerHandler tags requests with the listener they arrived on
+func (t *routeTable) listenerHandler(cfg config.ListenerConfig) http.Handler {
+	handler := http.Handler(t.router)
+
+	if t.config.Server.CORS != nil && t.config.Server.CORS.Enabled {
+		c := cors.New(cors.Options{
+			AllowedOrigins: t.config.Server.CORS.AllowedOrigins,
+			AllowedMethods: t.config.Server.CORS.AllowedMethods,
+			AllowedHeaders: t.config.Server.CORS.AllowedHeaders,
+			MaxAge:         t.config.Server.CORS.MaxAge,
+		})
+		handler = c.Handler(handler)
+	}
+
+	// Outermost, so everything below sees the resolved client IP
+	handler = resolveClientIP(t.config, handler)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), listenerKey{}, cfg.Name)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// routerFor returns the router to register on: the shared one, or a
+// subrouter that only matches on the given listeners
+func (t *routeTable) routerFor(listeners []string) *mux.Router {
+	if len(listeners) == 0 {
+		return t.router
+	}
+	return t.router.MatcherFunc(onListeners(listeners)).Subrouter()
+}
+
+// reusedState returns the state of an unchanged route in previous, copied so
+// the running table is left as it is
+func reusedState(previous *routeTable, route config.Route) *routeState {
+	if previous == nil {
+		return nil
+	}
+	state := previous.routes[route.Name]
+	if state == nil || !reflect.DeepEqual(state.route, route) {
+		return nil
+	}
+	kept := *state
+	return &kept
+}
+
+// serveListener serves the named listener with the current table. Requests
+// are counted against the table that serves them, so a replaced table knows
+// when it is drained.
+func (s *Server) serveListener(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := s.table.Load()
+		t.inFlight.Add(1)
+		defer t.inFlight.Add(-1)
+		t.listeners[name].ServeHTTP(w, r)
+	})
+}
+
+// serveAdminAPI serves the admin server with the current table
+func (s *Server) serveAdminAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := s.table.Load()
+		t.inFlight.Add(1)
+		defer t.inFlight.Add(-1)
+		t.admin.ServeHTTP(w, r)
+	})
+}
+
+// retire closes the proxies and rate limit store only old used, once the
+// requests old is still serving have finished or drainTimeout has passed
+func retire(old, current *routeTable, drainTimeout time.Duration) {
+	kept := make(map[*proxy.Handler]bool, len(current.routes))
+	for _, state := range current.routes {
+		kept[state.proxy] = true
+	}
+	retired := make(map[string]*proxy.Handler)
+	for name, state := range old.routes {
+		if !kept[state.proxy] {
+			retired[name] = state.proxy
+		}
+	}
+	var rateStore middleware.RateLimitStore
+	if old.rateStore != current.rateStore {
+		rateStore = old.rateStore
+	}
+	if len(retired) == 0 && rateStore == nil {
+		return
+	}
+
+	go func() {
+		if !old.drain(drainTimeout) {
+			log.Printf("⚠️  %d request(s) still running on the previous configuration after %s, closing its proxies", old.inFlight.Load(), drainTimeout)
+		}
+		closeProxyHandlers(retired)
+		if rateStore != nil {
+			rateStore.Close()
+		}
+	}()
+}
+
+// drain waits until no request is running on the table. It reports false if
+// timeout passed first.
+func (t *routeTable) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		// Wait before the first check too: a request may have loaded the
+		// table just before it was replaced, and not be counted yet
+		time.Sleep(drainPollInterval)
+		if t.inFlight.Load() == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
@@ -12,17 +12,18 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
 
 	"github.com/JustVugg/gonk/internal/auth"
 	"github.com/JustVugg/gonk/internal/cache"
+	"github.com/JustVugg/gonk/internal/clientip"
 	"github.com/JustVugg/gonk/internal/config"
 	"github.com/JustVugg/gonk/internal/health"
 	"github.com/JustVugg/gonk/internal/metrics"
@@ -33,27 +34,49 @@ import (
 
 type Server struct {
 	config        *config.Config
-	router        *mux.Router
-	httpServer    *http.Server
+	table         atomic.Pointer[routeTable] // serves requests; swapped by Reload
+	adminServer   *http.Server
+	listeners     []*listener
 	healthMonitor *health.Monitor
 	cacheManager  *cache.Manager
 	cbManager     *resilience.CircuitBreakerManager
-	proxyHandlers map[string]*proxy.Handler
+	routes        map[string]*routeState // the table's routes, for the admin API
+	configPath    string
+	configVersion uint64
+	history       *config.History
+	lastReload    *reloadStatus
+	inherited     map[string]boundSocket // passed on by an upgrade, until bound
+	bound         map[string]boundSocket // passed on by the next upgrade
+	upgradeReady  *os.File               // reports to the process that started this one
+	handoff       chan struct{}          // closed once an upgraded process serves
+	editMu        sync.Mutex             // serializes route edits through the admin API
+	reloadMu      sync.Mutex             // serializes reloads, which build and record history outside mu
+	upgradeMu     sync.Mutex
 	mu            sync.RWMutex
 }
 
 type routeInfo struct {
-	Name           string         `json:"name"`
-	Path           string         `json:"path"`
-	Methods        []string       `json:"methods"`
-	Protocol       string         `json:"protocol"`
-	StripPath      bool           `json:"strip_path"`
-	Upstreams      []upstreamInfo `json:"upstreams"`
-	LoadBalancing  string         `json:"load_balancing,omitempty"`
-	Auth           routeAuthInfo  `json:"auth"`
-	RateLimit      bool           `json:"rate_limit"`
-	CircuitBreaker bool           `json:"circuit_breaker"`
-	Cache          bool           `json:"cache"`
+	Name           string                `json:"name"`
+	Path           string                `json:"path"`
+	Methods        []string              `json:"methods"`
+	Protocol       string                `json:"protocol"`
+	StripPath      bool                  `json:"strip_path"`
+	Rewrite        *config.RewriteConfig `json:"rewrite,omitempty"`
+	Listeners      []string              `json:"listeners,omitempty"`
+	Upstreams      []upstreamInfo        `json:"upstreams"`
+	LoadBalancing  string                `json:"load_balancing,omitempty"`
+	Auth           routeAuthInfo         `json:"auth"`
+	RateLimit      bool                  `json:"rate_limit"`
+	CircuitBreaker bool                  `json:"circuit_breaker"`
+	Concurrency    bool                  `json:"concurrency"`
+	Cache          bool                  `json:"cache"`
+}
+
+type concurrencyStatus struct {
+	*resilience.BulkheadStats
+	MaxPerUpstream   int                       `json:"max_per_upstream,omitempty"`
+	UpstreamRejected int64                     `json:"upstream_rejected"`
+	Adaptive         *resilience.AdaptiveStats `json:"adaptive,omitempty"`
 }
 
 type upstreamInfo struct {
@@ -72,36 +95,27 @@ type routeAuthInfo struct {
 func New(cfg *config.Config) *Server {
 	s := &Server{
 		config:        cfg,
-		router:        mux.NewRouter(),
 		healthMonitor: health.NewMonitor(),
 		cacheManager:  cache.NewManager(),
 		cbManager:     resilience.NewCircuitBreakerManager(),
-		proxyHandlers: make(map[string]*proxy.Handler),
+		configVersion: 1,
+		bound:         make(map[string]boundSocket),
+		handoff:       make(chan struct{}),
 	}
+	s.inheritFromEnv()
 
-	s.setupRouter()
-	s.setupMiddleware()
-	s.setupRoutes()
-	s.setupInternalEndpoints()
-
-	handler := s.buildHandler()
-
-	s.httpServer = &http.Server{
-		Addr:         cfg.Server.Listen,
-		Handler:      handler,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  cfg.Server.IdleTimeout,
+	rateStore, err := middleware.NewRateLimitStore(cfg.RateLimitStore)
+	if err != nil {
+		log.Fatalf("Failed to create rate limit store: %v", err)
 	}
 
-	// Configure TLS if enabled
-	if cfg.Server.TLS != nil && cfg.Server.TLS.Enabled {
-		tlsConfig, err := s.configureTLS(cfg.Server.TLS)
-		if err != nil {
-			log.Fatalf("Failed to configure TLS: %v", err)
-		}
-		s.httpServer.TLSConfig = tlsConfig
-	}
+	s.setupListeners()
+	s.setupAdminServer()
+
+	s.healthMonitor.SetReadiness(cfg.Health.Readiness)
+	t := s.buildTable(cfg, rateStore, nil)
+	s.routes = t.routes
+	s.table.Store(t)
 
 	return s
 }
@@ -147,104 +161,144 @@ func (s *Server) configureTLS(tlsCfg *config.TLSConfig) (*tls.Config, error) {
 	return cfg, nil
 }
 
-func (s *Server) setupRouter() {
-	s.router.StrictSlash(true)
-	s.router.SkipClean(true)
-}
-
-func (s *Server) buildHandler() http.Handler {
-	handler := http.Handler(s.router)
-
-	if s.config.Server.HTTP2 {
-		h2s := &http2.Server{}
-		handler = h2c.NewHandler(handler, h2s)
-	}
-
-	if s.config.Server.CORS != nil && s.config.Server.CORS.Enabled {
-		c := cors.New(cors.Options{
-			AllowedOrigins: s.config.Server.CORS.AllowedOrigins,
-			AllowedMethods: s.config.Server.CORS.AllowedMethods,
-			AllowedHeaders: s.config.Server.CORS.AllowedHeaders,
-			MaxAge:         s.config.Server.CORS.MaxAge,
-		})
-		handler = c.Handler(handler)
-	}
-
-	return handler
-}
-
-func (s *Server) setupMiddleware() {
-	s.router.Use(middleware.RequestID)
-	s.router.Use(middleware.Recovery)
-	s.router.Use(middleware.Logging)
-
-	if s.config.Metrics.Enabled {
-		s.router.Use(metrics.Middleware)
-	}
-}
-
-func (s *Server) setupRoutes() {
-	for _, route := range s.config.Routes {
-		s.addRoute(route)
+func resolveClientIP(cfg *config.Config, handler http.Handler) http.Handler {
+	resolver, err := clientip.NewResolver(cfg.Server.TrustedProxies, cfg.Server.ClientIPHeader)
+	if err != nil {
+		log.Printf("❌ Ignoring trusted proxies, client IPs are the TCP peers: %v", err)
+		return handler
 	}
+	return resolver.Middleware(handler)
 }
 
-func (s *Server) addRoute(route config.Route) {
+// addRoute builds the route's middleware chain on t. The chain is rebuilt
+// on every reload; the proxy and limiters come from previous when the route
+// did not change.
+func (s *Server) addRoute(t *routeTable, route config.Route, previous *routeTable) {
 	log.Printf("📧 Adding route: %s [%s] -> %d upstream(s)", route.Name, route.Path, len(route.Upstreams))
 
-	// Register upstreams with health monitor
-	for _, upstream := range route.Upstreams {
-		s.healthMonitor.RegisterUpstream(route.Name, upstream.URL)
+	var identity *auth.IdentityPropagator
+	if route.Identity != nil && route.Identity.Enabled {
+		var err error
+		if identity, err = auth.NewIdentityPropagator(route.Identity); err != nil {
+			log.Printf("❌ Failed to set up identity propagation for route %s: %v", route.Name, err)
+			return
+		}
 	}
 
-	proxyHandler, err := proxy.NewHandler(&route)
-	if err != nil {
-		log.Printf("❌ Failed to create proxy for route %s: %v", route.Name, err)
-		return
+	state := reusedState(previous, route)
+	if state == nil {
+		var err error
+		if state, err = s.newRouteState(route); err != nil {
+			log.Printf("❌ Failed to create proxy for route %s: %v", route.Name, err)
+			return
+		}
 	}
-	s.proxyHandlers[route.Name] = proxyHandler
+	t.routes[route.Name] = state
 
-	handler := http.Handler(proxyHandler)
+	handler := http.Handler(state.proxy)
 
 	// Apply middleware in order (innermost first)
 	if route.Transform != nil {
 		handler = middleware.Transform(route.Transform, handler)
 	}
 
+	// Runs after auth has set the context, but outside transforms so a
+	// transform can still add its own headers
+	if identity != nil {
+		handler = identity.Middleware(handler)
+	}
+
 	if route.Cache != nil && route.Cache.Enabled {
 		routeCache := s.cacheManager.GetOrCreate(route.Name, route.Cache)
 		handler = routeCache.Middleware(handler)
 	}
 
-	if route.CircuitBreaker != nil && route.CircuitBreaker.Enabled {
+	if route.CircuitBreaker != nil && route.CircuitBreaker.Enabled && !state.proxy.UpstreamCircuitBreakers() {
 		cb := s.cbManager.GetOrCreate(route.Name, route.CircuitBreaker)
 		handler = cb.Middleware(handler)
 	}
 
-	if route.RateLimit != nil && route.RateLimit.Enabled {
-		handler = middleware.RateLimit(route.RateLimit, handler)
-	} else if s.config.RateLimit != nil && s.config.RateLimit.Enabled {
-		handler = middleware.RateLimit(s.config.RateLimit, handler)
+	// Concurrency limits sit outside the circuit breaker so load shedding is
+	// not recorded as upstream failures. The adaptive limit applies to calls
+	// that already got a static slot.
+	if state.limiter != nil {
+		handler = state.limiter.Middleware(handler)
+	}
+	if state.bulkhead != nil {
+		handler = state.bulkhead.Middleware(handler)
 	}
 
-	if s.config.Audit.Enabled {
+	rateLimit, rateScope := route.RateLimit, "route"
+	if rateLimit == nil || !rateLimit.Enabled {
+		rateLimit, rateScope = t.config.RateLimit, "global"
+	}
+	if rateLimit != nil && rateLimit.Enabled {
+		// Consumers seen so far are kept while the limit and its store are
+		// unchanged. Only a state reused from previous has a rate limiter.
+		kept := previous != nil && state.rateLimiter != nil &&
+			previous.rateStore == t.rateStore && reflect.DeepEqual(state.rateLimit, rateLimit)
+		if !kept {
+			state.rateLimiter = middleware.NewRateLimiter(route.Name, rateLimit, t.rateStore)
+		}
+		state.rateLimit, state.rateScope = rateLimit, rateScope
+		handler = state.rateLimiter.Middleware(handler)
+	} else {
+		state.rateLimit, state.rateScope, state.rateLimiter = nil, "", nil
+	}
+
+	if t.config.Audit.Enabled {
 		handler = middleware.Audit(route.Name, handler)
 	}
 
 	// Authentication and authorization middleware (outermost)
 	if route.Auth != nil && route.Auth.Type != "none" {
-		handler = auth.Middleware(&s.config.Auth, route.Auth, handler)
+		handler = auth.Middleware(&t.config.Auth, route.Auth, handler)
 	}
 
-	s.registerRoute(route, handler)
+	t.registerRoute(route, handler)
 }
 
-func (s *Server) registerRoute(route config.Route, handler http.Handler) {
+// newRouteState creates the proxy and limiters of a new or changed route,
+// and registers its upstreams with the health monitor
+func (s *Server) newRouteState(route config.Route) (*routeState, error) {
+	for _, upstream := range route.Upstreams {
+		s.healthMonitor.RegisterUpstream(route.Name, upstream.URL)
+	}
+
+	proxyHandler, err := proxy.NewHandler(&route)
+	if err != nil {
+		for _, upstream := range route.Upstreams {
+			s.healthMonitor.SetUpstreamStatus(route.Name, upstream.URL, false)
+		}
+		return nil, err
+	}
+	proxyHandler.OnUpstreamHealthChange(func(upstream string, healthy bool) {
+		s.healthMonitor.SetUpstreamStatus(route.Name, upstream, healthy)
+	})
+	proxyHandler.OnUpstreamsChange(func(upstreams []string) {
+		s.healthMonitor.SetRouteUpstreams(route.Name, upstreams)
+	})
+	s.healthMonitor.SetRouteUpstreams(route.Name, proxyHandler.UpstreamURLs())
+
+	state := &routeState{route: route, proxy: proxyHandler}
+	if cc := route.Concurrency; cc != nil && cc.Enabled {
+		if cc.Adaptive != nil && cc.Adaptive.Enabled {
+			state.limiter = resilience.NewAdaptiveLimiter(route.Name, cc)
+		}
+		if cc.MaxInFlight > 0 {
+			state.bulkhead = resilience.NewBulkhead(route.Name, cc)
+		}
+	}
+	return state, nil
+}
+
+func (t *routeTable) registerRoute(route config.Route, handler http.Handler) {
 	path := route.Path
+	router := t.routerFor(route.Listeners)
 
 	if strings.HasSuffix(path, "/*") {
 		pathPrefix := strings.TrimSuffix(path, "*")
-		r := s.router.PathPrefix(pathPrefix).Handler(handler)
+		r := router.PathPrefix(pathPrefix).Handler(handler)
 		r.Name(route.Name)
 
 		if len(route.Methods) > 0 {
@@ -254,7 +308,7 @@ func (s *Server) registerRoute(route config.Route, handler http.Handler) {
 		log.Printf("✅ Registered PathPrefix: %s (methods: %v)", pathPrefix, route.Methods)
 
 	} else if strings.HasSuffix(path, "/") {
-		r := s.router.PathPrefix(path).Handler(handler)
+		r := router.PathPrefix(path).Handler(handler)
 		r.Name(route.Name)
 
 		if len(route.Methods) > 0 {
@@ -264,7 +318,7 @@ func (s *Server) registerRoute(route config.Route, handler http.Handler) {
 		log.Printf("✅ Registered PathPrefix: %s (methods: %v)", path, route.Methods)
 
 	} else {
-		r := s.router.Handle(path, handler)
+		r := router.Handle(path, handler)
 		r.Name(route.Name)
 
 		if len(route.Methods) > 0 {
@@ -272,7 +326,7 @@ func (s *Server) registerRoute(route config.Route, handler http.Handler) {
 		}
 
 		if !strings.HasSuffix(path, "/") {
-			r2 := s.router.Handle(path+"/", handler)
+			r2 := router.Handle(path+"/", handler)
 			r2.Name(route.Name + "-slash")
 			if len(route.Methods) > 0 {
 				r2.Methods(route.Methods...)
@@ -284,38 +338,83 @@ func (s *Server) registerRoute(route config.Route, handler http.Handler) {
 	}
 }
 
-func (s *Server) setupInternalEndpoints() {
-	s.router.HandleFunc("/_gonk/health", s.healthMonitor.HealthHandler).Methods("GET").Name("gonk-health")
-	s.router.HandleFunc("/_gonk/live", s.healthMonitor.LivenessHandler).Methods("GET").Name("gonk-live")
-	s.router.HandleFunc("/_gonk/ready", s.healthMonitor.ReadinessHandler).Methods("GET").Name("gonk-ready")
-	s.router.Handle("/_gonk/info", s.adminMiddleware(http.HandlerFunc(s.infoHandler))).Methods("GET").Name("gonk-info")
-	s.router.Handle("/_gonk/routes", s.adminMiddleware(http.HandlerFunc(s.routesHandler))).Methods("GET").Name("gonk-routes")
-	s.router.Handle("/_gonk/status", s.adminMiddleware(http.HandlerFunc(s.statusHandler))).Methods("GET").Name("gonk-status")
+func (s *Server) setupInternalEndpoints(t *routeTable) {
+	s.registerHealthEndpoints(t.router)
+
+	// Admin endpoints can be limited to dedicated listeners, or moved to
+	// the admin server
+	admin := t.routerFor(t.config.Admin.Listeners)
+	if t.adminRouter != nil {
+		admin = t.adminRouter
+		s.registerHealthEndpoints(admin)
+	}
+
+	admin.Handle("/_gonk/info", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.infoHandler))).Methods("GET").Name("gonk-info")
+	admin.Handle("/_gonk/routes", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.routesHandler))).Methods("GET").Name("gonk-routes")
+	admin.Handle("/_gonk/status", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.statusHandler))).Methods("GET").Name("gonk-status")
 
-	if s.config.Metrics.Enabled {
-		s.router.Handle(s.config.Metrics.Path, s.adminMiddleware(metrics.Handler())).Methods("GET").Name("gonk-metrics")
-		log.Printf("✅ Metrics endpoint enabled: %s", s.config.Metrics.Path)
+	if t.config.Metrics.Enabled {
+		admin.Handle(t.config.Metrics.Path, s.adminMiddleware(config.AdminPermissionRead, metrics.Handler())).Methods("GET").Name("gonk-metrics")
+		log.Printf("✅ Metrics endpoint enabled: %s", t.config.Metrics.Path)
 	}
 
-	s.router.Handle("/_gonk/cache/clear", s.adminMiddleware(http.HandlerFunc(s.clearCacheHandler))).Methods("POST").Name("gonk-cache-clear")
-	s.router.Handle("/_gonk/cache/stats", s.adminMiddleware(http.HandlerFunc(s.cacheStatsHandler))).Methods("GET").Name("gonk-cache-stats")
+	admin.Handle("/_gonk/cache/clear", s.adminMiddleware(config.AdminPermissionCacheWrite, http.HandlerFunc(s.clearCacheHandler))).Methods("POST").Name("gonk-cache-clear")
+	admin.Handle("/_gonk/cache/stats", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.cacheStatsHandler))).Methods("GET").Name("gonk-cache-stats")
+
+	admin.Handle("/_gonk/circuit-breakers", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.circuitBreakersHandler))).Methods("GET").Name("gonk-circuit-breakers")
+	admin.Handle("/_gonk/circuit-breakers/{route}", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.routeCircuitBreakerHandler))).Methods("GET").Name("gonk-circuit-breaker")
+	admin.Handle("/_gonk/circuit-breakers/{route}/{action:open|close|reset}", s.adminMiddleware(config.AdminPermissionBreakerWrite, http.HandlerFunc(s.circuitBreakerOverrideHandler))).Methods("POST").Name("gonk-circuit-breaker-override")
+
+	admin.Handle("/_gonk/ratelimit", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.rateLimitsHandler))).Methods("GET").Name("gonk-ratelimit")
+	admin.Handle("/_gonk/ratelimit/{route}", s.adminMiddleware(config.AdminPermissionRead, http.HandlerFunc(s.routeRateLimitHandler))).Methods("GET").Name("gonk-ratelimit-route")
+	admin.Handle("/_gonk/ratelimit/{route}/reset", s.adminMiddleware(config.AdminPermissionRateLimitWrite, http.HandlerFunc(s.rateLimitResetHandler))).Methods("POST").Name("gonk-ratelimit-reset")
+
+	s.registerRouteAdminEndpoints(admin)
+	s.registerConfigHistoryEndpoints(admin)
+	s.registerReloadEndpoints(admin)
+	s.registerUpgradeEndpoints(admin)
 
 	log.Printf("✅ Internal endpoints registered")
 }
 
-func (s *Server) adminMiddleware(next http.Handler) http.Handler {
+func (s *Server) registerHealthEndpoints(router *mux.Router) {
+	router.HandleFunc("/_gonk/health", s.healthMonitor.HealthHandler).Methods("GET").Name("gonk-health")
+	router.HandleFunc("/_gonk/live", s.healthMonitor.LivenessHandler).Methods("GET").Name("gonk-live")
+	router.HandleFunc("/_gonk/ready", s.healthMonitor.ReadinessHandler).Methods("GET").Name("gonk-ready")
+}
+
+// adminMiddleware lets through callers holding the permission. Audit
+// records of the handler name the credential.
+func (s *Server) adminMiddleware(permission string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg := s.config.Admin
+		current := s.currentConfig()
+		cfg := current.Admin
 
 		if !s.adminClientAllowed(r, cfg) {
 			writeJSONError(w, http.StatusForbidden, "admin client is not allowed")
 			return
 		}
 
-		if cfg.RequireAuth && !constantTimeEqual(r.Header.Get(cfg.Header), cfg.Token) {
-			w.Header().Set("WWW-Authenticate", cfg.Header)
-			writeJSONError(w, http.StatusUnauthorized, "admin token required")
-			return
+		if cfg.RequireAuth {
+			identity := s.authenticateAdmin(r, current)
+			if identity == nil {
+				w.Header().Set("WWW-Authenticate", cfg.Header)
+				writeJSONError(w, http.StatusUnauthorized, "admin credentials required")
+				return
+			}
+			if !identity.allows(permission) {
+				log.Printf(
+					"audit action=admin_denied credential=%s permission=%s method=%s path=%s client_ip=%s",
+					identity.name,
+					permission,
+					r.Method,
+					r.URL.Path,
+					clientip.FromRequest(r),
+				)
+				writeJSONError(w, http.StatusForbidden, fmt.Sprintf("admin credential %s lacks the %s permission", identity.name, permission))
+				return
+			}
+			r = withAdminCredential(r, identity.name)
 		}
 
 		next.ServeHTTP(w, r)
@@ -323,16 +422,11 @@ func (s *Server) adminMiddleware(next http.Handler) http.Handler {
 }
 
 func (s *Server) adminClientAllowed(r *http.Request, cfg config.AdminConfig) bool {
-	if len(cfg.AllowedCIDRs) == 0 {
+	if len(cfg.AllowedCIDRs) == 0 || overUnixSocket(r) {
 		return true
 	}
 
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		host = r.RemoteAddr
-	}
-
-	clientIP := net.ParseIP(host)
+	clientIP := net.ParseIP(clientip.FromRequest(r))
 	if clientIP == nil {
 		return false
 	}
@@ -360,18 +454,38 @@ func constantTimeEqual(got, want string) bool {
 	return len(got) == len(want) && subtle.ConstantTimeCompare(gotHash[:], wantHash[:]) == 1
 }
 
+// currentConfig returns the running config. A reload replaces it rather
+// than changing it, so the result can be read without holding s.mu.
+func (s *Server) currentConfig() *config.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
 func (s *Server) infoHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := s.currentConfig()
+
+	var listeners []string
+	mtls := false
+	for _, listener := range cfg.Server.EffectiveListeners() {
+		listeners = append(listeners, listener.Name)
+		if listener.TLS != nil && listener.TLS.ClientCA != "" {
+			mtls = true
+		}
+	}
+
 	info := map[string]interface{}{
 		"name":            "GONK",
 		"version":         "1.1.0",
-		"routes":          len(s.config.Routes),
-		"admin_protected": s.config.Admin.RequireAuth || len(s.config.Admin.AllowedCIDRs) > 0,
+		"routes":          len(cfg.Routes),
+		"listeners":       listeners,
+		"admin_protected": cfg.Admin.RequireAuth || len(cfg.Admin.AllowedCIDRs) > 0,
 		"features": map[string]bool{
-			"metrics":         s.config.Metrics.Enabled,
-			"rate_limiting":   s.config.RateLimit != nil && s.config.RateLimit.Enabled,
-			"authentication":  s.config.Auth.JWT != nil || s.config.Auth.APIKey != nil,
+			"metrics":         cfg.Metrics.Enabled,
+			"rate_limiting":   cfg.RateLimit != nil && cfg.RateLimit.Enabled,
+			"authentication":  cfg.Auth.JWT != nil || cfg.Auth.APIKey != nil,
 			"authorization":   true,
-			"mtls":            s.config.Server.TLS != nil && s.config.Server.TLS.ClientCA != "",
+			"mtls":            mtls,
 			"load_balancing":  true,
 			"caching":         true,
 			"circuit_breaker": true,
@@ -399,16 +513,24 @@ func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	breakers := s.cbManager.Stats()
 	routes := make([]map[string]interface{}, 0, len(s.config.Routes))
 	for _, route := range s.config.Routes {
 		routeStatus := map[string]interface{}{
 			"route":           buildRouteInfo(route),
 			"circuit_breaker": s.cbManager.RouteStats(route.Name),
 		}
-		if proxyHandler := s.proxyHandlers[route.Name]; proxyHandler != nil {
-			if lbStats := proxyHandler.LoadBalancerStats(); lbStats != nil {
+		if state := s.routes[route.Name]; state != nil {
+			if lbStats := state.proxy.LoadBalancerStats(); lbStats != nil {
 				routeStatus["load_balancer"] = lbStats
 			}
+			if cbStats := state.proxy.CircuitBreakerStats(); cbStats != nil {
+				routeStatus["circuit_breaker"] = cbStats
+				breakers[route.Name] = *cbStats
+			}
+		}
+		if concurrency := s.concurrencyStatus(route); concurrency != nil {
+			routeStatus["concurrency"] = concurrency
 		}
 		routes = append(routes, routeStatus)
 	}
@@ -419,13 +541,57 @@ func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 		"runtime":          s.config.Runtime.Environment,
 		"admin_protected":  s.config.Admin.RequireAuth || len(s.config.Admin.AllowedCIDRs) > 0,
 		"audit_enabled":    s.config.Audit.Enabled,
+		"config_version":   s.configVersion,
+		"last_reload":      s.lastReload,
 		"health":           s.healthMonitor.Stats(),
 		"cache":            s.cacheManager.Stats(),
-		"circuit_breakers": s.cbManager.Stats(),
+		"circuit_breakers": breakers,
 		"routes":           routes,
 	})
 }
 
+// concurrencyStatus combines the route bulkhead, the adaptive limiter and the
+// per-upstream cap. Callers must hold s.mu.
+func (s *Server) concurrencyStatus(route config.Route) *concurrencyStatus {
+	if route.Concurrency == nil || !route.Concurrency.Enabled {
+		return nil
+	}
+
+	status := &concurrencyStatus{MaxPerUpstream: route.Concurrency.MaxPerUpstream}
+	state := s.routes[route.Name]
+	if state == nil {
+		return status
+	}
+	if state.bulkhead != nil {
+		stats := state.bulkhead.Stats()
+		status.BulkheadStats = &stats
+	}
+	if state.limiter != nil {
+		stats := state.limiter.Stats()
+		status.Adaptive = &stats
+	}
+	status.UpstreamRejected = state.proxy.UpstreamLimitRejections()
+	return status
+}
+
+// proxyHandler returns the route's proxy, or nil if the route has none.
+// Callers must hold s.mu.
+func (s *Server) proxyHandler(name string) *proxy.Handler {
+	if state := s.routes[name]; state != nil {
+		return state.proxy
+	}
+	return nil
+}
+
+// rateLimiter returns the route's rate limiter and the scope of its limit,
+// or nil if the route has no rate limit. Callers must hold s.mu.
+func (s *Server) rateLimiter(name string) (*middleware.RateLimiter, string) {
+	if state := s.routes[name]; state != nil {
+		return state.rateLimiter, state.rateScope
+	}
+	return nil, ""
+}
+
 func buildRouteInfo(route config.Route) routeInfo {
 	upstreams := make([]upstreamInfo, 0, len(route.Upstreams))
 	for _, upstream := range route.Upstreams {
@@ -442,9 +608,12 @@ func buildRouteInfo(route config.Route) routeInfo {
 		Methods:        append([]string(nil), route.Methods...),
 		Protocol:       route.Protocol,
 		StripPath:      route.StripPath,
+		Rewrite:        route.Rewrite,
+		Listeners:      route.Listeners,
 		Upstreams:      upstreams,
 		RateLimit:      route.RateLimit != nil && route.RateLimit.Enabled,
 		CircuitBreaker: route.CircuitBreaker != nil && route.CircuitBreaker.Enabled,
+		Concurrency:    route.Concurrency != nil && route.Concurrency.Enabled,
 		Cache:          route.Cache != nil && route.Cache.Enabled,
 	}
 
@@ -465,6 +634,11 @@ func buildRouteInfo(route config.Route) routeInfo {
 
 func (s *Server) clearCacheHandler(w http.ResponseWriter, r *http.Request) {
 	s.cacheManager.ClearAll()
+	log.Printf(
+		"audit action=cache_clear credential=%s client_ip=%s",
+		adminCredentialName(r),
+		clientip.FromRequest(r),
+	)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"cache cleared"}`))
@@ -487,68 +661,260 @@ func writeJSONError(w http.ResponseWriter, status int, message string) {
 }
 
 func (s *Server) Start(ctx context.Context) error {
-	errChan := make(chan error, 1)
+	errChan := make(chan error, len(s.listeners)+1)
 
-	go func() {
-		log.Printf("🚀 GONK v1.1 listening on %s", s.config.Server.Listen)
+	log.Printf("📊 Available routes:")
 
-		if s.config.Server.TLS != nil && s.config.Server.TLS.Enabled {
-			log.Printf("🔒 TLS enabled")
-			if s.config.Server.TLS.ClientCA != "" {
-				log.Printf("🔐 mTLS enabled (client auth: %s)", s.config.Server.TLS.ClientAuth)
-			}
+	s.table.Load().router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err == nil {
+			methods, _ := route.GetMethods()
+			log.Printf("   %s %s", strings.Join(methods, ","), path)
 		}
+		return nil
+	})
 
-		log.Printf("📊 Available routes:")
+	// Bind every socket before serving, so a process started by an upgrade
+	// reports ready only once it can take all the traffic
+	sockets := make([]net.Listener, len(s.listeners))
+	for i, l := range s.listeners {
+		address := l.cfg.Listen
+		ln, err := s.bind(listenerSocketKey(l.cfg.Name), address, func() (net.Listener, error) {
+			return net.Listen("tcp", address)
+		})
+		if err != nil {
+			s.closeBound()
+			return err
+		}
+		sockets[i] = ln
+	}
+	var adminSocket net.Listener
+	if s.adminServer != nil {
+		ln, err := s.bindAdmin()
+		if err != nil {
+			s.closeBound()
+			return err
+		}
+		adminSocket = ln
+	}
+	s.closeInherited()
+	s.notifyUpgradeReady()
+	notifySystemd("READY=1")
 
-		s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
-			path, err := route.GetPathTemplate()
-			if err == nil {
-				methods, _ := route.GetMethods()
-				log.Printf("   %s %s", strings.Join(methods, ","), path)
+	for i, l := range s.listeners {
+		go func(l *listener, ln net.Listener) {
+			log.Printf("🚀 GONK v1.1 listener %s on %s", l.cfg.Name, l.cfg.Listen)
+
+			if l.cfg.TLS != nil && l.cfg.TLS.Enabled {
+				log.Printf("🔒 TLS enabled on listener %s", l.cfg.Name)
+				if l.cfg.TLS.ClientCA != "" {
+					log.Printf("🔐 mTLS enabled on listener %s (client auth: %s)", l.cfg.Name, l.cfg.TLS.ClientAuth)
+				}
 			}
-			return nil
-		})
 
-		if s.config.Server.TLS != nil && s.config.Server.TLS.Enabled {
-			errChan <- s.httpServer.ListenAndServeTLS(s.config.Server.TLS.CertFile, s.config.Server.TLS.KeyFile)
-		} else {
-			errChan <- s.httpServer.ListenAndServe()
-		}
-	}()
+			errChan <- l.serve(ln)
+		}(l, sockets[i])
+	}
+	if s.adminServer != nil {
+		go func() {
+			errChan <- s.serveAdmin(adminSocket)
+		}()
+	}
 
+	var err error
+	handedOff := false
 	select {
-	case err := <-errChan:
-		return err
+	case err = <-errChan:
+		log.Println("Listener failed, shutting down server...")
 	case <-ctx.Done():
 		log.Println("Shutting down server...")
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		return s.httpServer.Shutdown(shutdownCtx)
+	case <-s.handoff:
+		log.Println("Draining connections for the upgraded process...")
+		handedOff = true
+	}
+
+	deadline := time.Now().Add(drainTimeout(s.currentConfig()))
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	defer func() { s.table.Load().rateStore.Close() }()
+	for _, l := range s.listeners {
+		if shutdownErr := l.server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if s.adminServer != nil {
+		if shutdownErr := s.adminServer.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	if handedOff {
+		s.waitForHijacked(deadline)
 	}
+	return err
 }
 
-func (s *Server) Reload(newConfig *config.Config) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Sources of a reload, recorded in the config history
+const (
+	ReloadSourceStartup   = "startup"
+	ReloadSourceFileWatch = "file_watch"
+	ReloadSourceSIGHUP    = "sighup"
+	ReloadSourceAdminAPI  = "admin_api"
+	ReloadSourceRollback  = "rollback"
+)
+
+// Reload applies newConfig without dropping requests. The new table is built
+// beside the running one and swapped in; routes that did not change keep
+// their proxy, load balancer and limiters. Proxies of removed or changed
+// routes are closed once the requests they are serving have finished.
+func (s *Server) Reload(newConfig *config.Config, source string) {
+	s.reload(newConfig, source)
+}
+
+// drainTimeout returns how long open requests get to finish, for configs
+// that were not filled in by the loader too
+func drainTimeout(cfg *config.Config) time.Duration {
+	if cfg.Server.DrainTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return cfg.Server.DrainTimeout
+}
+
+// reload applies newConfig and records the outcome as the last reload
+func (s *Server) reload(newConfig *config.Config, source string) *reloadStatus {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	oldConfig := s.currentConfig()
+	status := &reloadStatus{
+		Status:       ReloadStatusOK,
+		Source:       source,
+		Time:         time.Now().UTC(),
+		routeChanges: diffRoutes(oldConfig, newConfig),
+	}
+
+	// The watcher also sees files written by the admin API, which are
+	// already applied
+	if reflect.DeepEqual(oldConfig, newConfig) {
+		log.Println("Configuration unchanged, skipping reload")
+		status.Status = ReloadStatusUnchanged
+		s.mu.Lock()
+		status.ConfigVersion = s.configVersion
+		s.lastReload = status
+		s.mu.Unlock()
+		return status
+	}
 
 	log.Println("🔄 Reloading configuration...")
 
-	oldProxyHandlers := s.proxyHandlers
+	previous := s.table.Load()
+
+	// Keep the store, and with it every client's rate limit state, unless
+	// its configuration changed
+	rateStore := previous.rateStore
+	if !reflect.DeepEqual(oldConfig.RateLimitStore, newConfig.RateLimitStore) {
+		// The new store may restore quotas from the file the previous one
+		// persists to, which must not be written again after that
+		if memory, ok := previous.rateStore.(*middleware.MemoryRateLimitStore); ok {
+			if err := memory.Handoff(); err != nil {
+				log.Printf("Failed to persist rate limit quotas before replacing the store: %v", err)
+			}
+		}
+		if store, err := middleware.NewRateLimitStore(newConfig.RateLimitStore); err != nil {
+			log.Printf("❌ Failed to create rate limit store, keeping the previous one: %v", err)
+		} else {
+			rateStore = store
+		}
+	}
+
+	// Built without holding s.mu: creating a proxy can wait on service
+	// discovery, and the admin API keeps answering meanwhile
+	s.healthMonitor.SetReadiness(newConfig.Health.Readiness)
+	t := s.buildTable(newConfig, rateStore, previous)
+	for _, route := range newConfig.Routes {
+		if t.routes[route.Name] == nil {
+			status.FailedRoutes = append(status.FailedRoutes, route.Name)
+		}
+	}
+
+	// Recorded before taking s.mu; reloadMu keeps the version and path
+	// from changing meanwhile
+	s.mu.RLock()
+	configPath, running := s.configPath, s.configVersion
+	s.mu.RUnlock()
+	history := newHistory(newConfig, configPath)
+	version := recordConfig(history, newConfig, source, running)
+
+	s.mu.Lock()
 	s.config = newConfig
-	s.router = mux.NewRouter()
-	s.proxyHandlers = make(map[string]*proxy.Handler)
-	s.healthMonitor.ClearUpstreams()
+	s.routes = t.routes
+	s.table.Store(t)
+	s.history = history
+	s.configVersion = version
+	status.ConfigVersion = s.configVersion
+	s.lastReload = status
+	s.mu.Unlock()
+
+	// Removed routes no longer count for readiness
+	current := make(map[string]bool, len(newConfig.Routes))
+	for _, route := range newConfig.Routes {
+		current[route.Name] = true
+	}
+	for _, route := range oldConfig.Routes {
+		if !current[route.Name] {
+			s.healthMonitor.RemoveRoute(route.Name)
+		}
+	}
 
-	s.setupRouter()
-	s.setupMiddleware()
-	s.setupRoutes()
-	s.setupInternalEndpoints()
+	if !reflect.DeepEqual(newConfig.Server.EffectiveListeners(), listenerConfigs(s.listeners)) {
+		log.Println("⚠️  Listener changes take effect after a restart")
+	}
+	if s.separateAdmin() != (s.adminServer != nil) {
+		log.Println("⚠️  Starting or stopping the admin server takes effect after a restart")
+	}
+	retire(previous, t, drainTimeout(newConfig))
 
-	s.httpServer.Handler = s.buildHandler()
-	closeProxyHandlers(oldProxyHandlers)
+	log.Printf("✅ Configuration reloaded: %d route(s) added, %d changed, %d removed",
+		len(status.Added), len(status.Changed), len(status.Removed))
+	if len(status.FailedRoutes) > 0 {
+		log.Printf("⚠️  Routes not set up: %s", strings.Join(status.FailedRoutes, ", "))
+	}
+	return status
+}
 
-	log.Println("✅ Configuration reloaded successfully")
+// SetConfigPath names the file the config was loaded from. Route changes
+// through the admin API are written back to it, and the config history is
+// kept next to it.
+func (s *Server) SetConfigPath(path string) {
+	s.editMu.Lock()
+	defer s.editMu.Unlock()
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg := s.currentConfig()
+	history := newHistory(cfg, path)
+	var version uint64
+	if history != nil && cfg.Source() != nil {
+		if entry, err := history.Record(ReloadSourceStartup, cfg.Source()); err != nil {
+			log.Printf("⚠️  Failed to record config history: %v", err)
+		} else {
+			version = entry.Version
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configPath = path
+	s.history = history
+	if version != 0 {
+		s.configVersion = version
+	}
+}
+
+// ConfigVersion counts applied configurations, starting at 1
+func (s *Server) ConfigVersion() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.configVersion
 }
 
 func closeProxyHandlers(handlers map[string]*proxy.Handler) {
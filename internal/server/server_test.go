@@ -1,12 +1,31 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 
 	"github.com/JustVugg/gonk/internal/config"
+	"github.com/JustVugg/gonk/internal/middleware"
 )
 
 func TestAdminEndpointsRequireConfiguredToken(t *testing.T) {
@@ -34,7 +53,7 @@ func TestAdminEndpointsRequireConfiguredToken(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/_gonk/info", nil)
 	req.RemoteAddr = "127.0.0.1:12345"
 	rr := httptest.NewRecorder()
-	srv.httpServer.Handler.ServeHTTP(rr, req)
+	srv.listeners[0].server.Handler.ServeHTTP(rr, req)
 	if rr.Code != http.StatusUnauthorized {
 		t.Fatalf("status without token = %d, want %d", rr.Code, http.StatusUnauthorized)
 	}
@@ -43,7 +62,7 @@ func TestAdminEndpointsRequireConfiguredToken(t *testing.T) {
 	req.RemoteAddr = "127.0.0.1:12345"
 	req.Header.Set("X-Gonk-Admin-Token", "admin-secret")
 	rr = httptest.NewRecorder()
-	srv.httpServer.Handler.ServeHTTP(rr, req)
+	srv.listeners[0].server.Handler.ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
 		t.Fatalf("status with token = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
 	}
@@ -74,7 +93,7 @@ func TestRoutesEndpointReturnsConfiguredRoutes(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/_gonk/routes", nil)
 	req.RemoteAddr = "127.0.0.1:12345"
 	rr := httptest.NewRecorder()
-	srv.httpServer.Handler.ServeHTTP(rr, req)
+	srv.listeners[0].server.Handler.ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
 		t.Fatalf("status = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
 	}
@@ -117,7 +136,7 @@ func TestStatusEndpointReturnsOperationalState(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/_gonk/status", nil)
 	req.RemoteAddr = "127.0.0.1:12345"
 	rr := httptest.NewRecorder()
-	srv.httpServer.Handler.ServeHTTP(rr, req)
+	srv.listeners[0].server.Handler.ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
 		t.Fatalf("status = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
 	}
@@ -134,3 +153,1155 @@ func TestStatusEndpointReturnsOperationalState(t *testing.T) {
 		t.Fatalf("unexpected status response: %#v", response)
 	}
 }
+
+func TestCircuitBreakerOverrideEndpoints(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	srv := New(&config.Config{
+		Routes: []config.Route{
+			{
+				Name:      "plc",
+				Path:      "/plc/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL, Weight: 100}},
+				CircuitBreaker: &config.CircuitBreakerConfig{
+					Enabled:         true,
+					MaxFailures:     5,
+					ResetTimeout:    time.Hour,
+					HalfOpenMaxReqs: 1,
+				},
+			},
+		},
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/_gonk/circuit-breakers/plc/open", `{"duration":"30m","reason":"maintenance"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("open status = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if !strings.Contains(logs.String(), `audit action=circuit_breaker_open route=plc upstream=all`) ||
+		!strings.Contains(logs.String(), `reason="maintenance"`) {
+		t.Fatalf("audit log = %q, want circuit breaker override entry", logs.String())
+	}
+
+	if rr := serve(http.MethodGet, "/plc/coils", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("proxied status while forced open = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	rr = serve(http.MethodGet, "/_gonk/circuit-breakers/plc", "")
+	var status routeCircuitBreakerStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode circuit breaker status: %v", err)
+	}
+	if status.Scope != "route" || status.CircuitBreaker.Override == nil || status.CircuitBreaker.Override.Until == nil {
+		t.Fatalf("status = %+v, want route-wide breaker with an expiring override", status)
+	}
+
+	if rr := serve(http.MethodPost, "/_gonk/circuit-breakers/plc/reset", ""); rr.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if rr := serve(http.MethodGet, "/plc/coils", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("proxied status after reset = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+
+	if rr := serve(http.MethodPost, "/_gonk/circuit-breakers/plc/open", `{"upstream":"http://other:3000"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("upstream override on route-wide breaker = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve(http.MethodPost, "/_gonk/circuit-breakers/missing/open", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("override on unknown route = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestCircuitBreakerOpensOnUnreachableSingleUpstream(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	srv := New(&config.Config{
+		Routes: []config.Route{
+			{
+				Name:      "plc",
+				Path:      "/plc/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: deadURL, Weight: 100}},
+				CircuitBreaker: &config.CircuitBreakerConfig{
+					Enabled:            true,
+					MaxFailures:        2,
+					ResetTimeout:       time.Hour,
+					HalfOpenMaxReqs:    1,
+					FailureStatusCodes: []int{http.StatusServiceUnavailable},
+				},
+			},
+		},
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/plc/coils", nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The 502 written for a refused connection is not a configured failure
+	// status; the transport error behind it is what trips the breaker
+	for i := 0; i < 2; i++ {
+		if rr := serve(); rr.Code != http.StatusBadGateway {
+			t.Fatalf("request %d status = %d, want %d", i+1, rr.Code, http.StatusBadGateway)
+		}
+	}
+	if rr := serve(); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status after repeated connection failures = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestConcurrencyLimitRejectsWithRetryAfter(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-unblock
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	srv := New(&config.Config{
+		Routes: []config.Route{
+			{
+				Name:      "plc",
+				Path:      "/plc/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL, Weight: 100}},
+				Concurrency: &config.ConcurrencyConfig{
+					Enabled:     true,
+					MaxInFlight: 1,
+					RetryAfter:  5 * time.Second,
+				},
+			},
+		},
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- serve("/plc/slow").Code
+	}()
+	<-entered
+
+	rr := serve("/plc/fast")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status over the limit = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "5" {
+		t.Fatalf("Retry-After = %q, want 5", got)
+	}
+
+	close(unblock)
+	if code := <-done; code != http.StatusNoContent {
+		t.Fatalf("in-flight request status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	var status struct {
+		Routes []struct {
+			Concurrency struct {
+				InFlight int   `json:"in_flight"`
+				Rejected int64 `json:"rejected"`
+			} `json:"concurrency"`
+		} `json:"routes"`
+	}
+	if err := json.Unmarshal(serve("/_gonk/status").Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if len(status.Routes) != 1 || status.Routes[0].Concurrency.Rejected != 1 || status.Routes[0].Concurrency.InFlight != 0 {
+		t.Fatalf("concurrency status = %+v, want 1 rejection and nothing in flight", status.Routes)
+	}
+}
+
+func TestRateLimitEndpointsListAndResetConsumers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	srv := New(&config.Config{
+		Routes: []config.Route{
+			{
+				Name:      "plc",
+				Path:      "/plc/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL, Weight: 100}},
+				RateLimit: &config.RateLimitConfig{
+					Enabled:           true,
+					RequestsPerSecond: 1,
+					Burst:             2,
+					By:                "ip",
+					Algorithm:         "gcra",
+				},
+			},
+		},
+	})
+	defer srv.table.Load().rateStore.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		serve(http.MethodGet, "/plc/coils", "")
+	}
+
+	rr := serve(http.MethodGet, "/_gonk/ratelimit", "")
+	var listing struct {
+		RateLimits []routeRateLimitStatus `json:"rate_limits"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("failed to decode rate limit listing: %v, body = %s", err, rr.Body.String())
+	}
+	if len(listing.RateLimits) != 1 || len(listing.RateLimits[0].Consumers) != 1 {
+		t.Fatalf("listing = %+v, want one route with one consumer", listing)
+	}
+	consumer := listing.RateLimits[0].Consumers[0]
+	if consumer.Key != "ip=127.0.0.1" || consumer.Requests != 3 || consumer.Rejected != 1 {
+		t.Fatalf("consumer = %+v, want 3 requests from ip=127.0.0.1 with 1 rejected", consumer)
+	}
+	if len(consumer.Buckets) != 1 || consumer.Buckets[0].Remaining != 0 || consumer.Buckets[0].Limit != 2 {
+		t.Fatalf("buckets = %+v, want an empty bucket of 2", consumer.Buckets)
+	}
+
+	rr = serve(http.MethodPost, "/_gonk/ratelimit/plc/reset", `{"key":"ip=127.0.0.1","reason":"support ticket"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, want %d, body = %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if !strings.Contains(logs.String(), `audit action=rate_limit_reset route=plc key="ip=127.0.0.1"`) {
+		t.Fatalf("audit log = %q, want rate limit reset entry", logs.String())
+	}
+
+	rr = serve(http.MethodGet, "/plc/coils", "")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("after reset status = %d, RateLimit-Remaining = %q, want %d and 1",
+			rr.Code, rr.Header().Get("RateLimit-Remaining"), http.StatusNoContent)
+	}
+
+	if rr := serve(http.MethodPost, "/_gonk/ratelimit/missing/reset", `{"key":"ip=127.0.0.1"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("reset of unknown route status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestRateLimitEndpointKeepsGlobalScopeAcrossReload(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	configWith := func(routes ...string) *config.Config {
+		cfg := &config.Config{
+			RateLimit: &config.RateLimitConfig{
+				Enabled:           true,
+				RequestsPerSecond: 1,
+				Burst:             2,
+				By:                "ip",
+			},
+		}
+		for _, name := range routes {
+			cfg.Routes = append(cfg.Routes, config.Route{
+				Name: name, Path: "/" + name + "/*", Protocol: "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL, Weight: 100}},
+			})
+		}
+		return cfg
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := New(configWith("plc"))
+	defer srv.table.Load().rateStore.Close()
+	limiter := srv.table.Load().routes["plc"].rateLimiter
+
+	// The unchanged route keeps its limiter, built from the previous config's
+	// global limit
+	srv.Reload(configWith("plc", "historian"), ReloadSourceFileWatch)
+	if srv.table.Load().routes["plc"].rateLimiter != limiter {
+		t.Fatal("unchanged rate limit got a new limiter on reload")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/_gonk/ratelimit/plc", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	rr := httptest.NewRecorder()
+	srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+
+	var status routeRateLimitStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode rate limit status: %v, body = %s", err, rr.Body.String())
+	}
+	if status.Scope != "global" {
+		t.Fatalf("scope = %q after reload, want global", status.Scope)
+	}
+}
+
+func TestReloadHandsQuotaFileToNewRateLimitStore(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	configWith := func(cleanup time.Duration) *config.Config {
+		return &config.Config{
+			RateLimitStore: config.RateLimitStoreConfig{Type: "memory", PersistPath: path, CleanupInterval: cleanup},
+			RateLimit:      &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Burst: 5, By: "ip"},
+			Routes: []config.Route{
+				{Name: "plc", Path: "/plc/*", Protocol: "http", Upstreams: []config.Upstream{{URL: upstream.URL, Weight: 100}}},
+			},
+		}
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	serve := func(srv *Server) {
+		req := httptest.NewRequest(http.MethodGet, "/plc/coils", nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		srv.listeners[0].server.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	srv := New(configWith(time.Minute))
+	previous := srv.table.Load().rateStore
+	serve(srv)
+
+	srv.Reload(configWith(2*time.Minute), ReloadSourceFileWatch)
+	current := srv.table.Load().rateStore
+	if current == previous {
+		t.Fatal("changed rate_limit_store kept the previous store")
+	}
+	serve(srv)
+
+	// The previous store is closed when its table has drained, after the
+	// new store may already have written the file
+	current.Close()
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read quota file: %v", err)
+	}
+	previous.Close()
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, want) {
+		t.Fatalf("retired store overwrote the quota file: %s, want %s", got, want)
+	}
+}
+
+func TestRoutesAndAdminEndpointsBindToListeners(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	srv := New(&config.Config{
+		Server: config.ServerConfig{
+			Listeners: []config.ListenerConfig{
+				{Name: "devices", Listen: "127.0.0.1:0"},
+				{Name: "admin", Listen: "127.0.0.1:0"},
+			},
+		},
+		Admin: config.AdminConfig{Listeners: []string{"admin"}},
+		Routes: []config.Route{
+			{
+				Name:      "telemetry",
+				Path:      "/telemetry/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL}},
+				Listeners: []string{"devices"},
+			},
+			{
+				Name:      "shared",
+				Path:      "/shared/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL}},
+			},
+		},
+	})
+	tests := []struct {
+		listener int
+		path     string
+		want     int
+	}{
+		{listener: 0, path: "/telemetry/readings", want: http.StatusNoContent},
+		{listener: 1, path: "/telemetry/readings", want: http.StatusNotFound},
+		{listener: 0, path: "/shared/items", want: http.StatusNoContent},
+		{listener: 1, path: "/shared/items", want: http.StatusNoContent},
+		{listener: 0, path: "/_gonk/info", want: http.StatusNotFound},
+		{listener: 1, path: "/_gonk/info", want: http.StatusOK},
+		{listener: 0, path: "/_gonk/live", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[tt.listener].server.Handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.want {
+			t.Fatalf("GET %s on %s = %d, want %d", tt.path, srv.listeners[tt.listener].cfg.Name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestAdminServerSeparatesAdminEndpointsFromDataPlane(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer upstream.Close()
+
+	socketPath := filepath.Join(t.TempDir(), "admin.sock")
+	srv := New(&config.Config{
+		Admin: config.AdminConfig{
+			RequireAuth:  true,
+			Header:       "X-Gonk-Admin-Token",
+			Token:        "admin-secret",
+			AllowedCIDRs: []string{"10.0.0.0/8"},
+			Server:       &config.AdminServerConfig{Enabled: true, Listen: "unix://" + socketPath},
+		},
+		Routes: []config.Route{
+			{
+				Name:      "catch-all",
+				Path:      "/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: upstream.URL}},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/_gonk/info", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	rr := httptest.NewRecorder()
+	srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTeapot {
+		t.Fatalf("data plane /_gonk/info = %d, want the catch-all route's %d", rr.Code, http.StatusTeapot)
+	}
+
+	adminSocket, bindErr := srv.bindAdmin()
+	if bindErr != nil {
+		t.Fatalf("bindAdmin() returned error: %v", bindErr)
+	}
+	go srv.serveAdmin(adminSocket)
+	defer srv.adminServer.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+
+	// The socket skips the CIDR allowlist, but not the token
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://admin/_gonk/info", nil)
+		req.Header.Set("X-Gonk-Admin-Token", "admin-secret")
+		if resp, err = client.Do(req); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("admin socket request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin socket /_gonk/info = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	resp, err = client.Get("http://admin/_gonk/info")
+	if err != nil {
+		t.Fatalf("admin socket request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("admin socket /_gonk/info without token = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminEndpointsAcceptMappedClientCertificates(t *testing.T) {
+	srv := New(&config.Config{
+		Admin: config.AdminConfig{
+			RequireAuth: true,
+			Header:      "X-Gonk-Admin-Token",
+			CertRoles:   map[string]string{"CN=ops-*": "operator"},
+		},
+		Routes: []config.Route{
+			{
+				Name:      "api",
+				Path:      "/api/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: "http://127.0.0.1:1"}},
+			},
+		},
+	})
+
+	tests := []struct {
+		commonName string
+		want       int
+	}{
+		{commonName: "ops-laptop-7", want: http.StatusOK},
+		{commonName: "sensor-12", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.commonName}}
+		req := httptest.NewRequest(http.MethodGet, "/_gonk/info", nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.want {
+			t.Fatalf("/_gonk/info with certificate %s = %d, want %d", tt.commonName, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestAdminCredentialsEnforcePermissions(t *testing.T) {
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+
+	srv := New(&config.Config{
+		Auth: config.AuthConfig{
+			JWT: &config.JWTConfig{Enabled: true, SecretKey: "jwt-secret", Header: "Authorization", Prefix: "Bearer "},
+		},
+		Admin: config.AdminConfig{
+			RequireAuth: true,
+			Header:      "X-Gonk-Admin-Token",
+			Credentials: []config.AdminCredential{
+				{Name: "noc", TokenSHA256: hash("noc-token"), Permissions: []string{"read"}},
+				{Name: "ops", TokenSHA256: hash("ops-token"), Permissions: []string{"read", "cache:write"}},
+				{Name: "oncall-laptop", CertCN: "CN=oncall-*", Permissions: []string{"breaker:write"}},
+				{Name: "sre", Role: "sre", Permissions: []string{"*"}},
+			},
+		},
+		Routes: []config.Route{
+			{
+				Name:      "api",
+				Path:      "/api/*",
+				Protocol:  "http",
+				Upstreams: []config.Upstream{{URL: "http://127.0.0.1:1"}},
+			},
+		},
+	})
+
+	sreToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice", "roles": []string{"sre"}}).SignedString([]byte("jwt-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	oncallCert := &x509.Certificate{Subject: pkix.Name{CommonName: "oncall-3"}}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		cert   *x509.Certificate
+		want   int
+	}{
+		{name: "no credential", method: http.MethodGet, path: "/_gonk/status", want: http.StatusUnauthorized},
+		{name: "unknown token", method: http.MethodGet, path: "/_gonk/status", header: "X-Gonk-Admin-Token", value: "guess", want: http.StatusUnauthorized},
+		{name: "noc reads", method: http.MethodGet, path: "/_gonk/status", header: "X-Gonk-Admin-Token", value: "noc-token", want: http.StatusOK},
+		{name: "noc clears cache", method: http.MethodPost, path: "/_gonk/cache/clear", header: "X-Gonk-Admin-Token", value: "noc-token", want: http.StatusForbidden},
+		{name: "ops clears cache", method: http.MethodPost, path: "/_gonk/cache/clear", header: "X-Gonk-Admin-Token", value: "ops-token", want: http.StatusOK},
+		{name: "noc reads routes", method: http.MethodGet, path: "/_gonk/routes", header: "X-Gonk-Admin-Token", value: "noc-token", want: http.StatusOK},
+		{name: "noc reads routes as written", method: http.MethodGet, path: "/_gonk/config/routes", header: "X-Gonk-Admin-Token", value: "noc-token", want: http.StatusForbidden},
+		{name: "noc reads a route as written", method: http.MethodGet, path: "/_gonk/config/routes/api", header: "X-Gonk-Admin-Token", value: "noc-token", want: http.StatusForbidden},
+		{name: "certificate reads", method: http.MethodGet, path: "/_gonk/status", cert: oncallCert, want: http.StatusForbidden},
+		{name: "jwt role clears cache", method: http.MethodPost, path: "/_gonk/cache/clear", header: "Authorization", value: "Bearer " + sreToken, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		if tt.cert != nil {
+			req.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{tt.cert},
+				VerifiedChains:   [][]*x509.Certificate{{tt.cert}},
+			}
+		}
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.want {
+			t.Fatalf("%s: %s %s = %d, want %d, body = %s", tt.name, tt.method, tt.path, rr.Code, tt.want, rr.Body.String())
+		}
+	}
+
+	for _, want := range []string{
+		"audit action=admin_denied credential=noc permission=cache:write",
+		"audit action=cache_clear credential=ops",
+		"audit action=admin_denied credential=oncall-laptop permission=read",
+		"audit action=cache_clear credential=sre",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Fatalf("logs do not contain %q:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestRouteAdminAPIEditsConfigFile(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `# edge gateway
+admin:
+  require_auth: false
+routes:
+  - name: api # public API
+    path: /api/*
+    upstreams:
+      - url: ${API_UPSTREAM:-` + upstream.URL + `}
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	srv := New(cfg)
+	srv.SetConfigPath(configPath)
+
+	send := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:12345"
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(http.MethodGet, "/_gonk/config/routes", "", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET routes = %d, ETag %s, body %s", rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "${API_UPSTREAM:-") {
+		t.Fatalf("GET routes should return routes as written, got %s", rr.Body.String())
+	}
+
+	devices := `{"name": "devices", "path": "/devices/*", "upstreams": [{"url": "` + upstream.URL + `"}]}`
+	if rr := send(http.MethodPost, "/_gonk/config/routes", "", devices); rr.Code != http.StatusPreconditionRequired {
+		t.Fatalf("POST without If-Match = %d, want %d", rr.Code, http.StatusPreconditionRequired)
+	}
+	if rr := send(http.MethodPost, "/_gonk/config/routes", `"1"`, `{"name": "broken", "path": "/broken/*"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("POST of an invalid route = %d, want %d, body %s", rr.Code, http.StatusBadRequest, rr.Body.String())
+	}
+	if rr := send(http.MethodPost, "/_gonk/config/routes", `"1"`, devices); rr.Code != http.StatusCreated || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("POST route = %d, ETag %s, body %s", rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+	if rr := send(http.MethodPost, "/_gonk/config/routes/devices/upstreams", `"1"`, `{"url": "http://127.0.0.1:1"}`); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("POST with a stale version = %d, want %d", rr.Code, http.StatusPreconditionFailed)
+	}
+	if rr := send(http.MethodGet, "/devices/status", "", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("GET /devices/status = %d, want the new route to serve", rr.Code)
+	}
+
+	if rr := send(http.MethodDelete, "/_gonk/config/routes/api", `"2"`, ""); rr.Code != http.StatusOK {
+		t.Fatalf("DELETE route = %d, body %s", rr.Code, rr.Body.String())
+	}
+	if rr := send(http.MethodGet, "/api/users", "", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("GET /api/users = %d, want the deleted route gone", rr.Code)
+	}
+
+	// Valid config, but the route cannot be set up without its key
+	signed := `{"name": "signed", "path": "/signed/*", "upstreams": [{"url": "` + upstream.URL + `"}],
+		"identity": {"enabled": true, "assertion": {"enabled": true, "algorithm": "RS256", "private_key_file": "/nonexistent/key.pem"}}}`
+	rr = send(http.MethodPost, "/_gonk/config/routes", `"3"`, signed)
+	var failed struct {
+		Version      uint64   `json:"version"`
+		FailedRoutes []string `json:"failed_routes"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &failed); err != nil {
+		t.Fatalf("POST of a route that cannot be set up returned invalid JSON: %s", rr.Body.String())
+	}
+	if rr.Code != http.StatusUnprocessableEntity || failed.Version != 4 || len(failed.FailedRoutes) != 1 || failed.FailedRoutes[0] != "signed" {
+		t.Fatalf("POST of a route that cannot be set up = %d, body %s; want %d naming the route", rr.Code, rr.Body.String(), http.StatusUnprocessableEntity)
+	}
+	if rr := send(http.MethodDelete, "/_gonk/config/routes/signed", rr.Header().Get("ETag"), ""); rr.Code != http.StatusOK {
+		t.Fatalf("DELETE of the route that could not be set up = %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	for _, want := range []string{"# edge gateway", "name: devices", "path: /devices/*"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("config file does not contain %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "name: api") || strings.Contains(string(data), "broken") || strings.Contains(string(data), "signed") {
+		t.Fatalf("config file still has removed or rejected routes:\n%s", data)
+	}
+	if info, err := os.Stat(configPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("config file mode changed: %v, %v", info.Mode(), err)
+	}
+	if _, err := config.Load(configPath); err != nil {
+		t.Fatalf("written config does not load: %v", err)
+	}
+}
+
+func TestConfigHistoryRecordsChangesAndRollsBack(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "gonk.yaml")
+	configContent := `server:
+  history:
+    enabled: true
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: ` + upstream.URL + `
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	srv := New(cfg)
+	srv.SetConfigPath(configPath)
+
+	send := func(method, path, ifMatch, body string, out interface{}) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:12345"
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		if out != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+				t.Fatalf("%s %s returned invalid JSON: %s", method, path, rr.Body.String())
+			}
+		}
+		return rr.Code
+	}
+
+	devices := `{"name": "devices", "path": "/devices/*", "upstreams": [{"url": "` + upstream.URL + `"}]}`
+	if code := send(http.MethodPost, "/_gonk/config/routes", `"1"`, devices, nil); code != http.StatusCreated {
+		t.Fatalf("POST route = %d, want %d", code, http.StatusCreated)
+	}
+
+	var history struct {
+		Version uint64                `json:"version"`
+		History []config.HistoryEntry `json:"history"`
+	}
+	if code := send(http.MethodGet, "/_gonk/config/history", "", "", &history); code != http.StatusOK {
+		t.Fatalf("GET history = %d", code)
+	}
+	if history.Version != 2 || len(history.History) != 2 || history.History[0].Source != ReloadSourceAdminAPI || history.History[1].Source != ReloadSourceStartup {
+		t.Fatalf("history = %+v, want the startup config and the route change", history)
+	}
+
+	var entry config.HistoryEntry
+	if code := send(http.MethodGet, "/_gonk/config/history/2", "", "", &entry); code != http.StatusOK {
+		t.Fatalf("GET history entry = %d", code)
+	}
+	if !strings.Contains(entry.Diff, "+  - name: devices") {
+		t.Fatalf("diff does not show the new route:\n%s", entry.Diff)
+	}
+
+	var rollback struct {
+		Version      uint64 `json:"version"`
+		RolledBackTo uint64 `json:"rolled_back_to"`
+	}
+	if code := send(http.MethodPost, "/_gonk/config/history/1/rollback", "", "", &rollback); code != http.StatusOK {
+		t.Fatalf("POST rollback = %d", code)
+	}
+	if rollback.Version != 3 || rollback.RolledBackTo != 1 {
+		t.Fatalf("rollback = %+v, want version 3 rolled back to 1", rollback)
+	}
+	if code := send(http.MethodGet, "/devices/status", "", "", nil); code != http.StatusNotFound {
+		t.Fatalf("GET /devices/status = %d, want the route rolled back", code)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil || string(data) != configContent {
+		t.Fatalf("config file after rollback = %q, %v; want the original", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, config.DefaultHistoryDir, "0000000003.json")); err != nil {
+		t.Fatalf("rollback was not recorded: %v", err)
+	}
+}
+
+func TestConfigHistoryEntryNeedsReloadPermission(t *testing.T) {
+	sum := sha256.Sum256([]byte("noc-token"))
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	configContent := `server:
+  history:
+    enabled: true
+admin:
+  require_auth: true
+  token: all-access-token
+  credentials:
+    - name: noc
+      token_sha256: ` + hex.EncodeToString(sum[:]) + `
+      permissions: [read]
+routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: http://127.0.0.1:1
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	srv := New(cfg)
+	srv.SetConfigPath(configPath)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		req.Header.Set(cfg.Admin.Header, token)
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("/_gonk/config/history", "noc-token"); rr.Code != http.StatusOK {
+		t.Fatalf("GET history with a read credential = %d, want %d", rr.Code, http.StatusOK)
+	}
+	rr := get("/_gonk/config/history/1", "noc-token")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("GET history entry with a read credential = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if strings.Contains(rr.Body.String(), "all-access-token") {
+		t.Fatalf("read credential saw admin.token: %s", rr.Body.String())
+	}
+	if rr := get("/_gonk/config/history/1", "all-access-token"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "all-access-token") {
+		t.Fatalf("GET history entry with admin.token = %d, body %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReloadUnderTrafficKeepsUnchangedRoutesAndDropsNoRequests(t *testing.T) {
+	newUpstream := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Long enough for requests to span table swaps
+			time.Sleep(2 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	first, second := newUpstream(), newUpstream()
+	defer first.Close()
+	defer second.Close()
+
+	configWith := func(churn string) *config.Config {
+		return &config.Config{
+			Routes: []config.Route{
+				{Name: "stable", Path: "/stable/*", Protocol: "http", Upstreams: []config.Upstream{{URL: first.URL, Weight: 100}}},
+				{Name: "churn", Path: "/churn/*", Protocol: "http", Upstreams: []config.Upstream{{URL: churn, Weight: 100}}},
+			},
+		}
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := New(configWith(first.URL))
+	defer srv.table.Load().rateStore.Close()
+	stable := srv.table.Load().routes["stable"].proxy
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	failures := make(chan string, 100)
+	for i := 0; i < 8; i++ {
+		path := "/stable/ping"
+		if i%2 == 1 {
+			path = "/churn/ping"
+		}
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.RemoteAddr = "127.0.0.1:12345"
+				rr := httptest.NewRecorder()
+				srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+				if rr.Code != http.StatusNoContent {
+					select {
+					case failures <- fmt.Sprintf("GET %s = %d", path, rr.Code):
+					default:
+					}
+				}
+			}
+		}(path)
+	}
+
+	for i := 0; i < 50; i++ {
+		churn := first.URL
+		if i%2 == 0 {
+			churn = second.URL
+		}
+		srv.Reload(configWith(churn), ReloadSourceFileWatch)
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	close(failures)
+
+	for failure := range failures {
+		t.Error(failure)
+	}
+	if got := srv.table.Load().routes["stable"].proxy; got != stable {
+		t.Fatal("unchanged route got a new proxy on reload")
+	}
+	if got := srv.ConfigVersion(); got != 51 {
+		t.Fatalf("config version = %d, want 51", got)
+	}
+}
+
+// closeNotifyingStore reports when the table retiring it closes it
+type closeNotifyingStore struct {
+	middleware.RateLimitStore
+	closed chan struct{}
+}
+
+func (s *closeNotifyingStore) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestRetireWaitsForConfiguredDrainTimeout(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	store := &closeNotifyingStore{closed: make(chan struct{})}
+	old := &routeTable{rateStore: store}
+	old.inFlight.Add(1) // a request that does not finish
+
+	start := time.Now()
+	retire(old, &routeTable{}, 200*time.Millisecond)
+
+	select {
+	case <-store.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retired table was not closed after the drain timeout")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("retired table closed after %s, before the drain timeout", elapsed)
+	}
+}
+
+func TestReloadAppliesChangedCircuitBreakerThresholds(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	configWith := func(maxFailures int) *config.Config {
+		return &config.Config{
+			Routes: []config.Route{
+				{
+					Name:      "plc",
+					Path:      "/plc/*",
+					Protocol:  "http",
+					Upstreams: []config.Upstream{{URL: deadURL, Weight: 100}},
+					CircuitBreaker: &config.CircuitBreakerConfig{
+						Enabled:         true,
+						MaxFailures:     maxFailures,
+						ResetTimeout:    time.Hour,
+						HalfOpenMaxReqs: 1,
+					},
+				},
+			},
+		}
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	srv := New(configWith(5))
+	defer srv.table.Load().rateStore.Close()
+	srv.Reload(configWith(1), ReloadSourceFileWatch)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var status routeCircuitBreakerStatus
+	if err := json.Unmarshal(serve("/_gonk/circuit-breakers/plc").Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode circuit breaker status: %v", err)
+	}
+	if status.CircuitBreaker == nil || status.CircuitBreaker.MaxFailures != 1 {
+		t.Fatalf("status = %+v, want the reloaded max_failures of 1", status)
+	}
+
+	serve("/plc/coils")
+	if rr := serve("/plc/coils"); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status after one failure = %d, want %d from the reloaded breaker", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestReloadEndpointDryRunAndLastReloadStatus(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	configPath := filepath.Join(t.TempDir(), "gonk.yaml")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test config: %v", err)
+		}
+	}
+	writeConfig(`routes:
+  - name: api
+    path: /api/*
+    upstreams:
+      - url: ` + upstream.URL + `
+  - name: legacy
+    path: /legacy/*
+    upstreams:
+      - url: ` + upstream.URL + `
+`)
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+
+	srv := New(cfg)
+	srv.SetConfigPath(configPath)
+
+	send := func(method, path string, out interface{}) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:12345"
+		rr := httptest.NewRecorder()
+		srv.listeners[0].server.Handler.ServeHTTP(rr, req)
+		if out != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+				t.Fatalf("%s %s returned invalid JSON: %s", method, path, rr.Body.String())
+			}
+		}
+		return rr.Code
+	}
+
+	writeConfig(`routes:
+  - name: api
+    path: /api/v2/*
+    upstreams:
+      - url: ` + upstream.URL + `
+  - name: devices
+    path: /devices/*
+    upstreams:
+      - url: ` + upstream.URL + `
+`)
+
+	var dryRun struct {
+		Added   []string `json:"added"`
+		Changed []string `json:"changed"`
+		Removed []string `json:"removed"`
+	}
+	if code := send(http.MethodPost, "/_gonk/reload?dry_run=true", &dryRun); code != http.StatusOK {
+		t.Fatalf("POST dry run = %d", code)
+	}
+	if fmt.Sprint(dryRun.Added, dryRun.Changed, dryRun.Removed) != "[devices] [api] [legacy]" {
+		t.Fatalf("dry run = %+v, want devices added, api changed and legacy removed", dryRun)
+	}
+	if code := send(http.MethodGet, "/legacy/status", nil); code != http.StatusNoContent {
+		t.Fatalf("GET /legacy/status after dry run = %d, want the running config untouched", code)
+	}
+
+	goodConfig, _ := os.ReadFile(configPath)
+	writeConfig("routes: [\n")
+
+	var failed reloadStatus
+	if code := send(http.MethodPost, "/_gonk/reload", &failed); code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST reload of a broken file = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	var status struct {
+		ConfigVersion uint64        `json:"config_version"`
+		LastReload    *reloadStatus `json:"last_reload"`
+	}
+	send(http.MethodGet, "/_gonk/status", &status)
+	if status.LastReload == nil || status.LastReload.Status != ReloadStatusFailed || status.LastReload.Error == "" || status.ConfigVersion != 1 {
+		t.Fatalf("status after failed reload = %+v, want a failed last reload on version 1", status.LastReload)
+	}
+	if code := send(http.MethodGet, "/legacy/status", nil); code != http.StatusNoContent {
+		t.Fatalf("GET /legacy/status after failed reload = %d, want the running config kept", code)
+	}
+
+	writeConfig(string(goodConfig))
+	var reloaded reloadStatus
+	if code := send(http.MethodPost, "/_gonk/reload", &reloaded); code != http.StatusOK {
+		t.Fatalf("POST reload = %d", code)
+	}
+	if reloaded.Status != ReloadStatusOK || reloaded.ConfigVersion != 2 || len(reloaded.Added) != 1 {
+		t.Fatalf("reload = %+v, want version 2 with one added route", reloaded)
+	}
+	if code := send(http.MethodGet, "/devices/status", nil); code != http.StatusNoContent {
+		t.Fatalf("GET /devices/status = %d, want the new route served", code)
+	}
+	if code := send(http.MethodGet, "/legacy/status", nil); code != http.StatusNotFound {
+		t.Fatalf("GET /legacy/status = %d, want the removed route gone", code)
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/JustVugg/gonk/internal/clientip"
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+// Environment of a process started by an upgrade
+const (
+	// inheritedListenersEnv lists key=address for each socket passed on,
+	// in the order of the files from fd 3
+	inheritedListenersEnv = "GONK_INHERITED_LISTENERS"
+	// upgradeReadyEnv names the fd the new process writes to once it serves
+	upgradeReadyEnv = "GONK_UPGRADE_READY_FD"
+)
+
+// upgradeReadyTimeout bounds how long the new process may take to load its
+// config and bind its sockets
+const upgradeReadyTimeout = 30 * time.Second
+
+// adminSocketKey names the admin server's socket among the passed sockets
+const adminSocketKey = "admin"
+
+var errUpgradeUnsupported = errors.New("binary upgrade is only supported on Linux")
+
+// boundSocket is a listening socket and the configured address it was
+// opened for. Sockets passed on by an upgrade arrive as boundSockets too.
+type boundSocket struct {
+	address  string
+	listener net.Listener
+}
+
+func listenerSocketKey(name string) string {
+	return "listener:" + name
+}
+
+// parseInheritedListeners pairs the files passed by an upgrade with their
+// entries in spec
+func parseInheritedListeners(spec string, files []*os.File) (map[string]boundSocket, error) {
+	inherited := make(map[string]boundSocket)
+	if spec == "" {
+		return inherited, nil
+	}
+
+	entries := strings.Split(spec, ",")
+	if len(entries) != len(files) {
+		return nil, fmt.Errorf("%s lists %d sockets, %d were passed", inheritedListenersEnv, len(entries), len(files))
+	}
+	for i, entry := range entries {
+		key, address, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q", inheritedListenersEnv, entry)
+		}
+		ln, err := net.FileListener(files[i])
+		// FileListener works on a copy of the descriptor
+		files[i].Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %s: %w", key, err)
+		}
+		inherited[key] = boundSocket{address: address, listener: ln}
+	}
+	return inherited, nil
+}
+
+// bind returns the socket for key: the one passed on by the process this one
+// replaced if it has the same address, or a new one from listen. Bound
+// sockets are kept for the next upgrade.
+func (s *Server) bind(key, address string, listen func() (net.Listener, error)) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ln net.Listener
+	if inherited, ok := s.inherited[key]; ok {
+		delete(s.inherited, key)
+		if inherited.address == address {
+			log.Printf("♻️  Serving %s on the socket passed on by the previous process", address)
+			ln = inherited.listener
+		} else {
+			inherited.listener.Close()
+		}
+	}
+	if ln == nil {
+		var err error
+		if ln, err = listen(); err != nil {
+			return nil, err
+		}
+	}
+	s.bound[key] = boundSocket{address: address, listener: ln}
+	return ln, nil
+}
+
+// closeInherited closes passed sockets the config no longer listens on
+func (s *Server) closeInherited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, inherited := range s.inherited {
+		log.Printf("Closing inherited socket %s on %s, which the config no longer uses", key, inherited.address)
+		inherited.listener.Close()
+		delete(s.inherited, key)
+	}
+}
+
+// closeBound closes sockets bound before a later one failed to bind
+func (s *Server) closeBound() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, bound := range s.bound {
+		bound.listener.Close()
+		delete(s.bound, key)
+	}
+}
+
+// notifyUpgradeReady tells the process that started this one that every
+// socket is bound, so it can stop accepting connections
+func (s *Server) notifyUpgradeReady() {
+	if s.upgradeReady == nil {
+		return
+	}
+	if _, err := s.upgradeReady.Write([]byte{1}); err != nil {
+		log.Printf("⚠️  Failed to report readiness to the previous process: %v", err)
+	}
+	s.upgradeReady.Close()
+	s.upgradeReady = nil
+}
+
+// Upgrade starts the gonk binary again with the same arguments and passes
+// it the listening sockets. Once the new process serves, this one stops
+// accepting connections, drains the open ones for up to
+// server.drain_timeout, and Start returns. It returns the new process ID.
+func (s *Server) Upgrade() (int, error) {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+
+	select {
+	case <-s.handoff:
+		return 0, errors.New("an upgraded process has already taken over")
+	default:
+	}
+
+	pid, err := s.startUpgrade()
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("🔁 Upgraded process %d took over the listening sockets", pid)
+	notifySystemd(fmt.Sprintf("MAINPID=%d", pid))
+	close(s.handoff)
+	return pid, nil
+}
+
+// waitForHijacked waits until the requests running on the current table have
+// finished, or until deadline. Shutdown does not wait for hijacked
+// connections such as WebSockets, which the table still counts.
+func (s *Server) waitForHijacked(deadline time.Time) {
+	t := s.table.Load()
+	for t.inFlight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if n := t.inFlight.Load(); n > 0 {
+		log.Printf("⚠️  Closing %d connection(s) still open after the drain timeout", n)
+	}
+}
+
+func (s *Server) registerUpgradeEndpoints(admin *mux.Router) {
+	admin.Handle("/_gonk/upgrade", s.adminMiddleware(config.AdminPermissionReload, http.HandlerFunc(s.upgradeHandler))).Methods("POST").Name("gonk-upgrade")
+}
+
+func (s *Server) upgradeHandler(w http.ResponseWriter, r *http.Request) {
+	pid, err := s.Upgrade()
+	if errors.Is(err, errUpgradeUnsupported) {
+		writeJSONError(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("upgrade failed, still serving: %v", err))
+		return
+	}
+
+	log.Printf(
+		"audit action=binary_upgrade pid=%d credential=%s client_ip=%s",
+		pid,
+		adminCredentialName(r),
+		clientip.FromRequest(r),
+	)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "upgraded",
+		"pid":           pid,
+		"drain_timeout": s.currentConfig().Server.DrainTimeout.String(),
+	})
+}
@@ -0,0 +1,190 @@
+//go:build linux
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// inheritFromEnv picks up the sockets and readiness pipe passed by the
+// process this one replaced. The variables are cleared, so they do not leak
+// into a later upgrade.
+func (s *Server) inheritFromEnv() {
+	spec := os.Getenv(inheritedListenersEnv)
+	readyFD := os.Getenv(upgradeReadyEnv)
+	os.Unsetenv(inheritedListenersEnv)
+	os.Unsetenv(upgradeReadyEnv)
+
+	if spec != "" {
+		count := strings.Count(spec, ",") + 1
+		files := make([]*os.File, count)
+		for i := range files {
+			files[i] = os.NewFile(uintptr(3+i), "inherited-socket-"+strconv.Itoa(i))
+		}
+		inherited, err := parseInheritedListeners(spec, files)
+		if err != nil {
+			log.Printf("❌ Ignoring sockets passed by the previous process: %v", err)
+		} else {
+			s.inherited = inherited
+		}
+	}
+
+	if fd, err := strconv.Atoi(readyFD); err == nil {
+		s.upgradeReady = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+}
+
+// startUpgrade runs the binary again with the bound sockets, and waits until
+// it reports that it serves
+func (s *Server) startUpgrade() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the gonk binary: %w", err)
+	}
+
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.bound))
+	for key := range s.bound {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		bound := s.bound[key]
+		file, err := socketFile(key, bound.listener)
+		if err != nil {
+			s.mu.RUnlock()
+			return 0, fmt.Errorf("socket %s: %w", key, err)
+		}
+		files = append(files, file)
+		entries = append(entries, key+"="+bound.address)
+	}
+	s.mu.RUnlock()
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyRead.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		inheritedListenersEnv+"="+strings.Join(entries, ","),
+		upgradeReadyEnv+"="+strconv.Itoa(3+len(files)),
+	)
+	cmd.ExtraFiles = append(append([]*os.File(nil), files...), readyWrite)
+	err = cmd.Start()
+	// The new process holds its own copy; closing ours lets the read below
+	// end if it exits
+	readyWrite.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start %s: %w", executable, err)
+	}
+	go cmd.Wait()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyRead.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return 0, errors.New("the new process exited before it was serving; see its log")
+		}
+	case <-time.After(upgradeReadyTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("the new process was not serving after %s", upgradeReadyTimeout)
+	}
+
+	// The new process serves the admin socket now; closing ours must not
+	// remove its file
+	s.mu.RLock()
+	for _, bound := range s.bound {
+		if unixListener, ok := bound.listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	s.mu.RUnlock()
+
+	return cmd.Process.Pid, nil
+}
+
+// socketFile duplicates the listening socket of ln to pass it on. Unlike
+// the File method of the net listeners, the result does not put the socket,
+// which this process still accepts on, into blocking mode when exec asks for
+// its descriptor.
+func socketFile(key string, ln net.Listener) (*os.File, error) {
+	conn, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("socket %s cannot be passed on", key)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("socket %s: %w", key, err)
+	}
+	var dup uintptr
+	var errno syscall.Errno
+	if err := raw.Control(func(fd uintptr) {
+		dup, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_DUPFD_CLOEXEC, 0)
+	}); err != nil {
+		return nil, fmt.Errorf("socket %s: %w", key, err)
+	}
+	if errno != 0 {
+		return nil, fmt.Errorf("socket %s: %w", key, errno)
+	}
+	return os.NewFile(dup, key), nil
+}
+
+// HandleUpgradeSignal upgrades the binary on SIGUSR2
+func (s *Server) HandleUpgradeSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			log.Println("SIGUSR2 received, upgrading the gonk binary")
+			if _, err := s.Upgrade(); err != nil {
+				log.Printf("❌ Upgrade failed, still serving: %v", err)
+			}
+		}
+	}()
+}
+
+// notifySystemd reports state to systemd when it runs gonk as a Type=notify
+// service. Telling it the new MAINPID on an upgrade keeps it from stopping
+// the service when this process exits.
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("⚠️  Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("⚠️  Failed to notify systemd: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JustVugg/gonk/internal/config"
+)
+
+func TestInheritedSocketIsServedAndDrainedOnHandoff(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	// Stands in for the socket the previous process passes on. It stays
+	// open, so binding the address again would fail.
+	previous, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer previous.Close()
+	address := previous.Addr().String()
+	file, err := previous.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get socket file: %v", err)
+	}
+	inherited, err := parseInheritedListeners(listenerSocketKey("default")+"="+address, []*os.File{file})
+	if err != nil {
+		t.Fatalf("parseInheritedListeners() returned error: %v", err)
+	}
+
+	srv := New(&config.Config{
+		Server: config.ServerConfig{Listen: address, DrainTimeout: 5 * time.Second},
+		Routes: []config.Route{
+			{Name: "slow", Path: "/slow/*", Protocol: "http", Upstreams: []config.Upstream{{URL: upstream.URL}}},
+		},
+	})
+	srv.inherited = inherited
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(context.Background()) }()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	slow := make(chan int, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			resp, err := client.Get("http://" + address + "/slow/stream")
+			if err == nil {
+				resp.Body.Close()
+				slow <- resp.StatusCode
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		slow <- 0
+	}()
+
+	select {
+	case <-received:
+	case err := <-done:
+		t.Fatalf("Start() returned %v, want it to serve the inherited socket", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("request never reached the upstream")
+	}
+
+	// As if an upgraded process had reported ready
+	close(srv.handoff)
+
+	select {
+	case err := <-done:
+		t.Fatalf("Start() returned %v while a request was still running", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-slow; code != http.StatusNoContent {
+		t.Fatalf("request running during the handoff = %d, want %d", code, http.StatusNoContent)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start() returned error after draining: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() did not return after draining")
+	}
+
+	// The socket outlives this server for whoever else holds it
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := previous.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("socket closed by the handoff: %v", err)
+	}
+	conn.Close()
+	if err := <-accepted; err != nil {
+		t.Fatalf("Accept() on the passed socket returned error: %v", err)
+	}
+}
+
+func TestPassedSocketKeepsListenerNonBlocking(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	file, err := socketFile(listenerSocketKey("default"), ln)
+	if err != nil {
+		t.Fatalf("socketFile() returned error: %v", err)
+	}
+	defer file.Close()
+	// What exec does with each passed file
+	file.Fd()
+
+	raw, err := ln.(*net.TCPListener).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn() returned error: %v", err)
+	}
+	var flags uintptr
+	raw.Control(func(fd uintptr) {
+		flags, _, _ = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	})
+	if flags&syscall.O_NONBLOCK == 0 {
+		t.Fatal("the listener is in blocking mode after passing its socket, so Close would wait for the next connection")
+	}
+}
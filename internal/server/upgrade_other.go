@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+// inheritFromEnv does nothing: sockets are only passed on on Linux
+func (s *Server) inheritFromEnv() {}
+
+func (s *Server) startUpgrade() (int, error) {
+	return 0, errUpgradeUnsupported
+}
+
+// HandleUpgradeSignal does nothing: binary upgrades need Linux
+func (s *Server) HandleUpgradeSignal() {}
+
+func notifySystemd(state string) {}
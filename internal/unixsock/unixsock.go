@@ -0,0 +1,49 @@
+package unixsock
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Scheme is the upstream URL scheme for Unix domain sockets, e.g. unix:///run/svc.sock.
+// The whole URL path is the socket path; request paths come from the client request.
+const Scheme = "unix"
+
+// Host is the Host header sent to socket upstreams, which have no network address
+const Host = "localhost"
+
+// IsUnix reports whether the upstream URL points at a Unix domain socket
+func IsUnix(u *url.URL) bool {
+	return u != nil && u.Scheme == Scheme
+}
+
+// Path returns the socket path of a unix:// URL. Both unix:///abs/path and
+// the relative unix:rel/path forms are accepted.
+func Path(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Path
+}
+
+// Dialer returns a dial function that ignores the requested address and
+// connects to the socket instead
+func Dialer(socketPath string, timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socketPath)
+	}
+}
+
+// NewTransport returns an HTTP transport for a socket with the settings of
+// http.DefaultTransport. Callers keep it to pool connections across requests,
+// and close its idle connections once they stop using the socket.
+func NewTransport(socketPath string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = Dialer(socketPath, 30*time.Second)
+	transport.Proxy = nil
+	return transport
+}